@@ -3,7 +3,6 @@ package BotExt
 import (
 	"errors"
 	"fmt"
-	"strconv"
 
 	"go.uber.org/zap"
 	tele "gopkg.in/telebot.v3"
@@ -17,13 +16,17 @@ var ContinueState = errors.New("__CONTINUE__")
 type FSM struct {
 	stateMap map[string]*State
 	menus    *InlineMenusType
+	store    StateStore
 }
 
 // NewFiniteStateMachine is a constructor for FSM. One can create separate FSMs for different user groups (admins, users...)
-func NewFiniteStateMachine(ims *InlineMenusType) *FSM {
+// store keeps states and state variables, menus keep their message ids in it too
+func NewFiniteStateMachine(ims *InlineMenusType, store StateStore) *FSM {
+	ims.store = store
 	return &FSM{
 		stateMap: make(map[string]*State),
 		menus:    ims,
+		store:    store,
 	}
 }
 
@@ -66,9 +69,12 @@ func (f *FSM) Trigger(c tele.Context, stateName string, byMenu ...string) {
 		logger.Error("no such state", zap.Int64("UserID", c.Sender().ID), zap.String("stateName", stateName))
 		return
 	}
+	// menu is remembered per user: the state is shared by all users, and they can enter it in different ways
+	menuName := ""
 	if len(byMenu) != 0 {
-		state.menuTrigger = byMenu[0]
+		menuName = byMenu[0]
 	}
+	f.SetStateVar(c.Sender().ID, menuTriggerVar(stateName), menuName)
 	state.Trigger(c)
 }
 
@@ -85,9 +91,9 @@ func (f *FSM) Update(c tele.Context) {
 	state.Update(c)
 }
 
-// GetCurrentState extracts current state from the database
+// GetCurrentState extracts current state from the store
 func (f *FSM) GetCurrentState(c tele.Context) string {
-	return getState(c.Sender().ID)
+	return f.getState(c.Sender().ID)
 }
 
 // State definition
@@ -118,16 +124,22 @@ type State struct {
 
 	KeepVarsOnQuit bool
 
-	fsm         *FSM
-	next        string
-	menuTrigger string // "" if no menu
+	fsm  *FSM
+	next string
+}
+
+// internalVarPrefix marks state variables used by FSM itself, they are hidden from GetStateVars
+const internalVarPrefix = "__"
 
+// menuTriggerVar is a state variable with name of menu that triggered the state, "" if no menu
+func menuTriggerVar(stateName string) string {
+	return internalVarPrefix + "menu:" + stateName
 }
 
 // Trigger is a method to start a State for specific user.
 func (s *State) Trigger(c tele.Context) {
 	userID := c.Sender().ID
-	setState(userID, s.Name)
+	s.fsm.setState(userID, s.Name)
 	var err error
 	if s.OnTriggerExtra != nil {
 		if len(s.OnTriggerExtra) == 1 {
@@ -135,9 +147,7 @@ func (s *State) Trigger(c tele.Context) {
 			switch ote := s.OnTriggerExtra[0].(type) {
 			case string:
 				_ = c.Send(s.OnTrigger)
-				oldMsgID, _ := getMessageID(userID)
 				err = s.fsm.menus.Show(c, ote)
-				setMessageID(userID, oldMsgID)
 			default:
 				err = c.Send(s.OnTrigger, ote)
 			}
@@ -189,18 +199,13 @@ func (s *State) Update(c tele.Context) {
 				logger.Error("can't send manipulator2", zap.Int64("UserID", c.Sender().ID), zap.Error(err2))
 			}
 			logger.Error("can't send manipulator", zap.Int64("UserID", c.Sender().ID), zap.Error(err))
-			ResetState(c.Sender().ID, s.KeepVarsOnQuit)
+			s.fsm.ResetState(c.Sender().ID, s.KeepVarsOnQuit)
 			return
 		}
 	}
 
-	if s.menuTrigger != "" {
-		menu := s.fsm.menus.GetInlineMenu(s.menuTrigger)
-		if msgID, ok := getMessageID(c.Sender().ID); ok {
-			menu.Update(c, strconv.Itoa(msgID))
-		} else {
-			logger.Error("can't fetch menuMessageID from db", zap.Int64("UserID", c.Sender().ID), zap.String("state", s.Name))
-		}
+	if menuName, _ := s.fsm.GetStateVar(c.Sender().ID, menuTriggerVar(s.Name)); menuName != "" {
+		s.fsm.menus.Update(c, menuName)
 	}
 
 	if s.OnSuccess != nil {
@@ -217,7 +222,7 @@ func (s *State) Update(c tele.Context) {
 	}
 
 	if s.next == "" {
-		ResetState(c.Sender().ID, s.KeepVarsOnQuit)
+		s.fsm.ResetState(c.Sender().ID, s.KeepVarsOnQuit)
 	} else {
 		s.fsm.Trigger(c, s.next)
 	}
@@ -13,6 +13,7 @@ import (
 // InlineMenusType contains logic for all menus in "separate namespace"
 type InlineMenusType struct {
 	menus map[string]*InlineMenu
+	store StateStore // set by NewFiniteStateMachine
 }
 
 // NewInlineMenus - constructor for InlineMenusType
@@ -39,7 +40,7 @@ func (ims *InlineMenusType) Update(c tele.Context, name string) {
 		logger.Error("can't find inline menu", zap.Int64("userID", userID), zap.String("menuName", name))
 		return
 	}
-	if msgID, ok := getMessageID(userID); ok {
+	if msgID, ok := ims.getMessageID(userID, name); ok {
 		menu.Update(c, strconv.Itoa(msgID))
 	} else {
 		logger.Error("can't menu message id from db", zap.Int64("userID", userID), zap.String("menuName", name))
@@ -51,7 +52,8 @@ func (ims *InlineMenusType) RegisterMenu(bot *tele.Bot, menu *InlineMenu) error
 	if _, ok := ims.menus[menu.Name]; ok {
 		return fmt.Errorf("InlineMenusType.RegisterMenu: menu '%s' already registerd", menu.Name)
 	}
-	menu.construct(bot)
+	menu.registerHandlers(bot)
+	menu.registered = true
 	ims.menus[menu.Name] = menu
 	return nil
 }
@@ -63,14 +65,17 @@ func (ims *InlineMenusType) Show(c tele.Context, menuName string) error {
 	if !ok {
 		return fmt.Errorf("InlineMenusType.Show: menu %s is not registered", menuName)
 	}
-	// current context - pressed ReplyMenu button, so next one - inline menu TODO - fix this
-	setMessageID(c.Sender().ID, c.Message().ID+1)
-
-	if m := menu.bake(c); m == nil {
+	m := menu.bake(c)
+	if m == nil {
 		return nil
-	} else {
-		return c.Send(menu.header, m)
 	}
+	msg, err := c.Bot().Send(c.Recipient(), menu.header, m)
+	if err != nil {
+		return fmt.Errorf("InlineMenusType.Show: %w", err)
+	}
+	// several menus can be shown to user at the same time, each one is updated in its own message
+	ims.setMessageID(c.Sender().ID, menuName, msg.ID)
+	return nil
 }
 
 // InlineMenu implementation
@@ -93,6 +98,9 @@ var NoButtons = errors.New("__NO_ROWS__")
 // This map is used in InlineMenuTextSetter to insert data in specific format, in specific place
 // textSetters - specific setter of dynamic content for every button. Uses InlineMenuTextSetter defined in button.
 // btnTemplates - array of buttons to be rendered
+//
+// Buttons are added before RegisterMenu and are read-only afterwards: every render builds its own
+// telebot.ReplyMarkup, so menu can be shown to several users at the same time
 type InlineMenu struct {
 	Name            string
 	header          string
@@ -104,7 +112,7 @@ type InlineMenu struct {
 	textSetters  map[string]InlineMenuTextSetter
 	btnTemplates []*InlineButtonTemplate
 
-	menuCarcass *tele.ReplyMarkup
+	registered bool // buttons can't be changed after RegisterMenu
 }
 
 // TODO: inlineMenu, dynamicInlineMenu => interface
@@ -129,22 +137,26 @@ func NewDynamicInlineMenu(menuName, menuHeader string, maxButtonsInRow int, fetc
 	}
 }
 
-// AddButtons adds concrete buttons into InlineMenu
+// AddButtons sets concrete buttons of InlineMenu. It must be called before RegisterMenu:
+// templates are shared by every render, so changing them afterwards panics
 func (im *InlineMenu) AddButtons(buttons []*InlineButtonTemplate) {
-	im.PurgeButtons()
+	if im.registered {
+		panic(fmt.Errorf("InlineMenu.AddButtons: menu '%s' is registered already", im.Name))
+	}
+	im.purgeButtons()
 	for _, button := range buttons {
-		im.AddButton(button)
+		im.addButton(button)
 	}
 }
 
-// PurgeButtons clears all possible dynamic content
-func (im *InlineMenu) PurgeButtons() {
+// purgeButtons clears all possible dynamic content
+func (im *InlineMenu) purgeButtons() {
 	im.btnTemplates = make([]*InlineButtonTemplate, 0)
 	im.textSetters = make(map[string]InlineMenuTextSetter)
 }
 
-// AddButton adds only one button into InlineMenu
-func (im *InlineMenu) AddButton(button *InlineButtonTemplate) {
+// addButton adds only one button into InlineMenu
+func (im *InlineMenu) addButton(button *InlineButtonTemplate) {
 	button.belongsToMenu = im
 
 	// store dynamic content paste functions in a map, process only functions.
@@ -172,114 +184,110 @@ func (im *InlineMenu) Update(c tele.Context, msgID string) {
 	}
 }
 
-func (im *InlineMenu) construct(b *tele.Bot) {
-	im.menuCarcass = &tele.ReplyMarkup{}
+// registerHandlers binds handlers of buttons with OnClick function. It is done once, when menu is registered
+func (im *InlineMenu) registerHandlers(b *tele.Bot) {
+	for _, button := range im.btnTemplates {
+		if f, ok := button.OnClick.(func(tele.Context) error); ok {
+			btn := tele.Btn{Unique: button.Unique}
+			b.Handle(&btn, f)
+		}
+	}
+}
+
+// render builds new ReplyMarkup from templates. contentMap is used by textSetters, nil - no dynamic content
+func (im *InlineMenu) render(c tele.Context, templates []*InlineButtonTemplate, contentMap map[string]string) *tele.ReplyMarkup {
+	markup := &tele.ReplyMarkup{}
 
 	var row []tele.Btn
 	rows := make([]tele.Row, 0)
-	for i, button := range im.btnTemplates {
-		// fill static text in button and create handler
-		bakedButton := im.manageButton(b, button)
-
+	for i, button := range templates {
 		// button placement
 		if (i%im.maxButtonsInRow == 0) || button.Unique == RowSplitterButton {
 			if len(row) != 0 {
-				rows = append(rows, im.menuCarcass.Row(row...))
+				rows = append(rows, markup.Row(row...))
 			}
 			row = make([]tele.Btn, 0)
 		}
 		if button.Unique != RowSplitterButton {
-			row = append(row, bakedButton)
+			row = append(row, im.renderButton(c, markup, button, contentMap))
 		}
 	}
 	// if there are some unprocessed buttons - place them into new row
 	if len(row) != 0 {
-		rows = append(rows, im.menuCarcass.Row(row...))
+		rows = append(rows, markup.Row(row...))
 	}
-	im.menuCarcass.Inline(rows...)
+	markup.Inline(rows...)
+	return markup
 }
 
-// dynamicBake uses buttonFetcher (NewDynamicInlineMenu) to extract buttons from database. key - id, value - name
-func (im *InlineMenu) dynamicBake(c tele.Context) error {
-	if im.buttonFetcher == nil {
-		return nil
+// renderButton fills text of the button: static one or from textSetter
+func (im *InlineMenu) renderButton(c tele.Context, markup *tele.ReplyMarkup, button *InlineButtonTemplate,
+	contentMap map[string]string) tele.Btn {
+	text, ok := button.TextOnCreation.(string)
+	if !ok {
+		text = "-" // empty string is not allowed
+	}
+	if f, ok := im.textSetters[button.Unique]; ok && contentMap != nil {
+		content, err := f(c, contentMap)
+		if err != nil {
+			logger.Error("can't change val for button", zap.Int64("UserID", c.Sender().ID), zap.String("menuName", im.Name), zap.Error(err))
+		}
+		text = content
+	}
+
+	switch t := button.OnClick.(type) {
+	case func(tele.Context) error:
+		return markup.Data(text, button.Unique, "\f"+button.Unique)
+	case string:
+		return markup.Data(t, button.Unique, im.Name)
 	}
+	return tele.Btn{}
+}
 
+// dynamicTemplates uses buttonFetcher (NewDynamicInlineMenu) to extract buttons from database. key - id, value - name
+func (im *InlineMenu) dynamicTemplates(c tele.Context) ([]*InlineButtonTemplate, error) {
 	btnMap, err := im.buttonFetcher(c)
 	if err != nil {
 		if err == NoButtons {
-			return NoButtons
+			return nil, NoButtons
 		}
-		return fmt.Errorf("UpdateButtons: %w", err)
+		return nil, fmt.Errorf("dynamicTemplates: %w", err)
 	}
 
-	im.PurgeButtons()
-
+	var templates []*InlineButtonTemplate
 	if btnMap != nil {
 		for pair := btnMap.Oldest(); pair != nil; pair = pair.Next() {
-			im.AddButton(&InlineButtonTemplate{
+			templates = append(templates, &InlineButtonTemplate{
 				Unique:         pair.Key,
 				TextOnCreation: pair.Value,
 				OnClick:        pair.Value,
+				belongsToMenu:  im,
 			})
 		}
 	}
-
-	im.construct(c.Bot())
-
-	return nil
+	return templates, nil
 }
 
+// bake renders user-specific menu, nil if dynamic menu has no buttons
 func (im *InlineMenu) bake(c tele.Context) *tele.ReplyMarkup {
-	if im.dataFetcher == nil {
-		err := im.dynamicBake(c)
+	if im.buttonFetcher != nil {
+		templates, err := im.dynamicTemplates(c)
+		if err == NoButtons {
+			return nil
+		}
 		if err != nil {
-			if err == NoButtons {
-				return nil
-			}
-			logger.Error("can't dynamicBake", zap.Int64("UserID", c.Sender().ID), zap.String("menuName", im.Name), zap.Error(err))
+			logger.Error("can't fetch buttons", zap.Int64("UserID", c.Sender().ID), zap.String("menuName", im.Name), zap.Error(err))
 		}
-		return im.menuCarcass
+		return im.render(c, templates, nil)
+	}
+	if im.dataFetcher == nil {
+		return im.render(c, im.btnTemplates, nil)
 	}
 	dynamicContentMap, err := im.dataFetcher(c)
 	if err != nil {
 		logger.Error("can't fetch data from db", zap.Int64("UserID", c.Sender().ID), zap.String("menuName", im.Name), zap.Error(err))
 	}
-
-	if dynamicContentMap == nil {
-		return im.menuCarcass
-	}
-
-	for i, row := range im.menuCarcass.InlineKeyboard {
-		for j, btn := range row {
-			f, ok := im.textSetters[btn.Unique]
-			if !ok {
-				continue // static info had been placed already
-			}
-			content, err := f(c, dynamicContentMap)
-			if err != nil {
-				logger.Error("can't change val for button", zap.Int64("UserID", c.Sender().ID), zap.String("menuName", im.Name), zap.Error(err))
-			}
-			im.menuCarcass.InlineKeyboard[i][j].Text = content
-		}
-	}
-	return im.menuCarcass
-}
-
-func (im *InlineMenu) manageButton(b *tele.Bot, button *InlineButtonTemplate) tele.Btn {
-	staticText, ok := button.TextOnCreation.(string) // don't panic on failed type assertion (dynamic content is processed later)
-	if !ok {
-		staticText = "-" // empty string is not allowed
-	}
-	var bakedButton tele.Btn
-	switch t := button.OnClick.(type) {
-	case func(tele.Context) error:
-		bakedButton = im.menuCarcass.Data(staticText, button.Unique, "\f"+button.Unique)
-		b.Handle(&bakedButton, t)
-	case string:
-		bakedButton = im.menuCarcass.Data(t, button.Unique, im.Name)
-	}
-	return bakedButton
+	return im.render(c, im.btnTemplates, dynamicContentMap)
 }
 
 // InlineButtonTemplate implementation
@@ -0,0 +1,106 @@
+package BotExt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	om "github.com/wk8/go-ordered-map/v2"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeContext is a telebot.Context of specific user, only Sender is implemented
+type fakeContext struct {
+	tele.Context
+	userID int64
+}
+
+func (c fakeContext) Sender() *tele.User {
+	return &tele.User{ID: c.userID}
+}
+
+// TestInlineMenuConcurrentRender must be run with -race: every user renders the same menus at the same time
+func TestInlineMenuConcurrentRender(t *testing.T) {
+	logger = zap.NewNop()
+	const (
+		users   = 50
+		renders = 50
+		buttons = 7
+	)
+
+	dynamicMenu := NewDynamicInlineMenu("dynamic", "header", 3,
+		func(c tele.Context) (*om.OrderedMap[string, string], error) {
+			btnMap := om.New[string, string]()
+			for i := 0; i < buttons; i++ {
+				btnMap.Set(fmt.Sprintf("%d-%d", c.Sender().ID, i), fmt.Sprintf("user %d", c.Sender().ID))
+			}
+			return btnMap, nil
+		})
+	dataMenu := NewInlineMenu("data", "header", 2,
+		func(c tele.Context) (map[string]string, error) {
+			return map[string]string{"user": fmt.Sprint(c.Sender().ID)}, nil
+		})
+	dataMenu.AddButtons([]*InlineButtonTemplate{
+		{
+			Unique: "userButton",
+			TextOnCreation: func(c tele.Context, data map[string]string) (string, error) {
+				return data["user"], nil
+			},
+			OnClick: func(tele.Context) error { return nil },
+		},
+		{Unique: "staticButton", TextOnCreation: "static", OnClick: func(tele.Context) error { return nil }},
+	})
+
+	var wg sync.WaitGroup
+	for userID := int64(1); userID <= users; userID++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			c := fakeContext{userID: userID}
+			prefix := fmt.Sprintf("%d-", userID)
+			for i := 0; i < renders; i++ {
+				markup := dynamicMenu.bake(c)
+				count := 0
+				for _, row := range markup.InlineKeyboard {
+					for _, btn := range row {
+						count++
+						if !strings.HasPrefix(btn.Unique, prefix) {
+							t.Errorf("user %d got button %s of another user", userID, btn.Unique)
+						}
+					}
+				}
+				if count != buttons {
+					t.Errorf("user %d got %d buttons, want %d", userID, count, buttons)
+				}
+
+				markup = dataMenu.bake(c)
+				if text := markup.InlineKeyboard[0][0].Text; text != fmt.Sprint(userID) {
+					t.Errorf("user %d got button text %s", userID, text)
+				}
+			}
+		}(userID)
+	}
+	wg.Wait()
+}
+
+func TestInlineMenuAddButtonsAfterRegister(t *testing.T) {
+	logger = zap.NewNop()
+	bot, err := tele.NewBot(tele.Settings{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu := NewInlineMenu("menu", "header", 1, nil)
+	menu.AddButtons([]*InlineButtonTemplate{{Unique: "button", TextOnCreation: "text", OnClick: "state"}})
+	if err = NewInlineMenus().RegisterMenu(bot, menu); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("AddButtons after RegisterMenu doesn't panic")
+		}
+	}()
+	menu.AddButtons(nil)
+}
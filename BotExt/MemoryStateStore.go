@@ -0,0 +1,102 @@
+package BotExt
+
+import "sync"
+
+type memoryState struct {
+	state string
+	menus map[string]int // menu name -> id of message with menu
+	vars  map[string]string
+}
+
+// MemoryStateStore keeps states in process memory. States are lost on restart,
+// so it is meant for tests and local development
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[int64]*memoryState
+}
+
+// NewMemoryStateStore - constructor for MemoryStateStore
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[int64]*memoryState)}
+}
+
+// user returns state of user, it is created if it doesn't exist. Mutex must be locked
+func (ms *MemoryStateStore) user(userID int64) *memoryState {
+	s, ok := ms.states[userID]
+	if !ok {
+		s = &memoryState{menus: make(map[string]int), vars: make(map[string]string)}
+		ms.states[userID] = s
+	}
+	return s
+}
+
+func (ms *MemoryStateStore) SetState(userID int64, stateName string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.user(userID).state = stateName
+	return nil
+}
+
+func (ms *MemoryStateStore) GetState(userID int64) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if s, ok := ms.states[userID]; ok {
+		return s.state, nil
+	}
+	return NoState, nil
+}
+
+func (ms *MemoryStateStore) SetVar(userID int64, varName string, varValue string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.user(userID).vars[varName] = varValue
+	return nil
+}
+
+func (ms *MemoryStateStore) GetVar(userID int64, varName string) (value string, ok bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if s, exists := ms.states[userID]; exists {
+		value, ok = s.vars[varName]
+	}
+	return value, ok, nil
+}
+
+func (ms *MemoryStateStore) GetVars(userID int64) (map[string]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s, ok := ms.states[userID]
+	if !ok {
+		return nil, nil
+	}
+	values := make(map[string]string, len(s.vars))
+	for k, v := range s.vars {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (ms *MemoryStateStore) ClearVars(userID int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if s, ok := ms.states[userID]; ok {
+		s.vars = make(map[string]string)
+	}
+	return nil
+}
+
+func (ms *MemoryStateStore) SetMessageID(userID int64, menuName string, msgID int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.user(userID).menus[menuName] = msgID
+	return nil
+}
+
+func (ms *MemoryStateStore) GetMessageID(userID int64, menuName string) (msgID int, ok bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if s, exists := ms.states[userID]; exists {
+		msgID, ok = s.menus[menuName]
+	}
+	return msgID, ok, nil
+}
@@ -0,0 +1,147 @@
+package BotExt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStateStore keeps states in states table:
+//
+//	CREATE TABLE IF NOT EXISTS states (
+//		user_id			int8		NOT NULL,
+//		state			text,
+//		temp_vars		jsonb		NOT NULL DEFAULT '{}'::jsonb,
+//		menu_messages	jsonb		NOT NULL DEFAULT '{}'::jsonb, -- menu name -> id of message with menu
+//		PRIMARY KEY (user_id)
+//	);
+type PostgresStateStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresStateStore - constructor for PostgresStateStore
+func NewPostgresStateStore(db *pgxpool.Pool) *PostgresStateStore {
+	return &PostgresStateStore{db: db}
+}
+
+func (ps *PostgresStateStore) SetState(userID int64, stateName string) error {
+	_, err := ps.db.Exec(context.Background(), `
+		INSERT INTO states (user_id, state) 
+		VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE 
+			SET state = excluded.state 
+		`, userID, stateName)
+	if err != nil {
+		return fmt.Errorf("PostgresStateStore.SetState: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStateStore) GetState(userID int64) (stateName string, err error) {
+	var state *string
+	err = ps.db.QueryRow(context.Background(),
+		"SELECT state FROM states WHERE user_id = $1", userID).Scan(&state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NoState, nil
+	}
+	if err != nil {
+		return NoState, fmt.Errorf("PostgresStateStore.GetState: %w", err)
+	}
+	if state == nil {
+		return NoState, nil
+	}
+	return *state, nil
+}
+
+func (ps *PostgresStateStore) SetVar(userID int64, varName string, varValue string) error {
+	_, err := ps.db.Exec(context.Background(), `
+		INSERT INTO states (user_id, temp_vars)
+		VALUES($3, jsonb_build_object($1::text,$2::text))
+		ON CONFLICT (user_id) DO UPDATE
+			SET temp_vars = states.temp_vars || excluded.temp_vars
+	`, varName, varValue, userID)
+	if err != nil {
+		return fmt.Errorf("PostgresStateStore.SetVar: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStateStore) GetVar(userID int64, varName string) (value string, ok bool, err error) {
+	var v *string
+	err = ps.db.QueryRow(context.Background(), `
+		SELECT temp_vars->>$1 FROM states
+		WHERE user_id = $2
+		`, varName, userID).Scan(&v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("PostgresStateStore.GetVar: %w", err)
+	}
+	if v == nil {
+		return "", false, nil
+	}
+	return *v, true, nil
+}
+
+func (ps *PostgresStateStore) GetVars(userID int64) (values map[string]string, err error) {
+	var strJSON []byte
+	err = ps.db.QueryRow(context.Background(), `
+		SELECT temp_vars FROM states
+		WHERE user_id = $1
+		`, userID).Scan(&strJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PostgresStateStore.GetVars: %w", err)
+	}
+	if err = json.Unmarshal(strJSON, &values); err != nil {
+		return nil, fmt.Errorf("PostgresStateStore.GetVars: %w", err)
+	}
+	return values, nil
+}
+
+func (ps *PostgresStateStore) ClearVars(userID int64) error {
+	_, err := ps.db.Exec(context.Background(), `
+		UPDATE states
+		SET temp_vars = '{}'::jsonb
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("PostgresStateStore.ClearVars: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStateStore) SetMessageID(userID int64, menuName string, msgID int) error {
+	_, err := ps.db.Exec(context.Background(), `
+		INSERT INTO states (user_id, menu_messages)
+		VALUES($1, jsonb_build_object($2::text, $3::int4))
+		ON CONFLICT (user_id) DO UPDATE
+			SET menu_messages = states.menu_messages || excluded.menu_messages
+		`, userID, menuName, msgID)
+	if err != nil {
+		return fmt.Errorf("PostgresStateStore.SetMessageID: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStateStore) GetMessageID(userID int64, menuName string) (msgID int, ok bool, err error) {
+	var id *int
+	err = ps.db.QueryRow(context.Background(),
+		"SELECT (menu_messages->>$2)::int4 FROM states WHERE user_id = $1", userID, menuName).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("PostgresStateStore.GetMessageID: %w", err)
+	}
+	if id == nil {
+		return 0, false, nil
+	}
+	return *id, true, nil
+}
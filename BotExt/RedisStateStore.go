@@ -0,0 +1,98 @@
+package BotExt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisStateStore keeps state of user and ids of messages with menus (fields menu:<menuName>)
+// in hash <prefix>:<userID> and state variables in hash <prefix>:<userID>:vars
+type RedisStateStore struct {
+	rd     *redis.Client
+	prefix string
+}
+
+// NewRedisStateStore - constructor for RedisStateStore. Different bots sharing redis should use different prefixes
+func NewRedisStateStore(rd *redis.Client, prefix string) *RedisStateStore {
+	return &RedisStateStore{rd: rd, prefix: prefix}
+}
+
+func (rs *RedisStateStore) stateKey(userID int64) string {
+	return rs.prefix + ":" + strconv.FormatInt(userID, 10)
+}
+
+func (rs *RedisStateStore) varsKey(userID int64) string {
+	return rs.stateKey(userID) + ":vars"
+}
+
+func (rs *RedisStateStore) SetState(userID int64, stateName string) error {
+	if err := rs.rd.HSet(rs.stateKey(userID), "state", stateName).Err(); err != nil {
+		return fmt.Errorf("RedisStateStore.SetState: %w", err)
+	}
+	return nil
+}
+
+func (rs *RedisStateStore) GetState(userID int64) (stateName string, err error) {
+	stateName, err = rs.rd.HGet(rs.stateKey(userID), "state").Result()
+	if errors.Is(err, redis.Nil) {
+		return NoState, nil
+	}
+	if err != nil {
+		return NoState, fmt.Errorf("RedisStateStore.GetState: %w", err)
+	}
+	return stateName, nil
+}
+
+func (rs *RedisStateStore) SetVar(userID int64, varName string, varValue string) error {
+	if err := rs.rd.HSet(rs.varsKey(userID), varName, varValue).Err(); err != nil {
+		return fmt.Errorf("RedisStateStore.SetVar: %w", err)
+	}
+	return nil
+}
+
+func (rs *RedisStateStore) GetVar(userID int64, varName string) (value string, ok bool, err error) {
+	value, err = rs.rd.HGet(rs.varsKey(userID), varName).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("RedisStateStore.GetVar: %w", err)
+	}
+	return value, true, nil
+}
+
+func (rs *RedisStateStore) GetVars(userID int64) (values map[string]string, err error) {
+	values, err = rs.rd.HGetAll(rs.varsKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisStateStore.GetVars: %w", err)
+	}
+	return values, nil
+}
+
+func (rs *RedisStateStore) ClearVars(userID int64) error {
+	if err := rs.rd.Del(rs.varsKey(userID)).Err(); err != nil {
+		return fmt.Errorf("RedisStateStore.ClearVars: %w", err)
+	}
+	return nil
+}
+
+func (rs *RedisStateStore) SetMessageID(userID int64, menuName string, msgID int) error {
+	if err := rs.rd.HSet(rs.stateKey(userID), "menu:"+menuName, msgID).Err(); err != nil {
+		return fmt.Errorf("RedisStateStore.SetMessageID: %w", err)
+	}
+	return nil
+}
+
+func (rs *RedisStateStore) GetMessageID(userID int64, menuName string) (msgID int, ok bool, err error) {
+	msgID, err = rs.rd.HGet(rs.stateKey(userID), "menu:"+menuName).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("RedisStateStore.GetMessageID: %w", err)
+	}
+	return msgID, true, nil
+}
@@ -0,0 +1,130 @@
+package BotExt
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// logger from outer scope. Use SetLogger to set it up
+var logger *zap.Logger
+
+// SetLogger sets up logger for BotExt package
+func SetLogger(l *zap.Logger) {
+	logger = l
+}
+
+// NoState will be returned if user has no state
+const NoState = ""
+
+// StateStore keeps current state, state variables and menu message id of every user.
+// Implementations: PostgresStateStore, RedisStateStore, MemoryStateStore
+type StateStore interface {
+	SetState(userID int64, stateName string) error
+	// GetState returns NoState if user has no state
+	GetState(userID int64) (string, error)
+
+	SetVar(userID int64, varName string, varValue string) error
+	// GetVar returns ok = false if variable doesn't exist
+	GetVar(userID int64, varName string) (value string, ok bool, err error)
+	GetVars(userID int64) (map[string]string, error)
+	ClearVars(userID int64) error
+
+	// SetMessageID saves id of message with menu, used to update menu content after some action
+	SetMessageID(userID int64, menuName string, msgID int) error
+	// GetMessageID returns ok = false if menu wasn't shown to user
+	GetMessageID(userID int64, menuName string) (msgID int, ok bool, err error)
+}
+
+// STATE RELATED FUNCTIONS
+
+// setState defines current state for user
+func (f *FSM) setState(userID int64, stateName string) {
+	if err := f.store.SetState(userID, stateName); err != nil {
+		if stateName == NoState {
+			stateName = "NoState"
+		}
+		logger.Error("can't set state", zap.Int64("UserID", userID), zap.String("state", stateName), zap.Error(err))
+	}
+}
+
+// getState extract current state for user
+func (f *FSM) getState(userID int64) string {
+	stateName, err := f.store.GetState(userID)
+	if err != nil {
+		logger.Error("can't get state", zap.Int64("UserID", userID), zap.Error(err))
+	}
+	return stateName
+}
+
+// HasState returns true if user have some state
+func (f *FSM) HasState(userID int64) bool {
+	return f.getState(userID) != NoState
+}
+
+// ResetState empties state of user
+func (f *FSM) ResetState(userID int64, keepVars bool) {
+	f.setState(userID, NoState)
+	if !keepVars {
+		f.ClearStateVars(userID)
+	}
+}
+
+// STATE VARIABLE RELATED FUNCTIONS
+
+// SetStateVar sets variable related to state
+func (f *FSM) SetStateVar(userID int64, varName string, varValue string) {
+	if err := f.store.SetVar(userID, varName, varValue); err != nil {
+		logger.Error("can't set state var", zap.Int64("UserID", userID),
+			zap.String("varName", varName), zap.String("varValue", varValue), zap.Error(err))
+	}
+}
+
+// GetStateVar extracts variable related to state if it exists (ok return value)
+func (f *FSM) GetStateVar(userID int64, varName string) (value string, ok bool) {
+	value, ok, err := f.store.GetVar(userID, varName)
+	if err != nil {
+		logger.Error("can't get state var", zap.Int64("UserID", userID), zap.String("varName", varName), zap.Error(err))
+	}
+	return value, ok
+}
+
+// GetStateVars returns all state variables related to user
+func (f *FSM) GetStateVars(userID int64) map[string]string {
+	values, err := f.store.GetVars(userID)
+	if err != nil {
+		logger.Error("can't get state vars", zap.Int64("UserID", userID), zap.Error(err))
+	}
+	for name := range values {
+		if strings.HasPrefix(name, internalVarPrefix) {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// ClearStateVars empties all state variables related to user
+func (f *FSM) ClearStateVars(userID int64) {
+	if err := f.store.ClearVars(userID); err != nil {
+		logger.Error("can't clear state vars", zap.Int64("UserID", userID), zap.Error(err))
+	}
+}
+
+// MESSAGE ID FUNCTIONS
+
+// setMessageID saves id of message with menu, used to update menu content after some action
+func (ims *InlineMenusType) setMessageID(userID int64, menuName string, msgID int) {
+	if err := ims.store.SetMessageID(userID, menuName, msgID); err != nil {
+		logger.Error("can't set message id", zap.Int64("UserID", userID), zap.String("menuName", menuName),
+			zap.Int("msgID", msgID), zap.Error(err))
+	}
+}
+
+// getMessageID extracts id of message with menu (if exists)
+func (ims *InlineMenusType) getMessageID(userID int64, menuName string) (msgID int, ok bool) {
+	msgID, ok, err := ims.store.GetMessageID(userID, menuName)
+	if err != nil {
+		logger.Error("can't get message id", zap.Int64("UserID", userID), zap.String("menuName", menuName), zap.Error(err))
+	}
+	return msgID, ok
+}
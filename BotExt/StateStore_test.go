@@ -0,0 +1,236 @@
+package BotExt
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// testStateStore is a contract every StateStore implementation must satisfy.
+// userID must have no stored state before the call
+func testStateStore(t *testing.T, store StateStore, userID int64) {
+	t.Helper()
+	other := userID + 1
+
+	if state, err := store.GetState(userID); err != nil || state != NoState {
+		t.Fatalf("GetState of new user = %q, %v; want NoState, nil", state, err)
+	}
+	if _, ok, err := store.GetVar(userID, "missing"); err != nil || ok {
+		t.Fatalf("GetVar of new user: ok = %v, err = %v; want false, nil", ok, err)
+	}
+	if vars, err := store.GetVars(userID); err != nil || len(vars) != 0 {
+		t.Fatalf("GetVars of new user = %v, %v; want empty, nil", vars, err)
+	}
+	if _, ok, err := store.GetMessageID(userID, "menu"); err != nil || ok {
+		t.Fatalf("GetMessageID of new user: ok = %v, err = %v; want false, nil", ok, err)
+	}
+
+	// state
+	if err := store.SetState(userID, "first"); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if err := store.SetState(userID, "second"); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if state, err := store.GetState(userID); err != nil || state != "second" {
+		t.Fatalf("GetState = %q, %v; want second, nil", state, err)
+	}
+
+	// vars
+	if err := store.SetVar(userID, "a", "1"); err != nil {
+		t.Fatalf("SetVar: %v", err)
+	}
+	if err := store.SetVar(userID, "b", "2"); err != nil {
+		t.Fatalf("SetVar: %v", err)
+	}
+	if err := store.SetVar(userID, "a", "3"); err != nil {
+		t.Fatalf("SetVar: %v", err)
+	}
+	if v, ok, err := store.GetVar(userID, "a"); err != nil || !ok || v != "3" {
+		t.Fatalf("GetVar(a) = %q, %v, %v; want 3, true, nil", v, ok, err)
+	}
+	if _, ok, err := store.GetVar(userID, "missing"); err != nil || ok {
+		t.Fatalf("GetVar(missing): ok = %v, err = %v; want false, nil", ok, err)
+	}
+	vars, err := store.GetVars(userID)
+	if err != nil {
+		t.Fatalf("GetVars: %v", err)
+	}
+	if want := map[string]string{"a": "3", "b": "2"}; !reflect.DeepEqual(vars, want) {
+		t.Fatalf("GetVars = %v; want %v", vars, want)
+	}
+
+	// menu messages are kept per menu
+	if err := store.SetMessageID(userID, "menu", 10); err != nil {
+		t.Fatalf("SetMessageID: %v", err)
+	}
+	if err := store.SetMessageID(userID, "other", 20); err != nil {
+		t.Fatalf("SetMessageID: %v", err)
+	}
+	if id, ok, err := store.GetMessageID(userID, "menu"); err != nil || !ok || id != 10 {
+		t.Fatalf("GetMessageID(menu) = %d, %v, %v; want 10, true, nil", id, ok, err)
+	}
+	if id, ok, err := store.GetMessageID(userID, "other"); err != nil || !ok || id != 20 {
+		t.Fatalf("GetMessageID(other) = %d, %v, %v; want 20, true, nil", id, ok, err)
+	}
+
+	// users don't share anything
+	if state, err := store.GetState(other); err != nil || state != NoState {
+		t.Fatalf("GetState of other user = %q, %v; want NoState, nil", state, err)
+	}
+	if _, ok, err := store.GetVar(other, "a"); err != nil || ok {
+		t.Fatalf("GetVar of other user: ok = %v, err = %v; want false, nil", ok, err)
+	}
+
+	// clearing vars keeps state and menus
+	if err := store.ClearVars(userID); err != nil {
+		t.Fatalf("ClearVars: %v", err)
+	}
+	if vars, err := store.GetVars(userID); err != nil || len(vars) != 0 {
+		t.Fatalf("GetVars after ClearVars = %v, %v; want empty, nil", vars, err)
+	}
+	if state, err := store.GetState(userID); err != nil || state != "second" {
+		t.Fatalf("GetState after ClearVars = %q, %v; want second, nil", state, err)
+	}
+	if _, ok, err := store.GetMessageID(userID, "menu"); err != nil || !ok {
+		t.Fatalf("GetMessageID after ClearVars: ok = %v, err = %v; want true, nil", ok, err)
+	}
+
+	// reset state
+	if err := store.SetState(userID, NoState); err != nil {
+		t.Fatalf("SetState(NoState): %v", err)
+	}
+	if state, err := store.GetState(userID); err != nil || state != NoState {
+		t.Fatalf("GetState after reset = %q, %v; want NoState, nil", state, err)
+	}
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	testStateStore(t, NewMemoryStateStore(), 1)
+}
+
+// TestPostgresStateStore runs against database from TEST_POSTGRES_URL, states table must exist
+func TestPostgresStateStore(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatalf("pgxpool.New: %v", err)
+	}
+	defer db.Close()
+
+	const userID = -1000 // negative ids don't clash with telegram users
+	cleanup := func() {
+		_, err := db.Exec(context.Background(), "DELETE FROM states WHERE user_id IN ($1, $2)", userID, userID+1)
+		if err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+	testStateStore(t, NewPostgresStateStore(db), userID)
+}
+
+// TestRedisStateStore runs against redis from TEST_REDIS_ADDR
+func TestRedisStateStore(t *testing.T) {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	rd := redis.NewClient(&redis.Options{Addr: addr})
+	defer rd.Close()
+
+	store := NewRedisStateStore(rd, "test_state_store")
+	const userID = -1000
+	cleanup := func() {
+		for _, id := range []int64{userID, userID + 1} {
+			if err := rd.Del(store.stateKey(id), store.varsKey(id)).Err(); err != nil {
+				t.Fatalf("cleanup: %v", err)
+			}
+		}
+	}
+	cleanup()
+	defer cleanup()
+	testStateStore(t, store, userID)
+}
+
+func TestFSMStateVars(t *testing.T) {
+	logger = zap.NewNop()
+	const userID = 1
+
+	for _, keepVars := range []bool{true, false} {
+		fsm := NewFiniteStateMachine(NewInlineMenus(), NewMemoryStateStore())
+		if fsm.HasState(userID) {
+			t.Fatalf("keepVars=%v: new user has state", keepVars)
+		}
+
+		fsm.setState(userID, "state")
+		fsm.SetStateVar(userID, "name", "value")
+		if !fsm.HasState(userID) || fsm.getState(userID) != "state" {
+			t.Fatalf("keepVars=%v: getState = %q; want state", keepVars, fsm.getState(userID))
+		}
+		if v, ok := fsm.GetStateVar(userID, "name"); !ok || v != "value" {
+			t.Fatalf("keepVars=%v: GetStateVar = %q, %v; want value, true", keepVars, v, ok)
+		}
+
+		fsm.ResetState(userID, keepVars)
+		if fsm.HasState(userID) {
+			t.Fatalf("keepVars=%v: state is kept after ResetState", keepVars)
+		}
+		v, ok := fsm.GetStateVar(userID, "name")
+		if keepVars && (!ok || v != "value") {
+			t.Fatalf("ResetState(keepVars=true) lost var: %q, %v", v, ok)
+		}
+		if !keepVars && ok {
+			t.Fatalf("ResetState(keepVars=false) kept var: %q", v)
+		}
+	}
+}
+
+// sendContext is a fakeContext that accepts every message
+type sendContext struct {
+	fakeContext
+}
+
+func (c sendContext) Send(interface{}, ...interface{}) error {
+	return nil
+}
+
+func TestFSMMenuTriggerPerUser(t *testing.T) {
+	logger = zap.NewNop()
+	fsm := NewFiniteStateMachine(NewInlineMenus(), NewMemoryStateStore())
+	err := fsm.RegisterOneShotState(&State{
+		Name:      "state",
+		OnTrigger: "text",
+		Validator: func(tele.Context) string { return "" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsm.Trigger(sendContext{fakeContext{userID: 1}}, "state", "menu")
+	fsm.Trigger(sendContext{fakeContext{userID: 2}}, "state")
+	if menu, _ := fsm.GetStateVar(1, menuTriggerVar("state")); menu != "menu" {
+		t.Fatalf("menu of user 1 = %q; want menu", menu)
+	}
+	if menu, _ := fsm.GetStateVar(2, menuTriggerVar("state")); menu != "" {
+		t.Fatalf("menu of user 2 = %q; want none", menu)
+	}
+
+	// the same user entering the state without menu doesn't keep the old one
+	fsm.Trigger(sendContext{fakeContext{userID: 1}}, "state")
+	if menu, _ := fsm.GetStateVar(1, menuTriggerVar("state")); menu != "" {
+		t.Fatalf("menu of user 1 after trigger without menu = %q; want none", menu)
+	}
+	if vars := fsm.GetStateVars(1); len(vars) != 0 {
+		t.Fatalf("GetStateVars = %v; internal vars must be hidden", vars)
+	}
+}
@@ -0,0 +1,91 @@
+package BotExt
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// MaxUserQueue limits updates waiting for the worker of a single user. Users can't send faster than telegram
+// allows, so the queue grows only if handlers hang; then newer updates are dropped instead of piling up in memory
+const MaxUserQueue = 100
+
+// UpdateSerializer processes updates of every user one at a time and in the order they came,
+// so states and state variables of user aren't changed concurrently (e.g. album parts while recording).
+// Updates of different users are processed in parallel.
+//
+// Bot must be created with telebot.Settings.Synchronous = true: then middleware is called in order of updates,
+// and the serializer itself runs handlers in per-user workers
+type UpdateSerializer struct {
+	mu     sync.Mutex
+	queues map[int64][]func() // key - user id, there is a worker for every key
+}
+
+// NewUpdateSerializer - constructor for UpdateSerializer
+func NewUpdateSerializer() *UpdateSerializer {
+	return &UpdateSerializer{queues: make(map[int64][]func())}
+}
+
+// Middleware puts update to the queue of its user and returns immediately.
+// Handler errors are passed to telebot.Bot.OnError
+func (us *UpdateSerializer) Middleware() tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			job := func() {
+				if err := next(c); err != nil {
+					c.Bot().OnError(err, c)
+				}
+			}
+			var key int64
+			switch {
+			case c.Sender() != nil:
+				key = c.Sender().ID
+			case c.Chat() != nil:
+				key = c.Chat().ID
+			default:
+				go job()
+				return nil
+			}
+			if !us.enqueue(key, job) {
+				logger.Warn("update queue is full, update is dropped", zap.Int64("UserID", key))
+			}
+			return nil
+		}
+	}
+}
+
+// enqueue adds job to the queue of key and starts worker if the queue was empty.
+// Returns false if the queue is full and job is dropped
+func (us *UpdateSerializer) enqueue(key int64, job func()) bool {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	queue, ok := us.queues[key]
+	if len(queue) >= MaxUserQueue {
+		return false
+	}
+	us.queues[key] = append(queue, job)
+	if !ok {
+		go us.work(key)
+	}
+	return true
+}
+
+// work runs jobs of key one by one, worker stops when the queue is empty
+func (us *UpdateSerializer) work(key int64) {
+	for {
+		us.mu.Lock()
+		queue := us.queues[key]
+		if len(queue) == 0 {
+			delete(us.queues, key)
+			us.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		queue[0] = nil
+		us.queues[key] = queue[1:]
+		us.mu.Unlock()
+
+		job()
+	}
+}
@@ -0,0 +1,123 @@
+package BotExt
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// updateContext is a fake update of user with sequence number
+type updateContext struct {
+	fakeContext
+	seq int
+}
+
+func TestUpdateSerializerOrder(t *testing.T) {
+	logger = zap.NewNop()
+	const (
+		users   = 10
+		updates = 30
+	)
+
+	var (
+		mu       sync.Mutex
+		got      = make(map[int64][]int)
+		inFlight [users + 1]int32
+		wg       sync.WaitGroup
+	)
+	handler := NewUpdateSerializer().Middleware()(func(c tele.Context) error {
+		defer wg.Done()
+		uc := c.(updateContext)
+		if atomic.AddInt32(&inFlight[uc.userID], 1) != 1 {
+			t.Errorf("user %d: updates are processed concurrently", uc.userID)
+		}
+		time.Sleep(100 * time.Microsecond)
+		mu.Lock()
+		got[uc.userID] = append(got[uc.userID], uc.seq)
+		mu.Unlock()
+		atomic.AddInt32(&inFlight[uc.userID], -1)
+		return nil
+	})
+
+	// updates of users are interleaved as they come from telegram
+	for seq := 0; seq < updates; seq++ {
+		for userID := int64(1); userID <= users; userID++ {
+			wg.Add(1)
+			if err := handler(updateContext{fakeContext{userID: userID}, seq}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for userID := int64(1); userID <= users; userID++ {
+		seqs := got[userID]
+		if len(seqs) != updates {
+			t.Fatalf("user %d: got %d updates, want %d", userID, len(seqs), updates)
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("user %d: updates are out of order: %v", userID, seqs)
+			}
+		}
+	}
+}
+
+func TestUpdateSerializerParallelUsers(t *testing.T) {
+	logger = zap.NewNop()
+	secondStarted := make(chan struct{})
+	done := make(chan bool, 1)
+
+	handler := NewUpdateSerializer().Middleware()(func(c tele.Context) error {
+		switch c.Sender().ID {
+		case 1:
+			// first user waits for the second one, it would never happen if users were serialized together
+			select {
+			case <-secondStarted:
+				done <- true
+			case <-time.After(5 * time.Second):
+				done <- false
+			}
+		case 2:
+			close(secondStarted)
+		}
+		return nil
+	})
+
+	_ = handler(fakeContext{userID: 1})
+	_ = handler(fakeContext{userID: 2})
+	if !<-done {
+		t.Fatal("update of second user waited for the first user")
+	}
+}
+
+func TestUpdateSerializerQueueLimit(t *testing.T) {
+	logger = zap.NewNop()
+	release := make(chan struct{})
+	var processed int32
+
+	handler := NewUpdateSerializer().Middleware()(func(c tele.Context) error {
+		<-release
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+
+	// first update is taken by the worker or waits in the queue, so one more than MaxUserQueue may fit
+	for i := 0; i < MaxUserQueue+10; i++ {
+		_ = handler(fakeContext{userID: 1})
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&processed) < MaxUserQueue {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&processed); n < MaxUserQueue || n > MaxUserQueue+1 {
+		t.Fatalf("processed %d updates, want %d or %d", n, MaxUserQueue, MaxUserQueue+1)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	om "github.com/wk8/go-ordered-map/v2"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// types of achievement events, bot actions that can unlock achievements
+const (
+	EventWarmupOpened     = "WARMUP_OPENED"
+	EventReminderDone     = "REMINDER_DONE"
+	EventPracticeRecorded = "PRACTICE_RECORDED"
+	EventPurchase         = "PURCHASE"
+	EventLessonBooked     = "LESSON_BOOKED"
+)
+
+// achievementEvent is a bot action of the user
+//   - WarmupID - opened warmup, EventWarmupOpened only
+//   - Streak - current streak after practice, EventPracticeRecorded only
+type achievementEvent struct {
+	Type     string
+	UserID   int64
+	WarmupID string
+	Streak   int
+}
+
+// achievementRule unlocks achievement ID when Check returns true for one of events in On
+type achievementRule struct {
+	ID          string
+	Title       string
+	Description string
+	On          []string
+	Check       func(e achievementEvent) (bool, error)
+}
+
+func alwaysUnlocked(achievementEvent) (bool, error) {
+	return true, nil
+}
+
+func streakAtLeast(days int) func(e achievementEvent) (bool, error) {
+	return func(e achievementEvent) (bool, error) {
+		return e.Streak >= days, nil
+	}
+}
+
+// achievementRules - order of rules is order of badges in AchievementsMenu
+var achievementRules = []*achievementRule{
+	{
+		ID:          "first_warmup",
+		Title:       "🎤 Первая распевка",
+		Description: "Открыть первую распевку",
+		On:          []string{EventWarmupOpened},
+		Check:       alwaysUnlocked,
+	},
+	{
+		ID:          "streak_7",
+		Title:       "🔥 Неделя подряд",
+		Description: "Заниматься 7 дней подряд",
+		On:          []string{EventPracticeRecorded},
+		Check:       streakAtLeast(7),
+	},
+	{
+		ID:          "streak_30",
+		Title:       "🏆 Месяц подряд",
+		Description: "Заниматься 30 дней подряд",
+		On:          []string{EventPracticeRecorded},
+		Check:       streakAtLeast(30),
+	},
+	{
+		ID:          "group_completed",
+		Title:       "📚 Пакет пройден",
+		Description: "Открыть все распевки одного пакета",
+		On:          []string{EventWarmupOpened},
+		Check:       warmupGroupCompleted,
+	},
+	{
+		ID:          "reminders_10",
+		Title:       "✅ Слово держу",
+		Description: "Отметить 'Сделано' в 10 напоминаниях",
+		On:          []string{EventReminderDone},
+		Check:       remindersDoneAtLeast(10),
+	},
+	{
+		ID:          "first_purchase",
+		Title:       "💳 Первая покупка",
+		Description: "Купить распевки, подписку, сертификат или пакет занятий",
+		On:          []string{EventPurchase},
+		Check:       alwaysUnlocked,
+	},
+	{
+		ID:          "first_lesson",
+		Title:       "📅 Первый урок",
+		Description: "Записаться на первый урок",
+		On:          []string{EventLessonBooked},
+		Check:       alwaysUnlocked,
+	},
+}
+
+// warmupGroupCompleted checks that user has opened every warmup of the group of opened warmup
+func warmupGroupCompleted(e achievementEvent) (bool, error) {
+	var completed bool
+	err := DB.QueryRow(context.Background(), `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM warmups
+			WHERE warmup_group = (SELECT warmup_group FROM warmups WHERE warmup_id = $2)
+				AND warmup_id NOT IN (
+					SELECT warmup_id FROM practice_sessions
+					WHERE user_id = $1 AND warmup_id IS NOT NULL
+				)
+		)`, e.UserID, e.WarmupID).Scan(&completed)
+	if err != nil {
+		return false, fmt.Errorf("warmupGroupCompleted: %w", err)
+	}
+	return completed, nil
+}
+
+func remindersDoneAtLeast(count int) func(e achievementEvent) (bool, error) {
+	return func(e achievementEvent) (bool, error) {
+		var done int
+		err := DB.QueryRow(context.Background(), `
+			SELECT COUNT(*) FROM practice_log
+			WHERE user_id = $1 AND status = $2`, e.UserID, PracticeDone).Scan(&done)
+		if err != nil {
+			return false, fmt.Errorf("remindersDoneAtLeast: %w", err)
+		}
+		return done >= count, nil
+	}
+}
+
+// achievementsByEvent - rules subscribed to event type
+var achievementsByEvent = groupAchievementRules(achievementRules)
+
+func groupAchievementRules(rules []*achievementRule) map[string][]*achievementRule {
+	byEvent := make(map[string][]*achievementRule)
+	for _, rule := range rules {
+		for _, eventType := range rule.On {
+			byEvent[eventType] = append(byEvent[eventType], rule)
+		}
+	}
+	return byEvent
+}
+
+func getAchievementRule(achievementID string) (*achievementRule, bool) {
+	for _, rule := range achievementRules {
+		if rule.ID == achievementID {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// userAchievements returns unlocked achievements of the user with unlock date
+func userAchievements(userID int64) (map[string]string, error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT achievement_id, to_char(achieved, 'DD.MM.YYYY') FROM user_achievements
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("userAchievements: %w", err)
+	}
+	defer rows.Close()
+
+	achieved := make(map[string]string)
+	var achievementID, date string
+	for rows.Next() {
+		if err = rows.Scan(&achievementID, &date); err != nil {
+			return nil, fmt.Errorf("userAchievements: scan row: %w", err)
+		}
+		achieved[achievementID] = date
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("userAchievements: postgres itetator %w", err)
+	}
+	return achieved, nil
+}
+
+// publishAchievementEvent evaluates rules subscribed to the event and congratulates user with unlocked achievements.
+// Achievements are not critical, so errors are only logged
+func publishAchievementEvent(b *tele.Bot, e achievementEvent) {
+	rules := achievementsByEvent[e.Type]
+	if len(rules) == 0 {
+		return
+	}
+	achieved, err := userAchievements(e.UserID)
+	if err != nil {
+		logger.Error("can't evaluate achievements", zap.Int64("userID", e.UserID), zap.String("event", e.Type), zap.Error(err))
+		return
+	}
+	for _, rule := range rules {
+		if _, ok := achieved[rule.ID]; ok {
+			continue
+		}
+		ok, err := rule.Check(e)
+		if err != nil {
+			logger.Error("can't check achievement", zap.Int64("userID", e.UserID), zap.String("achievement", rule.ID),
+				zap.Error(err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err = unlockAchievement(b, e.UserID, rule); err != nil {
+			logger.Error("can't unlock achievement", zap.Int64("userID", e.UserID), zap.String("achievement", rule.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// unlockAchievement saves achievement and sends congratulation, achievement is unlocked only once
+func unlockAchievement(b *tele.Bot, userID int64, rule *achievementRule) error {
+	var achievementID string
+	err := DB.QueryRow(context.Background(), `
+		INSERT INTO user_achievements(user_id, achievement_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		RETURNING achievement_id`, userID, rule.ID).Scan(&achievementID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unlockAchievement: %w", err)
+	}
+	logger.Info("achievement unlocked", zap.Int64("userID", userID), zap.String("achievement", rule.ID))
+
+	_, err = b.Send(UserIDType{userID}, fmt.Sprintf("🎉 Новое достижение: %s!\n%s\n\nВсе достижения - в меню 'Мой прогресс'",
+		rule.Title, rule.Description))
+	if err != nil {
+		return fmt.Errorf("unlockAchievement: %w", err)
+	}
+	return nil
+}
+
+func achievementsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	achieved, err := userAchievements(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("achievementsFetcher: %w", err)
+	}
+	omap := om.New[string, string]()
+	for _, rule := range achievementRules {
+		if _, ok := achieved[rule.ID]; ok {
+			omap.Set(rule.ID, rule.Title)
+		} else {
+			omap.Set(rule.ID, "🔒 "+rule.Description)
+		}
+	}
+	return omap, nil
+}
+
+// sendAchievement tells about achievement chosen in AchievementsMenu
+func sendAchievement(c tele.Context, achievementID string) error {
+	rule, ok := getAchievementRule(achievementID)
+	if !ok {
+		return fmt.Errorf("sendAchievement: unknown achievement %s", achievementID)
+	}
+	achieved, err := userAchievements(c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("sendAchievement: %w", err)
+	}
+	if date, ok := achieved[achievementID]; ok {
+		return c.Send(fmt.Sprintf("%s\n%s\nПолучено %s 🤍", rule.Title, rule.Description, date))
+	}
+	return c.Send(fmt.Sprintf("🔒 %s\nЕще не получено, но у тебя все получится!", rule.Description))
+}
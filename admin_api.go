@@ -15,20 +15,29 @@ import (
 
 var (
 	adminInlineMenus = BotExt.NewInlineMenus()
-	adminFSM         = BotExt.NewFiniteStateMachine(adminInlineMenus)
+	adminFSM         *BotExt.FSM
 )
 
-func setupAdminHandlers(b *tele.Bot) {
+func setupAdminHandlers(b *tele.Bot, store BotExt.StateStore) {
+	adminFSM = BotExt.NewFiniteStateMachine(adminInlineMenus, store)
 	SetupAdminStates()
 	SetupAdminMenuHandlers(b)
 }
 
 var (
 	MainAdminMenuOptions = []string{
-		"Отправить сообщение всем", "Добавить подбадривание",
+		"Отправить сообщение всем", "Запланированные рассылки",
+		"Добавить подбадривание", "Забанить, Сделать админом",
 		"Добавить пакет распевок", "Изменить пакет распевок",
 		"Добавить распевку", "Изменить распевку",
-		/*"Кто хочет стать учеником",*/ "Забанить, Сделать админом",
+		"Добавить тариф подписки", "Тарифы подписки",
+		"Добавить промокод", "Промокоды",
+		"Добавить сертификат", "Сертификаты",
+		"Добавить окошки для уроков", "Окошки для уроков",
+		"Добавить пакет занятий", "Пакеты занятий",
+		"Начислить занятия", "Отметить посещение",
+		"Заявки учеников", "Дневник занятий",
+		"Прошлые рассылки",
 	}
 	MainAdminMenu = BotExt.ReplyMenuConstructor(MainAdminMenuOptions, 2, false)
 )
@@ -42,10 +51,14 @@ func onAdminText(c tele.Context) error {
 	switch c.Text() {
 	case "Отправить сообщение всем":
 		userID := c.Sender().ID
-		BotExt.SetStateVar(userID, "RecordID", uuid.New().String())
+		adminFSM.SetStateVar(userID, "RecordID", uuid.New().String())
 		adminFSM.Trigger(c, AdminSGRecordMessage)
 		return nil
 
+	case "Запланированные рассылки":
+		return adminInlineMenus.Show(c, pendingBroadcastsAdminMenu)
+	case "Прошлые рассылки":
+		return adminInlineMenus.Show(c, pastBroadcastsAdminMenu)
 	case "Забанить, Сделать админом":
 		err := sendUserList(c)
 		if err != nil {
@@ -63,18 +76,51 @@ func onAdminText(c tele.Context) error {
 	case "Изменить пакет распевок":
 		return adminInlineMenus.Show(c, warmupGroupAdminMenu)
 	case "Добавить распевку":
-		BotExt.SetStateVar(c.Sender().ID, "RecordID", uuid.New().String())
+		adminFSM.SetStateVar(c.Sender().ID, "RecordID", uuid.New().String())
 		adminFSM.Trigger(c, AdminSGAddWarmup)
 		return nil
 	case "Изменить распевку":
 		return adminInlineMenus.Show(c, changeWarmupMenu)
+	case "Добавить тариф подписки":
+		adminFSM.Trigger(c, AdminSGAddSubscriptionPlan)
+		return nil
+	case "Тарифы подписки":
+		return adminInlineMenus.Show(c, subscriptionPlansAdminMenu)
+	case "Добавить промокод":
+		adminFSM.Trigger(c, AdminSGAddPromoCode)
+		return nil
+	case "Промокоды":
+		return adminInlineMenus.Show(c, promoCodesAdminMenu)
+	case "Добавить сертификат":
+		adminFSM.Trigger(c, AdminSGAddGiftCertificate)
+		return nil
+	case "Сертификаты":
+		return adminInlineMenus.Show(c, giftCertificatesAdminMenu)
+	case "Добавить окошки для уроков":
+		adminFSM.Trigger(c, AdminSGAddLessonSlotsFormat)
+		return nil
+	case "Окошки для уроков":
+		return adminInlineMenus.Show(c, lessonSlotsAdminMenu)
+	case "Добавить пакет занятий":
+		adminFSM.Trigger(c, AdminSGAddLessonPackage)
+		return nil
+	case "Пакеты занятий":
+		return adminInlineMenus.Show(c, lessonPackagesAdminMenu)
+	case "Начислить занятия":
+		adminFSM.Trigger(c, AdminSGGrantLessonsUser)
+		return nil
+	case "Отметить посещение":
+		return adminInlineMenus.Show(c, lessonAttendanceAdminMenu)
 	case "Добавить подбадривание":
 		userID := c.Sender().ID
-		BotExt.SetStateVar(userID, "RecordID", uuid.New().String())
+		adminFSM.SetStateVar(userID, "RecordID", uuid.New().String())
 		adminFSM.Trigger(c, AdminSGRecordCheerup)
 		return nil
-	//case "Кто хочет стать учеником":
-	//	return adminInlineMenus.Show(c, wannabeStudentResolutionMenu)
+	case "Заявки учеников":
+		adminFSM.SetStateVar(c.Sender().ID, "leadsPage", "0")
+		return adminInlineMenus.Show(c, leadsAdminMenu)
+	case "Дневник занятий":
+		return sendPracticeReport(c)
 	case "ОЧИСТИТЬ КЭШ":
 		err := RD.FlushAll().Err()
 		if err != nil {
@@ -86,6 +132,16 @@ func onAdminText(c tele.Context) error {
 			logger.Error("can't RebuildQueue", zap.Error(err))
 			return c.Send("Не удалось обновить очередь напоминаний!")
 		}
+		err = subscriptionReminderService.RebuildQueue()
+		if err != nil {
+			logger.Error("can't RebuildQueue", zap.Error(err))
+			return c.Send("Не удалось обновить очередь напоминаний о подписке!")
+		}
+		err = lessonReminderService.RebuildQueue()
+		if err != nil {
+			logger.Error("can't RebuildQueue", zap.Error(err))
+			return c.Send("Не удалось обновить очередь напоминаний об уроках!")
+		}
 		return c.Send("Redis очищен")
 	case "СТАТЬ ЮЗЕРОМ":
 		userID := c.Sender().ID
@@ -119,32 +175,125 @@ func OnAdminInlineResult(c tele.Context) error {
 	triggeredItem := triggeredData[1]
 	userID := c.Sender().ID
 	switch triggeredItem {
-	/*
-		case wannabeStudentResolutionMenu:
-		userID_, err := strconv.ParseInt(triggeredID, 10, 64)
+	case pendingBroadcastsAdminMenu:
+		adminFSM.SetStateVar(userID, "selectedBroadcast", triggeredID)
+		err := previewBroadcast(c, triggeredID)
+		if err != nil {
+			logger.Error("previewBroadcast", zap.Int64("user", userID), zap.Error(err))
+		}
+		err = adminInlineMenus.Show(c, broadcastAdminMenu)
 		if err != nil {
-			logger.Error("can't parse userID", zap.Error(err))
+			logger.Error("broadcastAdminMenu", zap.Int64("user", userID), zap.Error(err))
 		}
-		err = resolveWannabeStudent(c, userID_)
+	case pastBroadcastsAdminMenu:
+		broadcastID, err := strconv.Atoi(triggeredID)
 		if err != nil {
-			logger.Error("can't resolve WannabeStudent", zap.Error(err))
+			logger.Error("pastBroadcastsAdminMenu", zap.Int64("user", userID), zap.Error(err))
+			break
 		}
-		return c.Respond()
-	*/
+		if err = sendBroadcastReport(c, broadcastID); err != nil {
+			logger.Error("sendBroadcastReport", zap.Int64("user", userID), zap.Error(err))
+		}
+	case audienceSegmentsAdminMenu:
+		if triggeredID == newSegment {
+			StartSegment(c)
+			break
+		}
+		err := applySavedSegment(c, triggeredID)
+		if err != nil {
+			logger.Error("applySavedSegment", zap.Int64("user", userID), zap.Error(err))
+		}
+	case leadsAdminMenu:
+		if (triggeredID == leadsPrevPage) || (triggeredID == leadsNextPage) {
+			switchLeadsPage(userID, triggeredID)
+			adminInlineMenus.Update(c, leadsAdminMenu)
+			break
+		}
+		adminFSM.SetStateVar(userID, "selectedLead", triggeredID)
+		err := sendLeadCard(c, triggeredID)
+		if err != nil {
+			logger.Error("sendLeadCard", zap.Int64("user", userID), zap.Error(err))
+		}
+		err = adminInlineMenus.Show(c, leadAdminMenu)
+		if err != nil {
+			logger.Error("leadAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+
 	case warmupGroupAdminMenu:
+		if adminFSM.GetCurrentState(c) == AdminSGSetPromoCodeGroups {
+			err := addPromoCodeGroup(c, triggeredID)
+			if err != nil {
+				logger.Error("addPromoCodeGroup", zap.Int64("user", userID), zap.Error(err))
+			}
+			return c.Respond()
+		}
+		if adminFSM.GetCurrentState(c) == AdminSGSetGiftCertificateContent {
+			adminFSM.SetStateVar(userID, "certificateGroup", triggeredID)
+			adminFSM.Update(c)
+			return c.Respond()
+		}
+		if adminFSM.GetCurrentState(c) == AdminSGSegmentGroup {
+			adminFSM.SetStateVar(userID, "segmentGroup", triggeredID)
+			adminFSM.Update(c)
+			return c.Respond()
+		}
 		if adminFSM.GetCurrentState(c) == AdminSGAddWarmup {
-			BotExt.SetStateVar(userID, "selectedWarmupGroup", triggeredID)
+			adminFSM.SetStateVar(userID, "selectedWarmupGroup", triggeredID)
 			adminFSM.Update(c)
 			return c.Respond()
 		}
-		BotExt.SetStateVar(userID, "selectedWarmupGroup", triggeredID)
+		adminFSM.SetStateVar(userID, "selectedWarmupGroup", triggeredID)
 		err := adminInlineMenus.Show(c, changeWarmupGroupParamsMenu)
 		if err != nil {
 			logger.Error("changeWarmupMenu", zap.Int64("user", userID), zap.Error(err))
 		}
 
+	case subscriptionPlansAdminMenu:
+		err := switchSubscriptionPlan(triggeredID)
+		if err != nil {
+			logger.Error("subscriptionPlansAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+		adminInlineMenus.Update(c, subscriptionPlansAdminMenu)
+
+	case promoCodesAdminMenu:
+		err := switchPromoCode(triggeredID)
+		if err != nil {
+			logger.Error("promoCodesAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+		adminInlineMenus.Update(c, promoCodesAdminMenu)
+
+	case giftCertificatesAdminMenu:
+		err := switchGiftCertificateType(triggeredID)
+		if err != nil {
+			logger.Error("giftCertificatesAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+		adminInlineMenus.Update(c, giftCertificatesAdminMenu)
+
+	case lessonSlotsAdminMenu:
+		err := processLessonSlotAdmin(c, triggeredID)
+		if err != nil {
+			logger.Error("lessonSlotsAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+		adminInlineMenus.Update(c, lessonSlotsAdminMenu)
+
+	case lessonPackagesAdminMenu:
+		err := switchLessonPackage(triggeredID)
+		if err != nil {
+			logger.Error("lessonPackagesAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		}
+		adminInlineMenus.Update(c, lessonPackagesAdminMenu)
+
+	case lessonAttendanceAdminMenu:
+		studentID, balance, err := markLessonAttended(c.Bot(), triggeredID)
+		if err != nil {
+			logger.Error("lessonAttendanceAdminMenu", zap.Int64("user", userID), zap.Error(err))
+		} else {
+			_ = c.Send(fmt.Sprintf("Занятие списано, баланс ученика [ID%d]: %d", studentID, balance))
+		}
+		adminInlineMenus.Update(c, lessonAttendanceAdminMenu)
+
 	case changeWarmupMenu:
-		BotExt.SetStateVar(userID, "selectedWarmup", triggeredID)
+		adminFSM.SetStateVar(userID, "selectedWarmup", triggeredID)
 		err := adminInlineMenus.Show(c, changeWarmupParamsMenu)
 		if err != nil {
 			logger.Error("changeWarmupMenu", zap.Int64("user", userID), zap.Error(err))
@@ -154,42 +303,100 @@ func OnAdminInlineResult(c tele.Context) error {
 	return c.Respond()
 }
 
-/*
-func resolveWannabeStudent(c tele.Context, userID int64) error {
-	var userName, userPhone, createdDate string
-	err := DB.QueryRow(context.Background(), `
-	SELECT user_name, phone_num, created::date::text FROM wannabe_student
-	WHERE user_id = $1`, userID).Scan(&userName, &userPhone, &createdDate)
+// notifyAdmins sends text to every admin. Errors are only logged: notification is not a reason to fail user action
+func notifyAdmins(b *tele.Bot, text string, opts ...interface{}) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT user_id FROM users
+		WHERE user_class = 'ADMIN'`)
 	if err != nil {
-		return fmt.Errorf("resolveWannabeStudent: can't query row: %w", err)
+		logger.Error("notifyAdmins: pg query error", zap.Error(err))
+		return
+	}
+	var admins []int64
+	var adminID int64
+	for rows.Next() {
+		if err = rows.Scan(&adminID); err != nil {
+			logger.Error("notifyAdmins: row scan error", zap.Error(err))
+			continue
+		}
+		admins = append(admins, adminID)
 	}
+	rows.Close()
 
-	text := fmt.Sprintf("@%s оставил(a) заявку %s", userName, createdDate)
-	err = c.Send(text)
+	for _, adminID := range admins {
+		if _, err = b.Send(UserIDType{adminID}, text, opts...); err != nil {
+			logger.Error("notifyAdmins: can't send message", zap.Int64("admin", adminID), zap.Error(err))
+		}
+	}
+}
+
+// userMention returns @username if user has one, otherwise - first and last name
+func userMention(u *tele.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func switchSubscriptionPlan(planID string) error {
+	_, err := DB.Exec(context.Background(), `
+	UPDATE subscription_plans
+	SET active = NOT active
+	WHERE plan_id = $1`, planID)
 	if err != nil {
-		return err
+		return fmt.Errorf("switchSubscriptionPlan: %w", err)
 	}
-	if userPhone != "" {
-		params := map[string]string{
-			"chat_id":      strconv.FormatInt(c.Sender().ID, 10),
-			"phone_number": userPhone,
-			"first_name":   userName,
-		}
-		_, err = c.Bot().Raw("sendContact", params)
-		if err != nil {
-			return err
-		}
-		_ = c.Send("Пользователь просил позвонить по телефону")
+	return nil
+}
+
+func switchPromoCode(code string) error {
+	_, err := DB.Exec(context.Background(), `
+	UPDATE promo_codes
+	SET active = NOT active
+	WHERE promo_code = $1`, code)
+	if err != nil {
+		return fmt.Errorf("switchPromoCode: %w", err)
 	}
+	return nil
+}
 
-	_, err = DB.Exec(context.Background(), `
-	UPDATE wannabe_student
-	SET resolved = true
-	WHERE user_id = $1`, userID)
+func switchLessonPackage(packageID string) error {
+	_, err := DB.Exec(context.Background(), `
+	UPDATE lesson_packages
+	SET active = NOT active
+	WHERE package_id = $1`, packageID)
+	if err != nil {
+		return fmt.Errorf("switchLessonPackage: %w", err)
+	}
+	return nil
+}
 
-	return err
+func switchGiftCertificateType(typeID string) error {
+	_, err := DB.Exec(context.Background(), `
+	UPDATE gift_certificate_types
+	SET active = NOT active
+	WHERE type_id = $1`, typeID)
+	if err != nil {
+		return fmt.Errorf("switchGiftCertificateType: %w", err)
+	}
+	return nil
+}
+
+// processLessonSlotAdmin deletes free slot or cancels booking of booked one
+func processLessonSlotAdmin(c tele.Context, slotID string) error {
+	s, err := getLessonSlot(slotID)
+	if err != nil {
+		return fmt.Errorf("processLessonSlotAdmin: %w", err)
+	}
+	if s.BookedBy == nil {
+		return deleteLessonSlot(slotID)
+	}
+	err = cancelLessonBooking(c.Bot(), slotID)
+	if err != nil {
+		return fmt.Errorf("processLessonSlotAdmin: %w", err)
+	}
+	return c.Send(fmt.Sprintf("Запись ученика [ID%d] отменена, ученику отправлено уведомление", *s.BookedBy))
 }
-*/
 
 func sendUserList(c tele.Context) error {
 	rows, err := DB.Query(context.Background(), `
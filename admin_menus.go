@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"vocal_training_bot/BotExt"
 
@@ -12,27 +14,165 @@ import (
 )
 
 const (
-	// wannabeStudentResolutionMenu = "wannabeStudentResolutionMenu"
+	leadsAdminMenu         = "leadsAdminMenu"
+	leadAdminMenu          = "leadAdminMenu"
 	warmupGroupAdminMenu   = "warmupGroupAdminMenu"
 	changeWarmupMenu       = "changeWarmupMenu"
 	changeWarmupParamsMenu = "changeWarmupParamsMenu"
 
 	changeWarmupGroupParamsMenu = "changeWarmupGroupParamsMenu"
+
+	subscriptionPlansAdminMenu = "subscriptionPlansAdminMenu"
+	promoCodesAdminMenu        = "promoCodesAdminMenu"
+	giftCertificatesAdminMenu  = "giftCertificatesAdminMenu"
+	lessonSlotsAdminMenu       = "lessonSlotsAdminMenu"
+	lessonPackagesAdminMenu    = "lessonPackagesAdminMenu"
+	lessonAttendanceAdminMenu  = "lessonAttendanceAdminMenu"
+	cheerupCategoriesAdminMenu = "cheerupCategoriesAdminMenu"
+	broadcastAdminMenu         = "broadcastAdminMenu"
+	pendingBroadcastsAdminMenu = "pendingBroadcastsAdminMenu"
+	audienceSegmentsAdminMenu  = "audienceSegmentsAdminMenu"
+	pastBroadcastsAdminMenu    = "pastBroadcastsAdminMenu"
 )
 
 func SetupAdminMenuHandlers(b *tele.Bot) {
-	/*
-		wannabeStudentResolutionIM := BotExt.NewDynamicInlineMenu(
-			wannabeStudentResolutionMenu,
-			"Существующие заявки",
-			1,
-			wannabeStudentResolutionFetcher,
-		)
-		err := adminInlineMenus.RegisterMenu(b, wannabeStudentResolutionIM)
-		if err != nil {
-			panic(err)
-		}
-	*/
+	leadsAdminIM := BotExt.NewDynamicInlineMenu(
+		leadsAdminMenu,
+		"Заявки учеников (🆕 новая, 📞 связались, 📅 записан(а), ❌ потерян(а)):",
+		1,
+		leadsAdminFetcher,
+	)
+	err := adminInlineMenus.RegisterMenu(b, leadsAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	leadAdminIM := BotExt.NewInlineMenu(
+		leadAdminMenu,
+		"Статус заявки",
+		2,
+		leadDataFetcher,
+	)
+	leadAdminIM.AddButtons([]*BotExt.InlineButtonTemplate{
+		leadStatusButton(LeadNew),
+		leadStatusButton(LeadContacted),
+		leadStatusButton(LeadBooked),
+		leadStatusButton(LeadLost),
+		{Unique: BotExt.RowSplitterButton},
+		{
+			Unique:         "AddLeadNote",
+			TextOnCreation: "📝 Добавить заметку",
+			OnClick: func(c tele.Context) error {
+				adminFSM.Trigger(c, AdminSGAddLeadNote)
+				return c.Respond()
+			},
+		},
+	})
+	err = adminInlineMenus.RegisterMenu(b, leadAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	broadcastAdminIM := BotExt.NewInlineMenu(
+		broadcastAdminMenu,
+		"Когда отправить рассылку?",
+		1,
+		nil,
+	)
+	broadcastAdminIM.AddButtons([]*BotExt.InlineButtonTemplate{
+		{
+			Unique:         "BroadcastSendNow",
+			TextOnCreation: "🚀 Отправить сейчас",
+			OnClick: func(c tele.Context) error {
+				if err := sendBroadcastNow(c); err != nil {
+					logger.Error("can't send broadcast", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
+		{
+			Unique:         "BroadcastSchedule",
+			TextOnCreation: "🕐 Запланировать",
+			OnClick: func(c tele.Context) error {
+				adminFSM.Trigger(c, AdminSGScheduleBroadcast)
+				return c.Respond()
+			},
+		},
+		{
+			Unique:         "BroadcastAudience",
+			TextOnCreation: "👥 Выбрать аудиторию",
+			OnClick: func(c tele.Context) error {
+				err := adminInlineMenus.Show(c, audienceSegmentsAdminMenu)
+				if err != nil {
+					logger.Error("audienceSegmentsAdminMenu", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
+		{
+			Unique:         "BroadcastCancel",
+			TextOnCreation: "🗑 Отменить рассылку",
+			OnClick: func(c tele.Context) error {
+				if err := cancelSelectedBroadcast(c); err != nil {
+					logger.Error("can't cancel broadcast", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
+	})
+	err = adminInlineMenus.RegisterMenu(b, broadcastAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	pendingBroadcastsAdminIM := BotExt.NewDynamicInlineMenu(
+		pendingBroadcastsAdminMenu,
+		"Запланированные рассылки и черновики:",
+		1,
+		pendingBroadcastsFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, pendingBroadcastsAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	pastBroadcastsAdminIM := BotExt.NewDynamicInlineMenu(
+		pastBroadcastsAdminMenu,
+		"Последние рассылки (📨 отправляется, ✅ отправлена, 🗑 отменена):",
+		1,
+		pastBroadcastsFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, pastBroadcastsAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	audienceSegmentsAdminIM := BotExt.NewDynamicInlineMenu(
+		audienceSegmentsAdminMenu,
+		"Кому отправить рассылку?",
+		1,
+		audienceSegmentsFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, audienceSegmentsAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	cheerupCategoriesAdminIM := BotExt.NewInlineMenu(
+		cheerupCategoriesAdminMenu,
+		"Когда лучше отправлять это подбадривание? Без категорий - в любой момент",
+		1,
+		cheerupCategoriesFetcher,
+	)
+	var cheerupCategoryButtons []*BotExt.InlineButtonTemplate
+	for _, category := range cheerupCategories {
+		cheerupCategoryButtons = append(cheerupCategoryButtons, cheerupCategoryButton(category))
+	}
+	cheerupCategoriesAdminIM.AddButtons(cheerupCategoryButtons)
+	err = adminInlineMenus.RegisterMenu(b, cheerupCategoriesAdminIM)
+	if err != nil {
+		panic(err)
+	}
 
 	warmupGroupAdminIM := BotExt.NewDynamicInlineMenu(
 		warmupGroupAdminMenu,
@@ -40,7 +180,7 @@ func SetupAdminMenuHandlers(b *tele.Bot) {
 		1,
 		warmupGroupAdminFetcher,
 	)
-	err := adminInlineMenus.RegisterMenu(b, warmupGroupAdminIM)
+	err = adminInlineMenus.RegisterMenu(b, warmupGroupAdminIM)
 	if err != nil {
 		panic(err)
 	}
@@ -138,42 +278,172 @@ func SetupAdminMenuHandlers(b *tele.Bot) {
 		panic(err)
 	}
 
+	subscriptionPlansAdminIM := BotExt.NewDynamicInlineMenu(
+		subscriptionPlansAdminMenu,
+		"Тарифы подписки. Нажми на тариф, чтобы включить или выключить его продажу:",
+		1,
+		subscriptionPlansAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, subscriptionPlansAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	promoCodesAdminIM := BotExt.NewDynamicInlineMenu(
+		promoCodesAdminMenu,
+		"Промокоды [использовано/лимит, срок действия]. Нажми на промокод, чтобы включить или выключить его:",
+		1,
+		promoCodesAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, promoCodesAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	giftCertificatesAdminIM := BotExt.NewDynamicInlineMenu(
+		giftCertificatesAdminMenu,
+		"Подарочные сертификаты [продано/активировано]. Нажми на сертификат, чтобы включить или выключить его продажу:",
+		1,
+		giftCertificatesAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, giftCertificatesAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonSlotsAdminIM := BotExt.NewDynamicInlineMenu(
+		lessonSlotsAdminMenu,
+		"Ближайшие окошки для уроков по твоему часовому поясу. 🟢 - свободно, нажми чтобы удалить. 🔴 - занято, нажми чтобы отменить запись:",
+		1,
+		lessonSlotsAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, lessonSlotsAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonPackagesAdminIM := BotExt.NewDynamicInlineMenu(
+		lessonPackagesAdminMenu,
+		"Пакеты занятий. Нажми на пакет, чтобы включить или выключить его продажу:",
+		1,
+		lessonPackagesAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, lessonPackagesAdminIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonAttendanceAdminIM := BotExt.NewDynamicInlineMenu(
+		lessonAttendanceAdminMenu,
+		"Прошедшие уроки за 30 дней, посещение которых не отмечено. Нажми на урок, чтобы списать занятие с баланса ученика:",
+		1,
+		lessonAttendanceAdminFetcher,
+	)
+	err = adminInlineMenus.RegisterMenu(b, lessonAttendanceAdminIM)
+	if err != nil {
+		panic(err)
+	}
 }
 
-/*
-	func wannabeStudentResolutionFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
-		rows, err := DB.Query(context.Background(), `
-		SELECT user_id::text, user_name FROM wannabe_student
-		WHERE resolved = false
-		ORDER BY created DESC`)
-		defer rows.Close()
+func leadsAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	pageStr, _ := adminFSM.GetStateVar(c.Sender().ID, "leadsPage")
+	page, _ := strconv.Atoi(pageStr)
+
+	// one extra row shows if there is a next page
+	rows, err := DB.Query(context.Background(), `
+	SELECT lead_id::text, user_name, status, created FROM wannabe_student
+	ORDER BY
+		CASE status
+			WHEN 'NEW' THEN 0
+			WHEN 'CONTACTED' THEN 1
+			ELSE 2
+		END,
+		created DESC
+	LIMIT $1 OFFSET $2`, leadsPageSize+1, page*leadsPageSize)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("leadsAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var leadID, userName, status string
+	var created time.Time
+	hasNext := false
+	for rows.Next() {
+		err = rows.Scan(&leadID, &userName, &status, &created)
 		if err != nil {
-			return nil, fmt.Errorf("wannabeStudentResolutionFetcher: can't fetch database: %w", err)
+			return omap, fmt.Errorf("leadsAdminFetcher: can't fetch row: %w", err)
 		}
-		omap := om.New[string, string]()
-
-		var userID, userName string
+		if omap.Len() == leadsPageSize {
+			hasNext = true
+			break
+		}
+		if userName == "" {
+			userName = "без username"
+		}
+		omap.Set(leadID, fmt.Sprintf("%s #%s @%s, %s", leadStatusEmoji(status), leadID, userName, created.Format("02.01.2006")))
+	}
 
-		for rows.Next() {
-			err = rows.Scan(&userID, &userName)
-			if err != nil {
-				return omap, fmt.Errorf("wannabeStudentResolutionFetcher: can't fetch row: %w", err)
-			}
-			text := "Пользователь @" + userName
-			omap.Set(userID, text)
+	if (omap.Len() == 0) && (page == 0) {
+		err = c.Send("Заявок нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
 		}
+		return nil, BotExt.NoButtons
+	}
+	if page > 0 {
+		omap.Set(leadsPrevPage, "⬅️ Назад")
+	}
+	if hasNext {
+		omap.Set(leadsNextPage, "Вперед ➡️")
+	}
+
+	return omap, nil
+}
+
+func leadDataFetcher(c tele.Context) (map[string]string, error) {
+	leadID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedLead")
+	if !ok {
+		return nil, fmt.Errorf("leadDataFetcher: can't fetch selectedLead")
+	}
+
+	var status string
+	err := DB.QueryRow(context.Background(), `
+	SELECT status FROM wannabe_student
+	WHERE lead_id = $1`, leadID).Scan(&status)
+	if err != nil {
+		return nil, fmt.Errorf("leadDataFetcher: can't fetch %s lead data: %w", leadID, err)
+	}
 
-		if omap.Len() == 0 {
-			err := c.Send("Активных заявок нет")
+	out := make(map[string]string)
+	out["status"] = status
+	return out, nil
+}
+
+// leadStatusButton sets lead status on click, current status is marked
+func leadStatusButton(status string) *BotExt.InlineButtonTemplate {
+	return &BotExt.InlineButtonTemplate{
+		Unique: "LeadStatus" + status,
+		TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+			current, ok := dc["status"]
+			if !ok {
+				return leadStatusTexts[status], fmt.Errorf("can't fetch status")
+			}
+			if current == status {
+				return "✅ " + leadStatusTexts[status], nil
+			}
+			return leadStatusTexts[status], nil
+		},
+		OnClick: func(c tele.Context) error {
+			err := setLeadStatus(c, status)
 			if err != nil {
-				logger.Error("can't send message", zap.Error(err))
+				logger.Error("can't set lead status", zap.Int64("userID", c.Sender().ID), zap.Error(err))
 			}
-			return nil, BotExt.NoButtons
-		}
-
-		return omap, nil
+			adminInlineMenus.Update(c, leadAdminMenu)
+			return c.Respond()
+		},
 	}
-*/
+}
 
 func warmupGroupAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
 	rows, err := DB.Query(context.Background(), `
@@ -239,7 +509,7 @@ func warmupListFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
 
 func warmupParamsFetcher(c tele.Context) (map[string]string, error) {
 	userID := c.Sender().ID
-	warmupID, ok := BotExt.GetStateVar(userID, "selectedWarmup")
+	warmupID, ok := adminFSM.GetStateVar(userID, "selectedWarmup")
 	if !ok {
 		return nil, fmt.Errorf("warmupParamsFetcher: can't fetch selectedWarmup")
 	}
@@ -262,7 +532,7 @@ func warmupParamsFetcher(c tele.Context) (map[string]string, error) {
 
 func warmupGroupParamsFetcher(c tele.Context) (map[string]string, error) {
 	userID := c.Sender().ID
-	warmupGroupID, ok := BotExt.GetStateVar(userID, "selectedWarmupGroup")
+	warmupGroupID, ok := adminFSM.GetStateVar(userID, "selectedWarmupGroup")
 	if !ok {
 		return nil, fmt.Errorf("warmupParamsFetcher: can't fetch selectedWarmupGroup")
 	}
@@ -281,3 +551,256 @@ func warmupGroupParamsFetcher(c tele.Context) (map[string]string, error) {
 
 	return out, nil
 }
+
+func subscriptionPlansAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+	SELECT plan_id::text, plan_name, period_days, price, active FROM subscription_plans
+	ORDER BY plan_id`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("subscriptionPlansAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var planID, planName string
+	var periodDays, price int
+	var active bool
+	for rows.Next() {
+		err = rows.Scan(&planID, &planName, &periodDays, &price, &active)
+		if err != nil {
+			return omap, fmt.Errorf("subscriptionPlansAdminFetcher: can't fetch row: %w", err)
+		}
+		status := "🔕"
+		if active {
+			status = "🔔"
+		}
+		omap.Set(planID, fmt.Sprintf("%s %s [%d дн., %d руб.]", status, planName, periodDays, price))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Тарифов подписки пока нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func promoCodesAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+	SELECT promo_code FROM promo_codes
+	ORDER BY created DESC`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("promoCodesAdminFetcher: can't fetch database: %w", err)
+	}
+
+	var codes []string
+	var code string
+	for rows.Next() {
+		err = rows.Scan(&code)
+		if err != nil {
+			return nil, fmt.Errorf("promoCodesAdminFetcher: can't fetch row: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	rows.Close()
+
+	omap := om.New[string, string]()
+	for _, code := range codes {
+		promo, err := getPromoCode(code)
+		if err != nil {
+			return omap, fmt.Errorf("promoCodesAdminFetcher: %w", err)
+		}
+		status := "🔕"
+		if promo.Active {
+			status = "🔔"
+		}
+		limit := "∞"
+		if promo.UsageLimit != nil {
+			limit = strconv.Itoa(*promo.UsageLimit)
+		}
+		expire := "бессрочно"
+		if promo.Expire != nil {
+			expire = "до " + promo.Expire.Format("02.01.2006")
+		}
+		groups := "все пакеты"
+		if len(promo.Groups) != 0 {
+			groups = fmt.Sprintf("пакетов: %d", len(promo.Groups))
+		}
+		omap.Set(promo.Code, fmt.Sprintf("%s %s %s [%d/%s, %s, %s]",
+			status, promo.Code, promo.String(), promo.Used, limit, expire, groups))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Промокодов пока нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func giftCertificatesAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+	SELECT type_id::text, title, price, active,
+		(SELECT COUNT(*) FROM gift_certificates WHERE gift_certificates.type_id = gift_certificate_types.type_id),
+		(SELECT COUNT(*) FROM gift_certificates WHERE gift_certificates.type_id = gift_certificate_types.type_id
+			AND redeemed_by IS NOT NULL)
+	FROM gift_certificate_types
+	ORDER BY type_id`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("giftCertificatesAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var typeID, title string
+	var price, sold, redeemed int
+	var active bool
+	for rows.Next() {
+		err = rows.Scan(&typeID, &title, &price, &active, &sold, &redeemed)
+		if err != nil {
+			return omap, fmt.Errorf("giftCertificatesAdminFetcher: can't fetch row: %w", err)
+		}
+		status := "🔕"
+		if active {
+			status = "🔔"
+		}
+		omap.Set(typeID, fmt.Sprintf("%s %s [%d руб., %d/%d]", status, title, price, sold, redeemed))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Подарочных сертификатов пока нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func lessonSlotsAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("lessonSlotsAdminFetcher: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+	SELECT slot_id::text, start_dt, duration_min, format, booked_by, COALESCE(username, '') FROM lesson_slots
+	LEFT JOIN users ON users.user_id = lesson_slots.booked_by
+	WHERE start_dt > now() AT TIME ZONE 'UTC'
+	ORDER BY start_dt
+	LIMIT 50`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("lessonSlotsAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var studentName string
+	for rows.Next() {
+		s := lessonSlot{}
+		err = rows.Scan(&s.SlotID, &s.StartDT, &s.Duration, &s.Format, &s.BookedBy, &studentName)
+		if err != nil {
+			return omap, fmt.Errorf("lessonSlotsAdminFetcher: can't fetch row: %w", err)
+		}
+		if s.BookedBy == nil {
+			omap.Set(s.SlotID, "🟢 "+s.Text(loc))
+			continue
+		}
+		omap.Set(s.SlotID, "🔴 "+s.Text(loc)+" - "+studentName)
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Окошек для уроков пока нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func lessonPackagesAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+	SELECT package_id::text, title, lessons, price, active FROM lesson_packages
+	ORDER BY package_id`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("lessonPackagesAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var packageID, title string
+	var lessons, price int
+	var active bool
+	for rows.Next() {
+		err = rows.Scan(&packageID, &title, &lessons, &price, &active)
+		if err != nil {
+			return omap, fmt.Errorf("lessonPackagesAdminFetcher: can't fetch row: %w", err)
+		}
+		status := "🔕"
+		if active {
+			status = "🔔"
+		}
+		omap.Set(packageID, fmt.Sprintf("%s %s [%d зан., %d руб.]", status, title, lessons, price))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Пакетов занятий пока нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func lessonAttendanceAdminFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("lessonAttendanceAdminFetcher: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+	SELECT slot_id::text, start_dt, duration_min, format, booked_by, COALESCE(username, ''),
+		(SELECT COALESCE(SUM(delta), 0) FROM lesson_credits WHERE lesson_credits.user_id = lesson_slots.booked_by)
+	FROM lesson_slots
+	INNER JOIN users ON users.user_id = lesson_slots.booked_by
+	WHERE attended IS NULL
+		AND start_dt < now() AT TIME ZONE 'UTC'
+		AND start_dt > now() AT TIME ZONE 'UTC' - INTERVAL '30 days'
+	ORDER BY start_dt`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("lessonAttendanceAdminFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var studentName string
+	var balance int
+	for rows.Next() {
+		s := lessonSlot{}
+		err = rows.Scan(&s.SlotID, &s.StartDT, &s.Duration, &s.Format, &s.BookedBy, &studentName, &balance)
+		if err != nil {
+			return omap, fmt.Errorf("lessonAttendanceAdminFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(s.SlotID, fmt.Sprintf("%s - %s [баланс: %d]", s.Text(loc), studentName, balance))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Все прошедшие уроки отмечены")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"vocal_training_bot/BotExt"
 
@@ -29,6 +31,42 @@ const (
 
 	ChangeWarmupSetGroup = "changeWarmupSetGroup"
 	ChangeWarmupSetName  = "ChangeWarmupSetName"
+
+	AdminSGAddSubscriptionPlan       = "AdminSG_AddSubscriptionPlan"
+	AdminSGSetSubscriptionPlanPeriod = "AdminSG_SetSubscriptionPlanPeriod"
+	AdminSGSetSubscriptionPlanPrice  = "AdminSG_SetSubscriptionPlanPrice"
+
+	AdminSGAddPromoCode         = "AdminSG_AddPromoCode"
+	AdminSGSetPromoCodeDiscount = "AdminSG_SetPromoCodeDiscount"
+	AdminSGSetPromoCodeExpire   = "AdminSG_SetPromoCodeExpire"
+	AdminSGSetPromoCodeLimit    = "AdminSG_SetPromoCodeLimit"
+	AdminSGSetPromoCodeGroups   = "AdminSG_SetPromoCodeGroups"
+
+	AdminSGAddGiftCertificate        = "AdminSG_AddGiftCertificate"
+	AdminSGSetGiftCertificateContent = "AdminSG_SetGiftCertificateContent"
+	AdminSGSetGiftCertificatePrice   = "AdminSG_SetGiftCertificatePrice"
+
+	AdminSGAddLessonSlotsFormat = "AdminSG_AddLessonSlotsFormat"
+	AdminSGAddLessonSlots       = "AdminSG_AddLessonSlots"
+
+	AdminSGAddLeadNote = "AdminSG_AddLeadNote"
+
+	AdminSGAddLessonPackage        = "AdminSG_AddLessonPackage"
+	AdminSGSetLessonPackageLessons = "AdminSG_SetLessonPackageLessons"
+	AdminSGSetLessonPackagePrice   = "AdminSG_SetLessonPackagePrice"
+
+	AdminSGGrantLessonsUser   = "AdminSG_GrantLessonsUser"
+	AdminSGGrantLessonsAmount = "AdminSG_GrantLessonsAmount"
+
+	AdminSGScheduleBroadcast = "AdminSG_ScheduleBroadcast"
+
+	AdminSGSegmentCity     = "AdminSG_SegmentCity"
+	AdminSGSegmentTimezone = "AdminSG_SegmentTimezone"
+	AdminSGSegmentJoined   = "AdminSG_SegmentJoined"
+	AdminSGSegmentGroup    = "AdminSG_SegmentGroup"
+	AdminSGSegmentActivity = "AdminSG_SegmentActivity"
+	AdminSGSegmentLesson   = "AdminSG_SegmentLesson"
+	AdminSGSegmentSave     = "AdminSG_SegmentSave"
 )
 
 const storageFolder = "./message_storage/"
@@ -36,10 +74,73 @@ const storageFolder = "./message_storage/"
 func SetupAdminStates() {
 	err := adminFSM.RegisterOneShotState(&BotExt.State{
 		Name: AdminSGRecordMessage,
-		OnTrigger: `Начни писать одно или несколько сообщений. Когда закончишь - просто напиши слово 'СТОП' - и сообщение отправится всем пользователям.
+		OnTrigger: `Начни писать одно или несколько сообщений. Когда закончишь - просто напиши слово 'СТОП' - покажу, как сообщение увидят пользователи, и можно будет выбрать время отправки.
 Если надо отменить запись сообщений напиши 'ОТМЕНА'`,
 		Manipulator: RecordOneTimeMessage,
 		OnSuccess:   "DONE!",
+		// selectedBroadcast is used in broadcastAdminMenu
+		KeepVarsOnQuit: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterOneShotState(&BotExt.State{
+		Name:        AdminSGScheduleBroadcast,
+		OnTrigger:   "Введи дату и время отправки в твоем часовом поясе в формате ДД.ММ.ГГГГ ЧЧ:ММ, например 25.12.2026 18:00. Для отмены напиши 'ОТМЕНА'",
+		Validator:   broadcastTimeValidator,
+		Manipulator: ScheduleBroadcast,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:        AdminSGSegmentCity,
+			OnTrigger:   "Соберем аудиторию рассылки. На каждом шаге можно написать '-', чтобы не фильтровать по этому параметру.\nВведи город пользователей",
+			Validator:   nameMax50Validator,
+			Manipulator: setSegmentVar("segmentCity"),
+		},
+		{
+			Name:        AdminSGSegmentTimezone,
+			OnTrigger:   "Введи часовой пояс: город из списка при регистрации или название, например Europe/Moscow",
+			Validator:   segmentTimezoneValidator,
+			Manipulator: setSegmentVar("segmentTimezone"),
+		},
+		{
+			Name:        AdminSGSegmentJoined,
+			OnTrigger:   "Введи период регистрации в боте в формате ДД.ММ.ГГГГ - ДД.ММ.ГГГГ. Одну из дат можно не писать, например 01.01.2026 -",
+			Validator:   segmentJoinedValidator,
+			Manipulator: setSegmentVar("segmentJoined"),
+		},
+		{
+			Name:           AdminSGSegmentGroup,
+			OnTrigger:      "Выбери из списка пакет распевок, который купили пользователи",
+			OnTriggerExtra: []interface{}{warmupGroupAdminMenu},
+			Validator:      segmentGroupValidator,
+			Manipulator:    SetSegmentGroup,
+		},
+		{
+			Name:        AdminSGSegmentActivity,
+			OnTrigger:   "Напиши 'активные N', чтобы выбрать тех, кто заходил в бота за последние N дней, или 'неактивные N' - тех, кто не заходил",
+			Validator:   segmentActivityValidator,
+			Manipulator: setSegmentVar("segmentActivity"),
+		},
+		{
+			Name:        AdminSGSegmentLesson,
+			OnTrigger:   "Записывались ли пользователи на урок? Напиши 'да' или 'нет'",
+			Validator:   segmentLessonValidator,
+			Manipulator: SetSegmentLesson,
+		},
+		{
+			Name:        AdminSGSegmentSave,
+			OnTrigger:   "Введи название, чтобы сохранить сегмент для следующих рассылок, или '-', чтобы использовать его только для этой рассылки. Для отмены напиши 'ОТМЕНА'",
+			Validator:   nameMax50Validator,
+			Manipulator: SaveSegment,
+			// selectedBroadcast is used in broadcastAdminMenu
+			KeepVarsOnQuit: true,
+		},
 	})
 	if err != nil {
 		panic(err)
@@ -51,6 +152,8 @@ func SetupAdminStates() {
 Если надо отменить запись сообщений - напиши 'ОТМЕНА'`,
 		Manipulator: RecordCheerup,
 		OnSuccess:   "DONE!",
+		// selectedCheerup is used in cheerupCategoriesAdminMenu
+		KeepVarsOnQuit: true,
 	})
 	if err != nil {
 		panic(err)
@@ -87,6 +190,17 @@ func SetupAdminStates() {
 		panic(err)
 	}
 
+	err = adminFSM.RegisterOneShotState(&BotExt.State{
+		Name:           AdminSGAddLeadNote,
+		OnTrigger:      `Напиши заметку по заявке. Если передумал - напиши 'ОТМЕНА'`,
+		KeepVarsOnQuit: true,
+		Manipulator:    AddLeadNote,
+		OnSuccess:      "DONE!",
+	})
+	if err != nil {
+		panic(err)
+	}
+
 	err = adminFSM.RegisterOneShotState(&BotExt.State{
 		Name:           AdminSGRepriceWarmupGroup,
 		OnTrigger:      `Введи новую цену группы`,
@@ -105,15 +219,15 @@ func SetupAdminStates() {
 		OnTriggerExtra: []interface{}{warmupGroupAdminMenu},
 		KeepVarsOnQuit: true,
 		Validator: func(c tele.Context) string {
-			_, ok := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+			_, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
 			if !ok {
 				return "Выбери группу из списка!"
 			}
 			return ""
 		},
 		Manipulator: func(c tele.Context) error {
-			warmupGroup, _ := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
-			warmupID, _ := BotExt.GetStateVar(c.Sender().ID, "selectedWarmup")
+			warmupGroup, _ := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+			warmupID, _ := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmup")
 			_, err = DB.Exec(context.Background(), `
 				UPDATE warmups
 				SET warmup_group = $1
@@ -131,7 +245,7 @@ func SetupAdminStates() {
 		OnSuccess:      "Done!",
 		Validator:      nameMax50Validator,
 		Manipulator: func(c tele.Context) error {
-			warmupID, _ := BotExt.GetStateVar(c.Sender().ID, "selectedWarmup")
+			warmupID, _ := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmup")
 			_, err = DB.Exec(context.Background(), `
 				UPDATE warmups
 				SET warmup_name = $1
@@ -147,7 +261,7 @@ func SetupAdminStates() {
 			OnTrigger:      "В какую группу поместить распевку?",
 			OnTriggerExtra: []interface{}{warmupGroupAdminMenu},
 			Validator: func(c tele.Context) string {
-				_, ok := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+				_, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
 				if !ok {
 					return "Выбери группу из списка!"
 				}
@@ -159,7 +273,7 @@ func SetupAdminStates() {
 			OnTrigger: "Как будет называться распевка? Макс 50 символов",
 			Validator: nameMax50Validator,
 			Manipulator: func(c tele.Context) error {
-				BotExt.SetStateVar(c.Sender().ID, "WarmupName", c.Text())
+				adminFSM.SetStateVar(c.Sender().ID, "WarmupName", c.Text())
 				return nil
 			},
 		},
@@ -173,6 +287,202 @@ func SetupAdminStates() {
 	if err != nil {
 		panic(err)
 	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGAddSubscriptionPlan,
+			OnTrigger: `Введи название тарифа, например 'Месяц' или 'Год', макс 50 символов`,
+			Validator: nameMax50Validator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "planName", c.Text())
+				return nil
+			},
+		},
+		{
+			Name:      AdminSGSetSubscriptionPlanPeriod,
+			OnTrigger: `На сколько дней оформляется подписка? Например, 30 или 365`,
+			Validator: periodValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "planPeriod", c.Text())
+				return nil
+			},
+		},
+		{
+			Name:        AdminSGSetSubscriptionPlanPrice,
+			OnTrigger:   `Введи цену тарифа в рублях`,
+			Validator:   positivePriceValidator,
+			Manipulator: AddSubscriptionPlan,
+			OnSuccess:   "DONE!",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGAddPromoCode,
+			OnTrigger: `Введи промокод: латинские буквы, цифры, '-' и '_', от 3 до 32 символов`,
+			Validator: promoCodeNameValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "promoCode", strings.ToUpper(strings.TrimSpace(c.Text())))
+				return nil
+			},
+		},
+		{
+			Name:      AdminSGSetPromoCodeDiscount,
+			OnTrigger: `Введи скидку: '15%' - скидка в процентах, '500' - скидка в рублях`,
+			Validator: discountValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "promoDiscount", strings.TrimSpace(c.Text()))
+				return nil
+			},
+		},
+		{
+			Name:      AdminSGSetPromoCodeExpire,
+			OnTrigger: `До какого дня включительно действует промокод? Формат ДД.ММ.ГГГГ, '-' - бессрочно`,
+			Validator: dateOrDashValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "promoExpire", strings.TrimSpace(c.Text()))
+				return nil
+			},
+		},
+		{
+			Name:      AdminSGSetPromoCodeLimit,
+			OnTrigger: `Сколько раз можно использовать промокод? '-' - без ограничений`,
+			Validator: limitOrDashValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "promoLimit", strings.TrimSpace(c.Text()))
+				return nil
+			},
+		},
+		{
+			Name: AdminSGSetPromoCodeGroups,
+			OnTrigger: `Выбери из списка пакеты распевок, на которые действует промокод. Когда закончишь - напиши 'ГОТОВО'.
+Если промокод действует на все пакеты - сразу напиши 'ГОТОВО'`,
+			OnTriggerExtra: []interface{}{warmupGroupAdminMenu},
+			Manipulator:    AddPromoCode,
+			OnSuccess:      "DONE!",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGAddGiftCertificate,
+			OnTrigger: `Введи название сертификата, макс 50 символов`,
+			Validator: nameMax50Validator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "certificateTitle", c.Text())
+				return nil
+			},
+		},
+		{
+			Name:           AdminSGSetGiftCertificateContent,
+			OnTrigger:      `Выбери из списка пакет распевок, который получит владелец сертификата, или напиши количество занятий`,
+			OnTriggerExtra: []interface{}{warmupGroupAdminMenu},
+			Validator:      giftCertificateContentValidator,
+			Manipulator: func(c tele.Context) error {
+				userID := c.Sender().ID
+				if _, ok := adminFSM.GetStateVar(userID, "certificateGroup"); !ok {
+					adminFSM.SetStateVar(userID, "certificateLessons", strings.TrimSpace(c.Text()))
+				}
+				return nil
+			},
+		},
+		{
+			Name:        AdminSGSetGiftCertificatePrice,
+			OnTrigger:   `Введи цену сертификата в рублях`,
+			Validator:   positivePriceValidator,
+			Manipulator: AddGiftCertificateType,
+			OnSuccess:   "DONE!",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGAddLessonSlotsFormat,
+			OnTrigger: `Какой формат уроков? Напиши 'онлайн' или 'студия'`,
+			Validator: lessonFormatValidator,
+			Manipulator: func(c tele.Context) error {
+				format := LessonStudio
+				if strings.ToLower(strings.TrimSpace(c.Text())) == "онлайн" {
+					format = LessonOnline
+				}
+				adminFSM.SetStateVar(c.Sender().ID, "lessonFormat", format)
+				return nil
+			},
+		},
+		{
+			Name: AdminSGAddLessonSlots,
+			OnTrigger: `Введи время начала уроков по своему часовому поясу, каждое окошко с новой строки в формате ДД.ММ.ГГГГ ЧЧ:ММ, например:
+25.12.2023 18:00
+26.12.2023 12:30`,
+			Validator:   lessonSlotsValidator,
+			Manipulator: AddLessonSlots,
+			OnSuccess:   "DONE!",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGAddLessonPackage,
+			OnTrigger: `Введи название пакета занятий, например 'Абонемент на 4 занятия', макс 50 символов`,
+			Validator: nameMax50Validator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "packageTitle", c.Text())
+				return nil
+			},
+		},
+		{
+			Name:      AdminSGSetLessonPackageLessons,
+			OnTrigger: `Сколько занятий в пакете?`,
+			Validator: lessonsCountValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "packageLessons", strings.TrimSpace(c.Text()))
+				return nil
+			},
+		},
+		{
+			Name:        AdminSGSetLessonPackagePrice,
+			OnTrigger:   `Введи цену пакета в рублях`,
+			Validator:   positivePriceValidator,
+			Manipulator: AddLessonPackage,
+			OnSuccess:   "DONE!",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = adminFSM.RegisterStateChain([]*BotExt.State{
+		{
+			Name:      AdminSGGrantLessonsUser,
+			OnTrigger: `Введи ID ученика (его можно найти в списке пользователей или в уведомлениях о записи)`,
+			Validator: existingUserIDValidator,
+			Manipulator: func(c tele.Context) error {
+				adminFSM.SetStateVar(c.Sender().ID, "grantUserID", strings.TrimSpace(c.Text()))
+				return nil
+			},
+		},
+		{
+			Name:        AdminSGGrantLessonsAmount,
+			OnTrigger:   `Сколько занятий начислить? Отрицательное число - списать занятия`,
+			Validator:   lessonsDeltaValidator,
+			Manipulator: GrantLessons,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
 }
 
 func nameMax50Validator(c tele.Context) string {
@@ -193,8 +503,347 @@ func priceValidator(c tele.Context) string {
 	return ""
 }
 
+func positivePriceValidator(c tele.Context) string {
+	price, err := strconv.Atoi(c.Text())
+	if (err != nil) || (price <= 0) {
+		return "Тут должно быть положительное число!"
+	}
+	return ""
+}
+
+func periodValidator(c tele.Context) string {
+	days, err := strconv.Atoi(c.Text())
+	if (err != nil) || (days <= 0) || (days > 3660) {
+		return "Тут должно быть число дней от 1 до 3660!"
+	}
+	return ""
+}
+
+func lessonsCountValidator(c tele.Context) string {
+	lessons, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if (err != nil) || (lessons <= 0) || (lessons > 100) {
+		return "Тут должно быть число занятий от 1 до 100!"
+	}
+	return ""
+}
+
+func lessonsDeltaValidator(c tele.Context) string {
+	delta, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if (err != nil) || (delta == 0) || (delta < -100) || (delta > 100) {
+		return "Тут должно быть число от -100 до 100, не равное нулю!"
+	}
+	return ""
+}
+
+func existingUserIDValidator(c tele.Context) string {
+	userID, err := strconv.ParseInt(strings.TrimSpace(c.Text()), 10, 64)
+	if err != nil {
+		return "Тут должен быть числовой ID пользователя!"
+	}
+	var exists bool
+	err = DB.QueryRow(context.Background(), `
+	SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		logger.Error("existingUserIDValidator", zap.Int64("user", userID), zap.Error(err))
+		return "Не получилось проверить ID, попробуй еще раз"
+	}
+	if !exists {
+		return "Пользователя с таким ID нет"
+	}
+	return ""
+}
+
+var matchingPatternPromoCode = regexp.MustCompile("^[A-Za-z0-9_-]{3,32}$")
+
+func promoCodeNameValidator(c tele.Context) string {
+	code := strings.TrimSpace(c.Text())
+	if ok := matchingPatternPromoCode.MatchString(code); !ok {
+		return "Промокод должен состоять из латинских букв, цифр, '-' и '_', от 3 до 32 символов"
+	}
+	if _, err := getPromoCode(code); err == nil {
+		return "Такой промокод уже есть!"
+	}
+	return ""
+}
+
+func discountValidator(c tele.Context) string {
+	_, _, err := parseDiscount(c.Text())
+	if err != nil {
+		return "Тут должно быть '15%' для скидки в процентах (1-100) или '500' для скидки в рублях"
+	}
+	return ""
+}
+
+// parseDiscount parses '15%' as percent discount and '500' as fixed discount in rubles
+func parseDiscount(s string) (discountType string, value int, err error) {
+	s = strings.TrimSpace(s)
+	discountType = DiscountFixed
+	if strings.HasSuffix(s, "%") {
+		discountType = DiscountPercent
+		s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	}
+	value, err = strconv.Atoi(s)
+	if err != nil {
+		return "", 0, fmt.Errorf("parseDiscount: %w", err)
+	}
+	if (value <= 0) || ((discountType == DiscountPercent) && (value > 100)) {
+		return "", 0, fmt.Errorf("parseDiscount: value %d is out of range", value)
+	}
+	return discountType, value, nil
+}
+
+func dateOrDashValidator(c tele.Context) string {
+	if strings.TrimSpace(c.Text()) == "-" {
+		return ""
+	}
+	if _, err := time.Parse("02.01.2006", strings.TrimSpace(c.Text())); err != nil {
+		return "Тут должна быть дата в формате ДД.ММ.ГГГГ, например 31.12.2023, или '-'"
+	}
+	return ""
+}
+
+func limitOrDashValidator(c tele.Context) string {
+	if strings.TrimSpace(c.Text()) == "-" {
+		return ""
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if (err != nil) || (limit <= 0) {
+		return "Тут должно быть положительное число или '-'"
+	}
+	return ""
+}
+
+// giftCertificateContentValidator accepts warmup group chosen in warmupGroupAdminMenu or positive number of lessons
+func giftCertificateContentValidator(c tele.Context) string {
+	if _, ok := adminFSM.GetStateVar(c.Sender().ID, "certificateGroup"); ok {
+		return ""
+	}
+	lessons, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if (err != nil) || (lessons <= 0) || (lessons > 100) {
+		return "Выбери пакет распевок из списка или напиши количество занятий - число от 1 до 100"
+	}
+	return ""
+}
+
+func lessonFormatValidator(c tele.Context) string {
+	switch strings.ToLower(strings.TrimSpace(c.Text())) {
+	case "онлайн", "студия":
+		return ""
+	}
+	return "Напиши 'онлайн' или 'студия'"
+}
+
+func lessonSlotsValidator(c tele.Context) string {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		logger.Error("lessonSlotsValidator", zap.Int64("user", c.Sender().ID), zap.Error(err))
+	}
+	slots, err := parseLessonSlots(c.Text(), loc)
+	if err != nil {
+		return "Не получилось разобрать время. Каждое окошко с новой строки в формате ДД.ММ.ГГГГ ЧЧ:ММ"
+	}
+	for _, dt := range slots {
+		if dt.Before(time.Now().UTC()) {
+			return "Окошко " + dt.In(loc).Format(lessonSlotLayout) + " уже в прошлом"
+		}
+	}
+	return ""
+}
+
+// addPromoCodeGroup is called on warmupGroupAdminMenu click while AdminSGSetPromoCodeGroups state is active
+func addPromoCodeGroup(c tele.Context, groupID string) error {
+	userID := c.Sender().ID
+	groups, _ := adminFSM.GetStateVar(userID, "promoGroups")
+	for _, g := range strings.Split(groups, ",") {
+		if g == groupID {
+			return c.Send("Этот пакет уже выбран")
+		}
+	}
+	if groups != "" {
+		groups += ","
+	}
+	adminFSM.SetStateVar(userID, "promoGroups", groups+groupID)
+	return c.Send("Пакет добавлен. Выбери еще или напиши 'ГОТОВО'")
+}
+
+func AddPromoCode(c tele.Context) error {
+	if strings.ToLower(strings.TrimSpace(c.Text())) != "готово" {
+		return BotExt.ContinueState
+	}
+
+	values := adminFSM.GetStateVars(c.Sender().ID)
+	code, ok := values["promoCode"]
+	if !ok {
+		return fmt.Errorf("AddPromoCode: can't get promoCode value")
+	}
+	discountType, discountValue, err := parseDiscount(values["promoDiscount"])
+	if err != nil {
+		return fmt.Errorf("AddPromoCode: %w", err)
+	}
+	var expire *time.Time
+	if dt, err := time.Parse("02.01.2006", values["promoExpire"]); err == nil {
+		dt = dt.Add(24*time.Hour - time.Second) // valid till the end of the day
+		expire = &dt
+	}
+	var limit *int
+	if l, err := strconv.Atoi(values["promoLimit"]); err == nil {
+		limit = &l
+	}
+
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("AddPromoCode: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), `
+	INSERT INTO promo_codes (promo_code, discount_type, discount_value, expire_dt, usage_limit)
+	VALUES ($1, $2, $3, $4, $5)`, code, discountType, discountValue, expire, limit)
+	if err != nil {
+		return fmt.Errorf("AddPromoCode: %w", err)
+	}
+	if groups := values["promoGroups"]; groups != "" {
+		for _, groupID := range strings.Split(groups, ",") {
+			_, err = tx.Exec(context.Background(), `
+			INSERT INTO promo_code_groups (promo_code, group_id)
+			VALUES ($1, $2)`, code, groupID)
+			if err != nil {
+				return fmt.Errorf("AddPromoCode: %w", err)
+			}
+		}
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("AddPromoCode: %w", err)
+	}
+	return nil
+}
+
+func AddGiftCertificateType(c tele.Context) error {
+	values := adminFSM.GetStateVars(c.Sender().ID)
+	title, ok := values["certificateTitle"]
+	if !ok {
+		return fmt.Errorf("AddGiftCertificateType: can't get certificateTitle value")
+	}
+	var err error
+	if groupID, ok := values["certificateGroup"]; ok {
+		_, err = DB.Exec(context.Background(), `
+		INSERT INTO gift_certificate_types (title, kind, group_id, price)
+		VALUES ($1, $2, $3, $4)`, title, CertificateWarmups, groupID, c.Text())
+	} else {
+		_, err = DB.Exec(context.Background(), `
+		INSERT INTO gift_certificate_types (title, kind, lessons, price)
+		VALUES ($1, $2, $3, $4)`, title, CertificateLessons, values["certificateLessons"], c.Text())
+	}
+	if err != nil {
+		return fmt.Errorf("AddGiftCertificateType: %w", err)
+	}
+	return nil
+}
+
+func AddLessonSlots(c tele.Context) error {
+	userID := c.Sender().ID
+	format, ok := adminFSM.GetStateVar(userID, "lessonFormat")
+	if !ok {
+		return fmt.Errorf("AddLessonSlots: can't get lessonFormat value")
+	}
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("AddLessonSlots: %w", err)
+	}
+	slots, err := parseLessonSlots(c.Text(), loc)
+	if err != nil {
+		return fmt.Errorf("AddLessonSlots: %w", err)
+	}
+
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("AddLessonSlots: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var skipped []string
+	for _, dt := range slots {
+		tag, err := tx.Exec(context.Background(), `
+		INSERT INTO lesson_slots (start_dt, duration_min, format)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (start_dt) DO NOTHING`, dt, lessonDurations[format], format)
+		if err != nil {
+			return fmt.Errorf("AddLessonSlots: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			skipped = append(skipped, dt.In(loc).Format(lessonSlotLayout))
+		}
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("AddLessonSlots: %w", err)
+	}
+	if len(skipped) != 0 {
+		return c.Send("Эти окошки уже были добавлены раньше: " + strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+func AddLessonPackage(c tele.Context) error {
+	values := adminFSM.GetStateVars(c.Sender().ID)
+	title, ok := values["packageTitle"]
+	if !ok {
+		return fmt.Errorf("AddLessonPackage: can't get packageTitle value")
+	}
+	lessons, ok := values["packageLessons"]
+	if !ok {
+		return fmt.Errorf("AddLessonPackage: can't get packageLessons value")
+	}
+	_, err := DB.Exec(context.Background(), `
+	INSERT INTO lesson_packages (title, lessons, price)
+	VALUES ($1, $2, $3)`, title, lessons, c.Text())
+	if err != nil {
+		return fmt.Errorf("AddLessonPackage: %w", err)
+	}
+	return nil
+}
+
+func GrantLessons(c tele.Context) error {
+	adminID := c.Sender().ID
+	userIDStr, ok := adminFSM.GetStateVar(adminID, "grantUserID")
+	if !ok {
+		return fmt.Errorf("GrantLessons: can't get grantUserID value")
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("GrantLessons: %w", err)
+	}
+	delta, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if err != nil {
+		return fmt.Errorf("GrantLessons: %w", err)
+	}
+	balance, err := grantLessons(c.Bot(), adminID, userID, delta)
+	if err != nil {
+		return fmt.Errorf("GrantLessons: %w", err)
+	}
+	return c.Send(fmt.Sprintf("DONE! Баланс ученика [ID%d]: %d", userID, balance))
+}
+
+func AddSubscriptionPlan(c tele.Context) error {
+	values := adminFSM.GetStateVars(c.Sender().ID)
+	planName, ok := values["planName"]
+	if !ok {
+		return fmt.Errorf("AddSubscriptionPlan: can't get planName value")
+	}
+	planPeriod, ok := values["planPeriod"]
+	if !ok {
+		return fmt.Errorf("AddSubscriptionPlan: can't get planPeriod value")
+	}
+	_, err := DB.Exec(context.Background(), `
+	INSERT INTO subscription_plans (plan_name, period_days, price)
+	VALUES ($1, $2, $3)`, planName, planPeriod, c.Text())
+	if err != nil {
+		return fmt.Errorf("AddSubscriptionPlan: %w", err)
+	}
+	return nil
+}
+
 func SetWarmupGroupPrice(c tele.Context) error {
-	groupName, ok := BotExt.GetStateVar(c.Sender().ID, "groupName")
+	groupName, ok := adminFSM.GetStateVar(c.Sender().ID, "groupName")
 	if !ok {
 		return fmt.Errorf("SetWarmupGroupPrice: can't get groupName value")
 	}
@@ -212,7 +861,7 @@ func SetWarmupGroupName(c tele.Context) error {
 		return nil
 	}
 
-	BotExt.SetStateVar(c.Sender().ID, "groupName", c.Text())
+	adminFSM.SetStateVar(c.Sender().ID, "groupName", c.Text())
 	return nil
 }
 
@@ -221,7 +870,7 @@ func RenameWarmupGroup(c tele.Context) error {
 		return nil
 	}
 
-	groupID, ok := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+	groupID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
 	if !ok {
 		return fmt.Errorf("RenameWarmupGroup: can't find state var selectedWarmupGroup")
 	}
@@ -237,7 +886,7 @@ func RenameWarmupGroup(c tele.Context) error {
 }
 
 func RepriceWarmupGroup(c tele.Context) error {
-	groupID, ok := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+	groupID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
 	if !ok {
 		return fmt.Errorf("RepriceWarmupGroup: can't find state var selectedWarmupGroup")
 	}
@@ -254,19 +903,25 @@ func RepriceWarmupGroup(c tele.Context) error {
 
 func RecordCheerup(c tele.Context) error {
 	userID := c.Sender().ID
-	recordID, ok := BotExt.GetStateVar(userID, "RecordID")
+	recordID, ok := adminFSM.GetStateVar(userID, "RecordID")
 	if !ok {
 		return fmt.Errorf("RecordCheerup: no RecordID in database")
 	}
 
 	if strings.ToLower(c.Text()) == "стоп" {
-		_, err := DB.Exec(context.Background(), `
+		var cheerupID int
+		err := DB.QueryRow(context.Background(), `
 		INSERT INTO warmup_cheerups (record_id)
-		VALUES ($1 :: uuid)`, recordID)
+		VALUES ($1 :: uuid)
+		RETURNING cheerup_id`, recordID).Scan(&cheerupID)
 		if err != nil {
 			return fmt.Errorf("RecordCheerup: cannot update database, %w", err)
 		}
-		return nil
+		if err = addCheerupToRotations(cheerupID); err != nil {
+			return fmt.Errorf("RecordCheerup: %w", err)
+		}
+		adminFSM.SetStateVar(userID, "selectedCheerup", strconv.Itoa(cheerupID))
+		return adminInlineMenus.Show(c, cheerupCategoriesAdminMenu)
 	}
 
 	if strings.ToLower(c.Text()) == "отмена" {
@@ -289,13 +944,13 @@ func RecordCheerup(c tele.Context) error {
 
 func RecordWarmup(c tele.Context) error {
 	userID := c.Sender().ID
-	recordID, ok := BotExt.GetStateVar(userID, "RecordID")
+	recordID, ok := adminFSM.GetStateVar(userID, "RecordID")
 	if !ok {
 		return fmt.Errorf("RecordWarmup: no RecordID in database")
 	}
 
 	if strings.ToLower(c.Text()) == "стоп" {
-		values := BotExt.GetStateVars(userID)
+		values := adminFSM.GetStateVars(userID)
 		warmupGroup, ok := values["selectedWarmupGroup"]
 		if !ok {
 			return fmt.Errorf("RecordWarmup: can't fetch selectedWarmupGroup")
@@ -334,30 +989,21 @@ func RecordWarmup(c tele.Context) error {
 
 func RecordOneTimeMessage(c tele.Context) error {
 	userID := c.Sender().ID
-	recordID, ok := BotExt.GetStateVar(userID, "RecordID")
+	recordID, ok := adminFSM.GetStateVar(userID, "RecordID")
 	if !ok {
 		return fmt.Errorf("RecordOneTimeMessage: no RecordID in database")
 	}
 
 	if strings.ToLower(c.Text()) == "стоп" {
-		if err := c.Send("Отправка сообщений..."); err != nil {
-			logger.Error("can't send message", zap.Int64("user", userID), zap.Error(err))
-		}
-
-		err := SendMessages(c.Bot(), recordID)
+		broadcastID, err := createBroadcast(userID, recordID)
 		if err != nil {
-			logger.Error("can't send messages", zap.String("recordID", recordID), zap.Error(err))
+			return fmt.Errorf("RecordOneTimeMessage: %w", err)
 		}
-
-		// delete because onetime
-		// TODO: clear lost messages from time to time (that are not cheerup or warmup)
-		_, err = DB.Exec(context.Background(), `
-		DELETE FROM messages 
-		WHERE record_id = $1`, recordID)
-		if err != nil {
-			return fmt.Errorf("RecordOneTimeMessage: cannot delete record, %w", err)
+		adminFSM.SetStateVar(userID, "selectedBroadcast", strconv.Itoa(broadcastID))
+		if err = previewBroadcast(c, strconv.Itoa(broadcastID)); err != nil {
+			return fmt.Errorf("RecordOneTimeMessage: %w", err)
 		}
-		return nil
+		return adminInlineMenus.Show(c, broadcastAdminMenu)
 	}
 
 	if strings.ToLower(c.Text()) == "отмена" {
@@ -460,39 +1106,13 @@ func (m message) HasFile() bool {
 	return false
 }
 
-func SendMessages(b *tele.Bot, recordID string) error {
-	BakedMessage, err := bakeMessage(recordID)
-	if err != nil {
-		return fmt.Errorf("SendMessages: %w", err)
-	}
-
-	rows, err := DB.Query(context.Background(), `
-		SELECT user_id from users
-		WHERE user_class = 'USER'`)
-	if err != nil {
-		return fmt.Errorf("SendMessages[recordID = %s]: pg query error %w", recordID, err)
-	}
-	defer rows.Close()
-
-	var userID int64
-	for rows.Next() {
-		err := rows.Scan(&userID)
-		if err != nil {
-			return fmt.Errorf("SendMessages[recordID = %s]: users row scan error %w", recordID, err)
-		}
-		user := UserIDType{userID}
-		for _, bm := range BakedMessage {
-			_, err = b.Copy(UserIDType{userID}, bm)
-			if err != nil {
-				if err.Error() == "telegram: Bad Request: message to copy not found (400)" {
-					err = sendFromDatabase(b, user, &bm, false)
-				}
-				logger.Error("can't SendMessages to specific user", zap.Error(err),
-					zap.Int64("userID", userID), zap.String("recordID", recordID))
-			}
-		}
+// sendBakedMessage copies message to user, message is sent from database if original one was deleted
+func sendBakedMessage(b *tele.Bot, user tele.Recipient, bm message) error {
+	_, err := b.Copy(user, bm)
+	if err != nil && err.Error() == "telegram: Bad Request: message to copy not found (400)" {
+		err = sendFromDatabase(b, user, &bm, false)
 	}
-	return nil
+	return err
 }
 
 func SendMessageToUser(b *tele.Bot, userID int64, recordID string, secured bool) error {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// isBotBlocked returns true if telegram won't deliver messages to user until he starts the bot again:
+// user blocked the bot, deleted his account or never started the bot
+func isBotBlocked(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) ||
+		errors.Is(err, tele.ErrNotStartedByUser) ||
+		errors.Is(err, tele.ErrChatNotFound)
+}
+
+// deactivateUser excludes user from broadcasts and notification queues. He is activated again by /start
+func deactivateUser(userID int64) error {
+	tag, err := DB.Exec(context.Background(), `
+		UPDATE users
+		SET active = false, deactivated = now() AT TIME ZONE 'UTC'
+		WHERE user_id = $1 AND active = true`, userID)
+	if err != nil {
+		return fmt.Errorf("deactivateUser: %w", err)
+	}
+	if tag.RowsAffected() != 0 {
+		logger.Info("user is deactivated", zap.Int64("userID", userID))
+	}
+	for _, ns := range []*NotificationService{notificationService, subscriptionReminderService, lessonReminderService} {
+		if err = ns.DelUser(userID); err != nil {
+			return fmt.Errorf("deactivateUser: %w", err)
+		}
+	}
+	return nil
+}
+
+// reactivateUser returns user to broadcasts and notification queues if he was deactivated
+func reactivateUser(userID int64) error {
+	tag, err := DB.Exec(context.Background(), `
+		UPDATE users
+		SET active = true, deactivated = NULL
+		WHERE user_id = $1 AND active = false`, userID)
+	if err != nil {
+		return fmt.Errorf("reactivateUser: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	logger.Info("user is reactivated", zap.Int64("userID", userID))
+	for _, ns := range []*NotificationService{notificationService, subscriptionReminderService, lessonReminderService} {
+		if err = ns.AddUser(userID); err != nil {
+			return fmt.Errorf("reactivateUser: %w", err)
+		}
+	}
+	return nil
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"time"
 
-	"github.com/jackc/pgx/v5"
+	"vocal_training_bot/BotExt"
+
+	"go.uber.org/zap"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -12,7 +15,7 @@ type UserIDType struct {
 	UserID int64
 }
 
-// var ProviderToken string
+var ProviderToken string
 var SupervisorID int64
 
 func (u UserIDType) Recipient() string {
@@ -22,8 +25,11 @@ func (u UserIDType) Recipient() string {
 func InitBot(cfg Config) *tele.Bot {
 	teleCfg := tele.Settings{
 		Token: cfg.Bot.Token,
+		URL:   cfg.Bot.APIURL,
+		// updates are dispatched to per-user workers by BotExt.UpdateSerializer
+		Synchronous: true,
 	}
-	// ProviderToken = cfg.Bot.ProviderToken
+	ProviderToken = cfg.Bot.ProviderToken
 	SupervisorID = cfg.Bot.SupervisorID
 
 	bot, err := tele.NewBot(teleCfg)
@@ -31,27 +37,39 @@ func InitBot(cfg Config) *tele.Bot {
 		panic(fmt.Errorf("InitBot: %w", err))
 	}
 
-	bot.Use(MiddlewareMetrics(), MiddlewareLogger(logger))
+	bot.Use(BotExt.NewUpdateSerializer().Middleware(), MiddlewareMetrics(), MiddlewareLogger(logger), MiddlewareActivity())
 
 	bot.Handle("/start", onStart)
 	bot.Handle(tele.OnText, onText)
 	bot.Handle(tele.OnCallback, onCallback)
 	bot.Handle(tele.OnMedia, onMedia)
 	bot.Handle(tele.OnContact, onContact)
-	// bot.Handle(tele.OnCheckout, onCheckout)
+	bot.Handle(tele.OnLocation, onLocation)
+	bot.Handle(tele.OnCheckout, onCheckout)
+	bot.Handle(tele.OnPayment, onPayment)
 
-	setupUserHandlers(bot)
-	setupAdminHandlers(bot)
+	store := newStateStore(cfg)
+	setupUserHandlers(bot, store)
+	setupAdminHandlers(bot, store)
 
 	notificationService.handler = func(userID int64) error {
-		_, err = bot.Send(UserIDType{userID}, "❗ НАПОМИНАНИЕ ❗ Пришло время делать распевку")
+		msg, err := bot.Send(UserIDType{userID}, "❗ НАПОМИНАНИЕ ❗ Пришло время делать распевку", warmupReminderMarkup)
 		if err != nil {
 			return err
 		}
+		if err = logReminderSent(userID, msg.ID); err != nil {
+			logger.Error("can't log warmup reminder", zap.Int64("userID", userID), zap.Error(err))
+		}
 
-		cheerupRecordID, err := getRandomCheerup()
-		if (err != nil) && (err == pgx.ErrNoRows) {
-			return fmt.Errorf("notificationService.handler: %w", err)
+		categories, err := reminderCheerupCategories(userID, time.Now())
+		if err != nil {
+			logger.Error("can't choose cheerup categories", zap.Int64("userID", userID), zap.Error(err))
+		}
+		cheerupRecordID, err := getRandomCheerup(userID, categories...)
+		if err != nil {
+			// reminder is sent already, so it mustn't be repeated because of cheerup
+			logger.Error("can't get cheerup", zap.Int64("userID", userID), zap.Error(err))
+			return nil
 		}
 
 		if cheerupRecordID != "" {
@@ -60,12 +78,36 @@ func InitBot(cfg Config) *tele.Bot {
 		return nil
 	}
 
+	subscriptionReminderService.handler = func(userID int64) error {
+		return subscriptionReminderHandler(bot, userID)
+	}
+
+	lessonReminderService.handler = func(userID int64) error {
+		return lessonReminderHandler(bot, userID)
+	}
+
 	return bot
 }
 
+// newStateStore returns storage of FSM states chosen in config
+func newStateStore(cfg Config) BotExt.StateStore {
+	switch cfg.Bot.StateStore {
+	case "", "postgres":
+		return BotExt.NewPostgresStateStore(DB)
+	case "redis":
+		return BotExt.NewRedisStateStore(RD, "states")
+	case "memory":
+		return BotExt.NewMemoryStateStore()
+	}
+	panic(fmt.Errorf("newStateStore: unknown state store %s", cfg.Bot.StateStore))
+}
+
 func onStart(c tele.Context) error {
 	ug, _ := GetUserGroup(c.Sender().ID)
 	c.Set("route", "onStart")
+	if err := reactivateUser(c.Sender().ID); err != nil {
+		logger.Error("can't reactivate user", zap.Int64("user", c.Sender().ID), zap.Error(err))
+	}
 	switch ug {
 	case UGAdmin:
 		return onAdminStart(c)
@@ -123,7 +165,18 @@ func onContact(c tele.Context) error {
 	return c.Send("Что-то пошло не так! Пожалуйста, попробуй написать позже...")
 }
 
-/*
+func onLocation(c tele.Context) error {
+	ug, _ := GetUserGroup(c.Sender().ID)
+	c.Set("route", "onLocation")
+	switch ug {
+	case UGUser:
+		return onUserText(c)
+	case UGNewUser:
+		return onUnregisteredText(c)
+	}
+	return c.Send("Что-то пошло не так! Пожалуйста, попробуй написать позже...")
+}
+
 func onCheckout(c tele.Context) error {
 	ug, _ := GetUserGroup(c.Sender().ID)
 	c.Set("route", "onCheckout")
@@ -131,6 +184,15 @@ func onCheckout(c tele.Context) error {
 	case UGUser:
 		return onUserCheckout(c)
 	}
+	return c.Bot().Accept(c.PreCheckoutQuery(), PaymentErrorText)
+}
+
+func onPayment(c tele.Context) error {
+	ug, _ := GetUserGroup(c.Sender().ID)
+	c.Set("route", "onPayment")
+	switch ug {
+	case UGUser:
+		return onUserPayment(c)
+	}
 	return c.Send("Что-то пошло не так! Пожалуйста, попробуй написать позже...")
 }
-*/
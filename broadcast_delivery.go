@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// statuses of broadcast recipients
+const (
+	DeliveryPending = "PENDING"
+	DeliverySent    = "SENT"
+	DeliveryFailed  = "FAILED"
+	DeliveryBlocked = "BLOCKED" // user blocked the bot or deleted account
+
+	// broadcastRate - messages per second for all broadcasts, telegram allows ~30
+	broadcastRate = 25
+	// broadcastBatch - recipients fetched from the queue at once
+	broadcastBatch = 100
+	// broadcastProgressEvery - how often admin's progress message is updated
+	broadcastProgressEvery = 10 * time.Second
+)
+
+// tokenBucket limits rate of requests: rate tokens are added every second up to capacity
+type tokenBucket struct {
+	mu          sync.Mutex
+	rate        float64
+	capacity    float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate, capacity float64) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (tb *tokenBucket) reserve(now time.Time) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.capacity {
+		tb.tokens = tb.capacity
+	}
+	tb.last = now
+	tb.tokens--
+
+	var wait time.Duration
+	if tb.tokens < 0 {
+		wait = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	if pause := tb.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+	return wait
+}
+
+// Wait blocks until request can be done
+func (tb *tokenBucket) Wait() {
+	if wait := tb.reserve(time.Now()); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Pause stops every request for d, it is used when telegram answers 429 with retry_after
+func (tb *tokenBucket) Pause(d time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if until := time.Now().Add(d); until.After(tb.pausedUntil) {
+		tb.pausedUntil = until
+	}
+	tb.tokens = 0
+}
+
+// errBroadcastStopped - delivery is interrupted by BroadcastScheduler.Stop, it is resumed after restart
+var errBroadcastStopped = errors.New("broadcast delivery stopped")
+
+// claimBroadcast moves the nearest due broadcast to SENDING and fills its queue of recipients.
+// ok is false if there are no due broadcasts
+func claimBroadcast() (ok bool, err error) {
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("claimBroadcast: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var broadcastID int
+	var segmentRaw []byte
+	err = tx.QueryRow(context.Background(), `
+		UPDATE broadcasts
+		SET status = $1, started = now() AT TIME ZONE 'UTC'
+		WHERE broadcast_id = (
+			SELECT broadcast_id FROM broadcasts
+			WHERE status = $2 AND send_at <= now() AT TIME ZONE 'UTC'
+			ORDER BY send_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING broadcast_id, segment`,
+		BroadcastSending, BroadcastScheduled).Scan(&broadcastID, &segmentRaw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claimBroadcast: %w", err)
+	}
+
+	segment := &audienceSegment{}
+	if segmentRaw != nil {
+		if err = json.Unmarshal(segmentRaw, segment); err != nil {
+			return false, fmt.Errorf("claimBroadcast: %w", err)
+		}
+	}
+	cond, args := segment.filter()
+	args = append(args, broadcastID)
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO broadcast_deliveries(broadcast_id, user_id)
+		SELECT $`+strconv.Itoa(len(args))+`, user_id FROM users
+		WHERE `+cond+`
+		ON CONFLICT DO NOTHING`, args...)
+	if err != nil {
+		return false, fmt.Errorf("claimBroadcast: enqueue recipients: %w", err)
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return false, fmt.Errorf("claimBroadcast: %w", err)
+	}
+	logger.Info("broadcast started", zap.Int("broadcastID", broadcastID))
+	return true, nil
+}
+
+// deliverBroadcast sends broadcast to pending recipients of its queue.
+// Progress is saved per recipient, so interrupted delivery continues from the same place
+func (bs *BroadcastScheduler) deliverBroadcast(broadcastID int, recordID string, createdBy int64) error {
+	bakedMessages, err := bakeMessage(recordID)
+	if err != nil {
+		return fmt.Errorf("deliverBroadcast: %w", err)
+	}
+
+	lastProgress := time.Now()
+	bs.reportProgress(broadcastID, createdBy, false)
+	for {
+		userIDs, err := pendingRecipients(broadcastID)
+		if err != nil {
+			return fmt.Errorf("deliverBroadcast: %w", err)
+		}
+		if len(userIDs) == 0 {
+			break
+		}
+		for _, userID := range userIDs {
+			select {
+			case <-bs.quit:
+				return errBroadcastStopped
+			default:
+			}
+
+			status, reason := DeliverySent, ""
+			if err = bs.deliverTo(userID, bakedMessages); err != nil {
+				status, reason = DeliveryFailed, deliveryFailureReason(err)
+				logger.Warn("can't deliver broadcast", zap.Int("broadcastID", broadcastID),
+					zap.Int64("userID", userID), zap.Error(err))
+				if isBotBlocked(err) {
+					status = DeliveryBlocked
+					if err = deactivateUser(userID); err != nil {
+						logger.Error("deactivateUser", zap.Int64("userID", userID), zap.Error(err))
+					}
+				}
+			}
+			if err = setDeliveryStatus(broadcastID, userID, status, reason); err != nil {
+				return fmt.Errorf("deliverBroadcast: %w", err)
+			}
+			broadcastDeliveries.WithLabelValues(status, reason).Inc()
+
+			if time.Since(lastProgress) > broadcastProgressEvery {
+				bs.reportProgress(broadcastID, createdBy, false)
+				lastProgress = time.Now()
+			}
+		}
+	}
+
+	if err = finishBroadcast(broadcastID, recordID); err != nil {
+		return fmt.Errorf("deliverBroadcast: %w", err)
+	}
+	bs.reportProgress(broadcastID, createdBy, true)
+	return nil
+}
+
+// deliverTo sends every message of broadcast to user. Messages are resent after telegram's retry_after
+func (bs *BroadcastScheduler) deliverTo(userID int64, bakedMessages []message) error {
+	for _, bm := range bakedMessages {
+		for {
+			bs.limiter.Wait()
+			err := sendBakedMessage(bs.bot, UserIDType{userID}, bm)
+			var floodErr tele.FloodError
+			if errors.As(err, &floodErr) {
+				logger.Warn("broadcast is throttled by telegram", zap.Int("retryAfter", floodErr.RetryAfter))
+				bs.limiter.Pause(time.Duration(floodErr.RetryAfter) * time.Second)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func pendingRecipients(broadcastID int) ([]int64, error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT user_id FROM broadcast_deliveries
+		WHERE broadcast_id = $1 AND status = $2
+		ORDER BY user_id
+		LIMIT $3`, broadcastID, DeliveryPending, broadcastBatch)
+	if err != nil {
+		return nil, fmt.Errorf("pendingRecipients: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	var userID int64
+	for rows.Next() {
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("pendingRecipients: scan row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("pendingRecipients: postgres itetator %w", err)
+	}
+	return userIDs, nil
+}
+
+func setDeliveryStatus(broadcastID int, userID int64, status, errText string) error {
+	_, err := DB.Exec(context.Background(), `
+		UPDATE broadcast_deliveries
+		SET status = $1, error = NULLIF($2, ''), sent = now() AT TIME ZONE 'UTC'
+		WHERE broadcast_id = $3 AND user_id = $4`, status, errText, broadcastID, userID)
+	if err != nil {
+		return fmt.Errorf("setDeliveryStatus: %w", err)
+	}
+	return nil
+}
+
+// deliveryProgress returns number of recipients who are still waiting for broadcast and total number of them
+func deliveryProgress(broadcastID int) (pending, total int, err error) {
+	err = DB.QueryRow(context.Background(), `
+		SELECT COUNT(*) FILTER (WHERE status = $1), COUNT(*)
+		FROM broadcast_deliveries
+		WHERE broadcast_id = $2`, DeliveryPending, broadcastID).Scan(&pending, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("deliveryProgress: %w", err)
+	}
+	return pending, total, nil
+}
+
+// reportProgress sends progress of broadcast to admin, the same message is edited afterwards
+// and becomes delivery report when broadcast is finished.
+// Its ID is saved in broadcasts, so progress continues in the same message after restart
+func (bs *BroadcastScheduler) reportProgress(broadcastID int, adminID int64, finished bool) {
+	pending, total, err := deliveryProgress(broadcastID)
+	if err != nil {
+		logger.Error("reportProgress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+		return
+	}
+	broadcastPending.Set(float64(pending))
+	text := fmt.Sprintf("📨 Рассылка #%d отправляется: %d из %d", broadcastID, total-pending, total)
+	if finished {
+		loc, err := userLocation(adminID)
+		if err != nil {
+			logger.Error("reportProgress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+			loc = time.UTC
+		}
+		if text, err = broadcastReport(broadcastID, loc); err != nil {
+			logger.Error("reportProgress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+			return
+		}
+	}
+
+	var messageID *int
+	err = DB.QueryRow(context.Background(), `
+		SELECT progress_message_id FROM broadcasts
+		WHERE broadcast_id = $1`, broadcastID).Scan(&messageID)
+	if err != nil {
+		logger.Error("reportProgress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+		return
+	}
+	if messageID != nil {
+		msg := tele.StoredMessage{MessageID: strconv.Itoa(*messageID), ChatID: adminID}
+		_, err = bs.bot.Edit(msg, text)
+		if err != nil && !errors.Is(err, tele.ErrMessageNotModified) && !errors.Is(err, tele.ErrSameMessageContent) {
+			logger.Error("can't update broadcast progress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+		}
+		return
+	}
+
+	msg, err := bs.bot.Send(UserIDType{adminID}, text)
+	if err != nil {
+		logger.Error("can't send broadcast progress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+		return
+	}
+	_, err = DB.Exec(context.Background(), `
+		UPDATE broadcasts
+		SET progress_message_id = $1
+		WHERE broadcast_id = $2`, msg.ID, broadcastID)
+	if err != nil {
+		logger.Error("reportProgress", zap.Int("broadcastID", broadcastID), zap.Error(err))
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"vocal_training_bot/BotExt"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	om "github.com/wk8/go-ordered-map/v2"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// pastBroadcastsLimit - number of the latest broadcasts in pastBroadcastsAdminMenu
+const pastBroadcastsLimit = 20
+
+// broadcast metrics are registered once on start, any number of schedulers can share them
+var (
+	broadcastDeliveries = promauto.NewCounterVec(prom.CounterOpts{
+		Name: "broadcast_deliveries_total",
+		Help: "recipients of broadcasts by delivery status and failure reason",
+	}, []string{"status", "reason"})
+	broadcastPending = promauto.NewGauge(prom.GaugeOpts{
+		Name: "broadcast_pending_recipients",
+		Help: "recipients of broadcasts being sent that haven't got messages yet",
+	})
+)
+
+// Reasons of failed delivery. The set is fixed as they are metric labels, full errors are logged
+const (
+	ReasonBlocked    = "blocked"      // user blocked the bot, deleted account or never started it
+	ReasonFlood      = "flood"        // too many requests
+	ReasonBadRequest = "bad_request"  // telegram refused the message
+	ReasonForbidden  = "forbidden"    // bot can't write to the chat
+	ReasonServer     = "server_error" // telegram failed
+	ReasonNetwork    = "network"      // telegram wasn't reached
+	ReasonOther      = "other"
+)
+
+// deliveryFailureReasonText - descriptions of delivery failure reasons for admin reports
+var deliveryFailureReasonText = map[string]string{
+	ReasonBlocked:    "бот заблокирован",
+	ReasonFlood:      "превышен лимит запросов",
+	ReasonBadRequest: "сообщение отклонено телеграмом",
+	ReasonForbidden:  "нет доступа к чату",
+	ReasonServer:     "ошибка телеграма",
+	ReasonNetwork:    "ошибка сети",
+	ReasonOther:      "другая ошибка",
+}
+
+// telegramErrorCode extracts code of errors unknown to telebot, they are formatted as "telegram: <description> (<code>)"
+var telegramErrorCode = regexp.MustCompile(`telegram: .* \((\d+)\)$`)
+
+// deliveryFailureReason returns one of Reason* constants for error of failed delivery
+func deliveryFailureReason(err error) string {
+	if isBotBlocked(err) {
+		return ReasonBlocked
+	}
+	var floodErr tele.FloodError
+	if errors.As(err, &floodErr) {
+		return ReasonFlood
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return ReasonNetwork
+	}
+
+	var code int
+	var teleErr *tele.Error
+	if errors.As(err, &teleErr) {
+		code = teleErr.Code
+	} else if m := telegramErrorCode.FindStringSubmatch(err.Error()); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+	switch {
+	case code == http.StatusTooManyRequests:
+		return ReasonFlood
+	case code == http.StatusBadRequest:
+		return ReasonBadRequest
+	case code == http.StatusForbidden:
+		return ReasonForbidden
+	case code >= http.StatusInternalServerError:
+		return ReasonServer
+	}
+	return ReasonOther
+}
+
+// broadcastReport describes delivery results and timing of broadcast
+func broadcastReport(broadcastID int, loc *time.Location) (string, error) {
+	var (
+		status                  string
+		created                 time.Time
+		started, finished       *time.Time
+		delivered, failed       int
+		blocked, pending, total int
+	)
+	err := DB.QueryRow(context.Background(), `
+		SELECT broadcasts.status, created, started, finished,
+			COUNT(*) FILTER (WHERE broadcast_deliveries.status = $2),
+			COUNT(*) FILTER (WHERE broadcast_deliveries.status = $3),
+			COUNT(*) FILTER (WHERE broadcast_deliveries.status = $4),
+			COUNT(*) FILTER (WHERE broadcast_deliveries.status = $5),
+			COUNT(broadcast_deliveries.user_id)
+		FROM broadcasts
+		LEFT JOIN broadcast_deliveries USING (broadcast_id)
+		WHERE broadcasts.broadcast_id = $1
+		GROUP BY broadcasts.broadcast_id`,
+		broadcastID, DeliverySent, DeliveryFailed, DeliveryBlocked, DeliveryPending).Scan(
+		&status, &created, &started, &finished, &delivered, &failed, &blocked, &pending, &total)
+	if err != nil {
+		return "", fmt.Errorf("broadcastReport: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📊 Рассылка #%d, %s\n", broadcastID, strings.ToLower(status)))
+	sb.WriteString(fmt.Sprintf("Создана: %s\n", created.In(loc).Format(broadcastTimeLayout)))
+	if started != nil {
+		sb.WriteString(fmt.Sprintf("Начата: %s\n", started.In(loc).Format(broadcastTimeLayout)))
+	}
+	if started != nil && finished != nil {
+		sb.WriteString(fmt.Sprintf("Завершена: %s, заняла %s\n", finished.In(loc).Format(broadcastTimeLayout),
+			finished.Sub(*started).Round(time.Second)))
+	}
+	if total == 0 {
+		sb.WriteString("Получателей нет")
+		return sb.String(), nil
+	}
+	sb.WriteString(fmt.Sprintf("\nПолучателей: %d\n✅ Доставлено: %d\n🚫 Заблокировали бота: %d\n❌ Ошибки: %d\n⏳ В очереди: %d",
+		total, delivered, blocked, failed, pending))
+	if failed == 0 {
+		return sb.String(), nil
+	}
+
+	rows, err := DB.Query(context.Background(), `
+		SELECT COALESCE(error, ''), COUNT(*) FROM broadcast_deliveries
+		WHERE broadcast_id = $1 AND status = $2
+		GROUP BY error
+		ORDER BY COUNT(*) DESC`, broadcastID, DeliveryFailed)
+	if err != nil {
+		return "", fmt.Errorf("broadcastReport: %w", err)
+	}
+	defer rows.Close()
+
+	var reason string
+	var count int
+	for rows.Next() {
+		if err = rows.Scan(&reason, &count); err != nil {
+			return "", fmt.Errorf("broadcastReport: scan row: %w", err)
+		}
+		// deliveries failed before reasons were fixed keep raw error text
+		if text, ok := deliveryFailureReasonText[reason]; ok {
+			reason = text
+		}
+		sb.WriteString(fmt.Sprintf("\n  • %s: %d", reason, count))
+	}
+	if err = rows.Err(); err != nil {
+		return "", fmt.Errorf("broadcastReport: postgres itetator %w", err)
+	}
+	return sb.String(), nil
+}
+
+// sendBroadcastReport sends report of broadcast to admin
+func sendBroadcastReport(c tele.Context, broadcastID int) error {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("sendBroadcastReport: %w", err)
+	}
+	report, err := broadcastReport(broadcastID, loc)
+	if err != nil {
+		return fmt.Errorf("sendBroadcastReport: %w", err)
+	}
+	return c.Send(report)
+}
+
+func pastBroadcastsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("pastBroadcastsFetcher: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT broadcast_id::text, status, COALESCE(started, finished, created) FROM broadcasts
+		WHERE status IN ($1, $2, $3)
+		ORDER BY broadcast_id DESC
+		LIMIT $4`, BroadcastSending, BroadcastSent, BroadcastCanceled, pastBroadcastsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("pastBroadcastsFetcher: can't fetch database: %w", err)
+	}
+	defer rows.Close()
+	omap := om.New[string, string]()
+
+	var broadcastID, status string
+	var dt time.Time
+	for rows.Next() {
+		if err = rows.Scan(&broadcastID, &status, &dt); err != nil {
+			return omap, fmt.Errorf("pastBroadcastsFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(broadcastID, fmt.Sprintf("#%s %s %s", broadcastID, broadcastStatusIcons[status],
+			dt.In(loc).Format(broadcastTimeLayout)))
+	}
+	if omap.Len() == 0 {
+		err = c.Send("Рассылок еще не было")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return omap, BotExt.NoButtons
+	}
+	return omap, nil
+}
+
+var broadcastStatusIcons = map[string]string{
+	BroadcastSending:  "📨",
+	BroadcastSent:     "✅",
+	BroadcastCanceled: "🗑",
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestDeliveryFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"blocked by user", tele.ErrBlockedByUser, ReasonBlocked},
+		{"deactivated user", fmt.Errorf("sendBakedMessage: %w", tele.ErrUserIsDeactivated), ReasonBlocked},
+		{"chat not found", tele.ErrChatNotFound, ReasonBlocked},
+		{"flood", tele.FloodError{RetryAfter: 5}, ReasonFlood},
+		{"known bad request", tele.ErrEmptyMessage, ReasonBadRequest},
+		// errors unknown to telebot are plain fmt.Errorf("telegram: %s (%d)")
+		{"unknown bad request", errors.New("telegram: Bad Request: wrong file identifier (400)"), ReasonBadRequest},
+		{"unknown forbidden", errors.New("telegram: Forbidden: bot was kicked from the group chat (403)"), ReasonForbidden},
+		{"unknown too many requests", errors.New("telegram: Too Many Requests (429)"), ReasonFlood},
+		{"server error", errors.New("telegram: Internal Server Error (500)"), ReasonServer},
+		{"bad gateway", errors.New("telegram: Bad Gateway (502)"), ReasonServer},
+		{"wrapped unknown", fmt.Errorf("deliverTo: %w", errors.New("telegram: Bad Request: message is too long (400)")), ReasonBadRequest},
+		{"network", fmt.Errorf("telebot: %w", &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: errors.New("timeout")}), ReasonNetwork},
+		{"internal", errors.New("can't read file"), ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deliveryFailureReason(tt.err); got != tt.want {
+				t.Fatalf("deliveryFailureReason(%v) = %s; want %s", tt.err, got, tt.want)
+			}
+			if _, ok := deliveryFailureReasonText[tt.want]; !ok {
+				t.Fatalf("reason %s has no description", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"vocal_training_bot/BotExt"
+
+	"github.com/jackc/pgx/v5"
+	om "github.com/wk8/go-ordered-map/v2"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// statuses of broadcasts
+const (
+	BroadcastDraft     = "DRAFT"
+	BroadcastScheduled = "SCHEDULED"
+	BroadcastSending   = "SENDING"
+	BroadcastSent      = "SENT"
+	BroadcastCanceled  = "CANCELED"
+
+	broadcastTimeLayout = "02.01.2006 15:04"
+)
+
+// BroadcastScheduler sends broadcasts when their time comes. Jobs are rows of broadcasts table,
+// so scheduled broadcasts survive restarts
+type BroadcastScheduler struct {
+	bot       *tele.Bot
+	frequency time.Duration
+	limiter   *tokenBucket
+	wake      chan struct{}
+	quit      chan struct{}
+}
+
+func NewBroadcastScheduler(b *tele.Bot, frequency time.Duration) *BroadcastScheduler {
+	return &BroadcastScheduler{
+		bot:       b,
+		frequency: frequency,
+		limiter:   newTokenBucket(broadcastRate, broadcastRate),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (bs *BroadcastScheduler) Start() {
+	ticker := time.NewTicker(bs.frequency)
+	bs.quit = make(chan struct{})
+	// broadcasts interrupted by restart are resumed right away
+	bs.Wake()
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+			case <-bs.wake:
+			case <-bs.quit:
+				ticker.Stop()
+				return
+			}
+			if err := bs.processBroadcasts(); err != nil {
+				logger.Error("processBroadcasts", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (bs *BroadcastScheduler) Stop() {
+	close(bs.quit)
+}
+
+// Wake makes scheduler check broadcasts right now, e.g. when broadcast is sent immediately
+func (bs *BroadcastScheduler) Wake() {
+	select {
+	case bs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// processBroadcasts starts every broadcast whose time has come and delivers broadcasts being sent
+func (bs *BroadcastScheduler) processBroadcasts() error {
+	for {
+		ok, err := claimBroadcast()
+		if err != nil {
+			return fmt.Errorf("BroadcastScheduler.processBroadcasts: %w", err)
+		}
+		if !ok {
+			break
+		}
+	}
+
+	rows, err := DB.Query(context.Background(), `
+		SELECT broadcast_id, record_id::text, created_by FROM broadcasts
+		WHERE status = $1
+		ORDER BY started`, BroadcastSending)
+	if err != nil {
+		return fmt.Errorf("BroadcastScheduler.processBroadcasts: %w", err)
+	}
+	type sending struct {
+		broadcastID int
+		recordID    string
+		createdBy   int64
+	}
+	var broadcasts []sending
+	for rows.Next() {
+		var b sending
+		if err = rows.Scan(&b.broadcastID, &b.recordID, &b.createdBy); err != nil {
+			rows.Close()
+			return fmt.Errorf("BroadcastScheduler.processBroadcasts: scan row: %w", err)
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("BroadcastScheduler.processBroadcasts: postgres itetator %w", err)
+	}
+
+	for _, b := range broadcasts {
+		err = bs.deliverBroadcast(b.broadcastID, b.recordID, b.createdBy)
+		if errors.Is(err, errBroadcastStopped) {
+			logger.Info("broadcast delivery stopped", zap.Int("broadcastID", b.broadcastID))
+			return nil
+		}
+		if err != nil {
+			logger.Error("can't deliver broadcast", zap.Int("broadcastID", b.broadcastID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// finishBroadcast marks broadcast as sent. Messages of broadcast are deleted because they are onetime
+func finishBroadcast(broadcastID int, recordID string) error {
+	_, err := DB.Exec(context.Background(), `
+		UPDATE broadcasts
+		SET status = $1, finished = now() AT TIME ZONE 'UTC'
+		WHERE broadcast_id = $2`, BroadcastSent, broadcastID)
+	if err != nil {
+		return fmt.Errorf("finishBroadcast: %w", err)
+	}
+	// TODO: clear lost messages from time to time (that are not cheerup or warmup)
+	_, err = DB.Exec(context.Background(), `
+		DELETE FROM messages
+		WHERE record_id = $1`, recordID)
+	if err != nil {
+		return fmt.Errorf("finishBroadcast: cannot delete record, %w", err)
+	}
+	return nil
+}
+
+// createBroadcast saves recorded messages as draft, admin chooses delivery time later
+func createBroadcast(adminID int64, recordID string) (broadcastID int, err error) {
+	err = DB.QueryRow(context.Background(), `
+		INSERT INTO broadcasts(record_id, created_by)
+		VALUES ($1 :: uuid, $2)
+		RETURNING broadcast_id`, recordID, adminID).Scan(&broadcastID)
+	if err != nil {
+		return 0, fmt.Errorf("createBroadcast: %w", err)
+	}
+	return broadcastID, nil
+}
+
+// scheduleBroadcast sets delivery time of draft or scheduled broadcast, ok is false if broadcast can't be changed
+func scheduleBroadcast(broadcastID string, sendAt time.Time) (ok bool, err error) {
+	tag, err := DB.Exec(context.Background(), `
+		UPDATE broadcasts
+		SET status = $1, send_at = $2
+		WHERE broadcast_id = $3 AND status IN ($4, $1)`,
+		BroadcastScheduled, sendAt.UTC(), broadcastID, BroadcastDraft)
+	if err != nil {
+		return false, fmt.Errorf("scheduleBroadcast: %w", err)
+	}
+	return tag.RowsAffected() != 0, nil
+}
+
+// cancelBroadcast cancels draft or scheduled broadcast, ok is false if it is being sent or finished already
+func cancelBroadcast(broadcastID string) (ok bool, err error) {
+	var recordID string
+	err = DB.QueryRow(context.Background(), `
+		UPDATE broadcasts
+		SET status = $1, finished = now() AT TIME ZONE 'UTC'
+		WHERE broadcast_id = $2 AND status IN ($3, $4)
+		RETURNING record_id::text`,
+		BroadcastCanceled, broadcastID, BroadcastDraft, BroadcastScheduled).Scan(&recordID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cancelBroadcast: %w", err)
+	}
+	_, err = DB.Exec(context.Background(), `
+		DELETE FROM messages
+		WHERE record_id = $1`, recordID)
+	if err != nil {
+		return true, fmt.Errorf("cancelBroadcast: cannot delete record, %w", err)
+	}
+	return true, nil
+}
+
+// previewBroadcast sends broadcast to admin exactly as users will get it
+func previewBroadcast(c tele.Context, broadcastID string) error {
+	var recordID, status string
+	var sendAt *time.Time
+	err := DB.QueryRow(context.Background(), `
+		SELECT record_id::text, status, send_at FROM broadcasts
+		WHERE broadcast_id = $1`, broadcastID).Scan(&recordID, &status, &sendAt)
+	if err != nil {
+		return fmt.Errorf("previewBroadcast: %w", err)
+	}
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("previewBroadcast: %w", err)
+	}
+	if err = c.Send(fmt.Sprintf("Рассылка #%s, %s. Так ее увидят пользователи:", broadcastID,
+		broadcastStatusText(status, sendAt, loc))); err != nil {
+		return err
+	}
+	return SendMessageToUser(c.Bot(), c.Sender().ID, recordID, false)
+}
+
+func broadcastStatusText(status string, sendAt *time.Time, loc *time.Location) string {
+	if status == BroadcastScheduled && sendAt != nil {
+		return "🕐 " + sendAt.In(loc).Format(broadcastTimeLayout)
+	}
+	if status == BroadcastDraft {
+		return "📝 черновик"
+	}
+	return strings.ToLower(status)
+}
+
+func broadcastTimeValidator(c tele.Context) string {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return ""
+	}
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		logger.Error("broadcastTimeValidator", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+		return "Не могу определить твой часовой пояс"
+	}
+	sendAt, err := time.ParseInLocation(broadcastTimeLayout, strings.TrimSpace(c.Text()), loc)
+	if err != nil {
+		return "Не могу распознать время. Формат: ДД.ММ.ГГГГ ЧЧ:ММ, например 25.12.2026 18:00"
+	}
+	if !sendAt.After(time.Now()) {
+		return "Это время уже прошло, введи время в будущем"
+	}
+	return ""
+}
+
+// ScheduleBroadcast sets delivery time entered by admin for broadcast from state var selectedBroadcast
+func ScheduleBroadcast(c tele.Context) error {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return nil
+	}
+	broadcastID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedBroadcast")
+	if !ok {
+		return fmt.Errorf("ScheduleBroadcast: can't find state var selectedBroadcast")
+	}
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("ScheduleBroadcast: %w", err)
+	}
+	sendAt, err := time.ParseInLocation(broadcastTimeLayout, strings.TrimSpace(c.Text()), loc)
+	if err != nil {
+		return fmt.Errorf("ScheduleBroadcast: %w", err)
+	}
+	ok, err = scheduleBroadcast(broadcastID, sendAt)
+	if err != nil {
+		return fmt.Errorf("ScheduleBroadcast: %w", err)
+	}
+	if !ok {
+		return c.Send("Рассылка уже отправляется или отменена")
+	}
+	return c.Send(fmt.Sprintf("🕐 Рассылка #%s запланирована на %s", broadcastID, sendAt.Format(broadcastTimeLayout)))
+}
+
+// sendBroadcastNow schedules broadcast from state var selectedBroadcast to the current time
+func sendBroadcastNow(c tele.Context) error {
+	broadcastID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedBroadcast")
+	if !ok {
+		return fmt.Errorf("sendBroadcastNow: can't find state var selectedBroadcast")
+	}
+	ok, err := scheduleBroadcast(broadcastID, time.Now())
+	if err != nil {
+		return fmt.Errorf("sendBroadcastNow: %w", err)
+	}
+	if !ok {
+		return c.Send("Рассылка уже отправляется или отменена")
+	}
+	broadcastScheduler.Wake()
+	return c.Send(fmt.Sprintf("🚀 Рассылка #%s отправляется...", broadcastID))
+}
+
+// cancelSelectedBroadcast cancels broadcast from state var selectedBroadcast
+func cancelSelectedBroadcast(c tele.Context) error {
+	broadcastID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedBroadcast")
+	if !ok {
+		return fmt.Errorf("cancelSelectedBroadcast: can't find state var selectedBroadcast")
+	}
+	ok, err := cancelBroadcast(broadcastID)
+	if err != nil {
+		return fmt.Errorf("cancelSelectedBroadcast: %w", err)
+	}
+	if !ok {
+		return c.Send("Рассылка уже отправляется или отменена")
+	}
+	return c.Send(fmt.Sprintf("🗑 Рассылка #%s отменена", broadcastID))
+}
+
+func pendingBroadcastsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("pendingBroadcastsFetcher: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT broadcast_id::text, status, send_at FROM broadcasts
+		WHERE status IN ($1, $2)
+		ORDER BY send_at NULLS LAST, broadcast_id`, BroadcastScheduled, BroadcastDraft)
+	if err != nil {
+		return nil, fmt.Errorf("pendingBroadcastsFetcher: can't fetch database: %w", err)
+	}
+	defer rows.Close()
+	omap := om.New[string, string]()
+
+	var broadcastID, status string
+	var sendAt *time.Time
+	for rows.Next() {
+		if err = rows.Scan(&broadcastID, &status, &sendAt); err != nil {
+			return omap, fmt.Errorf("pendingBroadcastsFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(broadcastID, fmt.Sprintf("#%s %s", broadcastID, broadcastStatusText(status, sendAt, loc)))
+	}
+	if omap.Len() == 0 {
+		err = c.Send("Запланированных рассылок нет")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return omap, BotExt.NoButtons
+	}
+	return omap, nil
+}
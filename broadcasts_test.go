@@ -0,0 +1,12 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBroadcastSchedulerTwice(t *testing.T) {
+	// metrics are registered on package init, constructor must not register them again
+	NewBroadcastScheduler(nil, time.Minute)
+	NewBroadcastScheduler(nil, time.Minute)
+}
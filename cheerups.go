@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"vocal_training_bot/BotExt"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// categories of cheerups, cheerup without categories fits any moment
+const (
+	CheerupStreak   = "streak"
+	CheerupComeback = "comeback"
+	CheerupMorning  = "morning"
+	CheerupEvening  = "evening"
+)
+
+// cheerupCategories - order of categories in cheerupCategoriesAdminMenu
+var cheerupCategories = []string{CheerupStreak, CheerupComeback, CheerupMorning, CheerupEvening}
+
+var cheerupCategoryTexts = map[string]string{
+	CheerupStreak:   "🔥 Серия занятий",
+	CheerupComeback: "🤗 Возвращение после перерыва",
+	CheerupMorning:  "🌅 Утро",
+	CheerupEvening:  "🌙 Вечер",
+}
+
+// reminderCheerupCategories returns categories that fit the moment of warmup reminder:
+// comeback if user's streak is broken, otherwise morning or evening by user's local time
+func reminderCheerupCategories(userID int64, now time.Time) ([]string, error) {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return nil, fmt.Errorf("reminderCheerupCategories: %w", err)
+	}
+	local := now.In(loc)
+	days, err := practiceDays(userID)
+	if err != nil {
+		return nil, fmt.Errorf("reminderCheerupCategories: %w", err)
+	}
+	if current, _ := practiceStreaks(days, local); len(days) != 0 && current == 0 {
+		return []string{CheerupComeback}, nil
+	}
+	switch hour := local.Hour(); {
+	case hour >= 5 && hour < 12:
+		return []string{CheerupMorning}, nil
+	case hour >= 18:
+		return []string{CheerupEvening}, nil
+	}
+	return nil, nil
+}
+
+// getRandomCheerup returns record of the next cheerup in user's rotation and saves delivery to history.
+// User gets every cheerup once per cycle in shuffled order, the new cycle starts when rotation is empty.
+// Cheerups of one of categories are preferred, any cheerup of the cycle is sent if none of them is left.
+// recordID is "" if there are no cheerups at all
+func getRandomCheerup(userID int64, categories ...string) (recordID string, err error) {
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("getRandomCheerup: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var cheerupID int
+	if categories == nil {
+		categories = []string{}
+	}
+	selectNext := func() error {
+		return tx.QueryRow(context.Background(), `
+			SELECT cheerup_id, record_id::text FROM cheerup_rotation
+			INNER JOIN warmup_cheerups USING (cheerup_id)
+			WHERE user_id = $1
+			ORDER BY categories && $2 DESC, position
+			LIMIT 1
+			FOR UPDATE OF cheerup_rotation`, userID, categories).Scan(&cheerupID, &recordID)
+	}
+	err = selectNext()
+	if errors.Is(err, pgx.ErrNoRows) {
+		_, err = tx.Exec(context.Background(), `
+			INSERT INTO cheerup_rotation(user_id, cheerup_id, position)
+			SELECT $1, cheerup_id, random() FROM warmup_cheerups
+			ON CONFLICT DO NOTHING`, userID)
+		if err != nil {
+			return "", fmt.Errorf("getRandomCheerup: can't start new cycle: %w", err)
+		}
+		err = selectNext()
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getRandomCheerup: %w", err)
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		DELETE FROM cheerup_rotation
+		WHERE user_id = $1 AND cheerup_id = $2`, userID, cheerupID)
+	if err != nil {
+		return "", fmt.Errorf("getRandomCheerup: %w", err)
+	}
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO cheerup_deliveries(user_id, cheerup_id)
+		VALUES ($1, $2)`, userID, cheerupID)
+	if err != nil {
+		return "", fmt.Errorf("getRandomCheerup: %w", err)
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return "", fmt.Errorf("getRandomCheerup: %w", err)
+	}
+	return recordID, nil
+}
+
+// addCheerupToRotations puts new cheerup to a random place of current cycle of every user.
+// Users without current cycle get it when the next cycle starts
+func addCheerupToRotations(cheerupID int) error {
+	_, err := DB.Exec(context.Background(), `
+		INSERT INTO cheerup_rotation(user_id, cheerup_id, position)
+		SELECT DISTINCT user_id, $1, random() FROM cheerup_rotation
+		ON CONFLICT DO NOTHING`, cheerupID)
+	if err != nil {
+		return fmt.Errorf("addCheerupToRotations: %w", err)
+	}
+	return nil
+}
+
+func cheerupCategoriesFetcher(c tele.Context) (map[string]string, error) {
+	cheerupID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedCheerup")
+	if !ok {
+		return nil, fmt.Errorf("cheerupCategoriesFetcher: can't fetch selectedCheerup")
+	}
+	var categories []string
+	err := DB.QueryRow(context.Background(), `
+		SELECT categories FROM warmup_cheerups
+		WHERE cheerup_id = $1`, cheerupID).Scan(&categories)
+	if err != nil {
+		return nil, fmt.Errorf("cheerupCategoriesFetcher: %w", err)
+	}
+	out := make(map[string]string)
+	for _, category := range categories {
+		out[category] = "true"
+	}
+	return out, nil
+}
+
+func cheerupCategoryButton(category string) *BotExt.InlineButtonTemplate {
+	return &BotExt.InlineButtonTemplate{
+		Unique: "CheerupCategory_" + category,
+		TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+			if dc[category] == "true" {
+				return "✅ " + cheerupCategoryTexts[category], nil
+			}
+			return cheerupCategoryTexts[category], nil
+		},
+		OnClick: func(c tele.Context) error {
+			err := switchCheerupCategory(c, category)
+			if err != nil {
+				logger.Error("can't switch cheerup category", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+			}
+			adminInlineMenus.Update(c, cheerupCategoriesAdminMenu)
+			return c.Respond()
+		},
+	}
+}
+
+func switchCheerupCategory(c tele.Context, category string) error {
+	cheerupID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedCheerup")
+	if !ok {
+		return fmt.Errorf("switchCheerupCategory: can't find state var selectedCheerup")
+	}
+	_, err := DB.Exec(context.Background(), `
+		UPDATE warmup_cheerups
+		SET categories = CASE
+			WHEN $2 = ANY(categories) THEN array_remove(categories, $2)
+			ELSE array_append(categories, $2)
+		END
+		WHERE cheerup_id = $1`, cheerupID, category)
+	if err != nil {
+		return fmt.Errorf("switchCheerupCategory: %w", err)
+	}
+	return nil
+}
@@ -19,6 +19,10 @@ type Config struct {
 		Token         string `yaml:"Token" envconfig:"BOT_TOKEN" validate:"nonzero"`
 		ProviderToken string `yaml:"ProviderToken" envconfig:"PROVIDER_TOKEN" validate:"nonzero"`
 		SupervisorID  int64  `yaml:"SupervisorID" envconfig:"SUPERVISOR_USER_ID" validate:"nonzero"`
+		// APIURL overrides Bot API server address, e.g. for local fake server. Empty - default telegram server
+		APIURL string `yaml:"APIURL" envconfig:"BOT_API_URL"`
+		// StateStore is storage of FSM states: postgres (default), redis or memory
+		StateStore string `yaml:"StateStore" envconfig:"STATE_STORE"`
 	} `yaml:"Bot"`
 
 	Pg struct {
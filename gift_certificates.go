@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"vocal_training_bot/BotExt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	CertificateWarmups = "WARMUPS"
+	CertificateLessons = "LESSONS"
+
+	// giftCertificateValidityMonths - certificate can be redeemed within this period after purchase
+	giftCertificateValidityMonths = 2
+
+	// unambiguous symbols only: no 0/O, 1/I
+	giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// newGiftCode generates random code in XXXX-XXXX-XXXX format
+func newGiftCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(giftCodeAlphabet)))
+	for i := 0; i < 12; i++ {
+		if (i != 0) && (i%4 == 0) {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("newGiftCode: %w", err)
+		}
+		sb.WriteByte(giftCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// giftCertificateType is a product that can be bought as a gift
+//   - Kind - CertificateWarmups grants GroupID warmup group, CertificateLessons grants Lessons lesson credits
+type giftCertificateType struct {
+	Title     string
+	Kind      string
+	GroupID   *int
+	GroupName *string
+	Lessons   *int
+	Price     int
+	Active    bool
+}
+
+func getGiftCertificateType(typeID string) (*giftCertificateType, error) {
+	t := &giftCertificateType{}
+	err := DB.QueryRow(context.Background(), `
+		SELECT title, kind, group_id, group_name, lessons, gift_certificate_types.price, active
+		FROM gift_certificate_types
+		LEFT JOIN warmup_groups ON warmup_groups.warmup_group_id = gift_certificate_types.group_id
+		WHERE type_id = $1`, typeID).Scan(&t.Title, &t.Kind, &t.GroupID, &t.GroupName, &t.Lessons, &t.Price, &t.Active)
+	if err != nil {
+		return nil, fmt.Errorf("getGiftCertificateType: %w", err)
+	}
+	return t, nil
+}
+
+// Description returns human-readable content of certificate
+func (t *giftCertificateType) Description() string {
+	switch {
+	case (t.Kind == CertificateWarmups) && (t.GroupName != nil):
+		return "пакет распевок '" + *t.GroupName + "'"
+	case (t.Kind == CertificateLessons) && (t.Lessons != nil):
+		return fmt.Sprintf("занятий вокалом: %d", *t.Lessons)
+	}
+	return t.Title
+}
+
+func processGiftCertificateType(c tele.Context, typeID string) error {
+	t, err := getGiftCertificateType(typeID)
+	if err != nil {
+		return fmt.Errorf("processGiftCertificateType: %w", err)
+	}
+	if !t.Active {
+		return c.Send("Этот сертификат больше недоступен")
+	}
+
+	invoice := &tele.Invoice{
+		Title: "Подарочный сертификат",
+		Description: fmt.Sprintf("Сертификат '%s': %s. Действует %d мес. с момента покупки",
+			t.Title, t.Description(), giftCertificateValidityMonths),
+		Payload:  GiftCertificatePayloadChecker + PayloadSplit + typeID,
+		Currency: PaymentCurrency,
+		Prices: []tele.Price{
+			{
+				Label:  PaymentCurrency,
+				Amount: t.Price * 100,
+			},
+		},
+		Token: ProviderToken,
+	}
+	return c.Send(invoice)
+}
+
+// giftCertificateCheckoutValidator returns "" if checkout can be accepted, otherwise - it is an error message for user
+func giftCertificateCheckoutValidator(userID int64, typeID string, checkout *tele.PreCheckoutQuery) string {
+	t, err := getGiftCertificateType(typeID)
+	if err != nil {
+		logger.Error("can't find gift certificate type in db", zap.Int64("userID", userID), zap.String("typeID", typeID),
+			zap.String("checkoutID", checkout.ID), zap.Error(err))
+		return PaymentErrorText
+	}
+	if !t.Active {
+		return "Этот сертификат больше недоступен"
+	}
+	if (t.Price*100 != checkout.Total) || (checkout.Currency != PaymentCurrency) {
+		logger.Error("price doesn't match", zap.Int64("userID", userID), zap.String("typeID", typeID),
+			zap.String("checkoutID", checkout.ID), zap.String("payload", checkout.Payload),
+			zap.Int("dbPrice", t.Price*100), zap.Int("checkout.Total", checkout.Total),
+			zap.String("checkout.Currency", checkout.Currency),
+		)
+		return "Цена изменилась, попробуй купить сертификат еще раз!"
+	}
+	return ""
+}
+
+// acquireGiftCertificate creates certificate with unique code for paid checkout. stored is false if nothing was saved
+func acquireGiftCertificate(c tele.Context, payment *tele.Payment, typeID string) (stored bool, err error) {
+	userID := c.Sender().ID
+	chargeID := payment.TelegramChargeID
+	price := strconv.Itoa(payment.Total) + payment.Currency
+	expire := time.Now().UTC().AddDate(0, giftCertificateValidityMonths, 0)
+
+	var code string
+	for attempt := 0; attempt < 5; attempt++ {
+		var exists bool
+		err := DB.QueryRow(context.Background(), `
+			SELECT EXISTS(SELECT 1 FROM gift_certificates WHERE checkout_id = $1)`, chargeID).Scan(&exists)
+		if err != nil {
+			logger.Error("exec db error", zap.Int64("userID", userID), zap.String("chargeID", chargeID), zap.Error(err))
+			return false, c.Send(PaymentLostText)
+		}
+		if exists {
+			logger.Warn("duplicate payment", zap.Int64("userID", userID), zap.String("chargeID", chargeID))
+			return false, nil
+		}
+
+		code, err = newGiftCode()
+		if err != nil {
+			return false, fmt.Errorf("acquireGiftCertificate: %w", err)
+		}
+		// code collision is handled by the next attempt
+		err = DB.QueryRow(context.Background(), `
+			INSERT INTO gift_certificates(certificate_code, type_id, buyer_id, checkout_id, price_when_acquired, expire_dt)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT DO NOTHING
+			RETURNING certificate_code`, code, typeID, userID, chargeID, price, expire).Scan(&code)
+		if err == nil {
+			break
+		}
+		code = ""
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Error("exec db error", zap.Int64("userID", userID), zap.String("typeID", typeID),
+				zap.String("chargeID", chargeID), zap.String("providerChargeID", payment.ProviderChargeID), zap.Error(err))
+			return false, c.Send(PaymentLostText)
+		}
+	}
+	if code == "" {
+		logger.Error("can't generate unique gift code", zap.Int64("userID", userID), zap.String("chargeID", chargeID))
+		return false, c.Send(PaymentLostText)
+	}
+
+	t, err := getGiftCertificateType(typeID)
+	if err != nil {
+		return true, fmt.Errorf("acquireGiftCertificate: %w", err)
+	}
+
+	logger.Info("successful payment", zap.Int64("userID", userID), zap.String("typeID", typeID),
+		zap.String("price", price), zap.String("chargeID", chargeID),
+		zap.String("providerChargeID", payment.ProviderChargeID))
+	notifyAdmins(c.Bot(), fmt.Sprintf("🎁 Куплен сертификат '%s' (%s), код %s. Покупатель: %s [ID%d]",
+		t.Title, t.Description(), code, userMention(c.Sender()), userID))
+
+	err = c.Send(paymentReceipt("Подарочный сертификат: "+t.Title, payment, time.Now()))
+	if err != nil {
+		return true, err
+	}
+	return true, c.Send(fmt.Sprintf(`🎁 Сертификат '%s' (%s)
+
+Код: %s
+Действует до: %s
+
+Перешли этот код получателю. Чтобы активировать сертификат, надо открыть меню 'Подарочный сертификат' в боте и нажать 'Активировать сертификат'`,
+		t.Title, t.Description(), code, expire.Format("02.01.2006")))
+}
+
+// giftWarmupsOwnedText is shown if user tries to redeem warmup group he already has
+const giftWarmupsOwnedText = "У тебя уже есть пакет распевок из этого сертификата. Сертификат не активирован - " +
+	"его можно подарить кому-нибудь еще. Введи другой код или напиши 'ОТМЕНА'"
+
+// giftCodeValidator returns "" if certificate can be redeemed, otherwise - it is an error message for user
+func giftCodeValidator(c tele.Context) string {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return ""
+	}
+	var redeemed, owned bool
+	var expire time.Time
+	err := DB.QueryRow(context.Background(), `
+		SELECT redeemed_by IS NOT NULL, expire_dt,
+			EXISTS(
+				SELECT 1 FROM acquired_warmup_groups
+				WHERE user_id = $2 AND group_id = gift_certificate_types.group_id)
+		FROM gift_certificates
+		JOIN gift_certificate_types USING (type_id)
+		WHERE certificate_code = upper($1)`, strings.TrimSpace(c.Text()), c.Sender().ID).Scan(&redeemed, &expire, &owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "Сертификат с таким кодом не найден. Проверь код и попробуй еще раз, или напиши 'ОТМЕНА'"
+	}
+	if err != nil {
+		logger.Error("can't check gift certificate", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+		return "Не получилось проверить код, попробуй еще раз позже"
+	}
+	if redeemed {
+		return "Этот сертификат уже активирован"
+	}
+	if time.Now().UTC().After(expire) {
+		return "Срок действия сертификата истек " + expire.Format("02.01.2006")
+	}
+	if owned {
+		return giftWarmupsOwnedText
+	}
+	return ""
+}
+
+// redeemGiftCertificate marks certificate as redeemed and grants its content in one transaction
+func redeemGiftCertificate(c tele.Context) error {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return nil
+	}
+	userID := c.Sender().ID
+	code := strings.ToUpper(strings.TrimSpace(c.Text()))
+
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("redeemGiftCertificate: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var typeID string
+	err = tx.QueryRow(context.Background(), `
+		UPDATE gift_certificates
+		SET redeemed_by = $1, redeemed_dt = now() AT TIME ZONE 'UTC'
+		WHERE certificate_code = $2 AND redeemed_by IS NULL AND expire_dt > now() AT TIME ZONE 'UTC'
+		RETURNING type_id::text`, userID, code).Scan(&typeID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Этот сертификат уже активирован или истек")
+	}
+	if err != nil {
+		return fmt.Errorf("redeemGiftCertificate: %w", err)
+	}
+
+	t, err := getGiftCertificateType(typeID)
+	if err != nil {
+		return fmt.Errorf("redeemGiftCertificate: %w", err)
+	}
+
+	var text string
+	switch t.Kind {
+	case CertificateWarmups:
+		if t.GroupID == nil {
+			return fmt.Errorf("redeemGiftCertificate: certificate type %s has no warmup group", typeID)
+		}
+		var tag pgconn.CommandTag
+		tag, err = tx.Exec(context.Background(), `
+			INSERT INTO acquired_warmup_groups(user_id, group_id, checkout_id, price_when_acquired)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, group_id) DO NOTHING`, userID, *t.GroupID, "gift|"+code, "gift "+code)
+		if err == nil && tag.RowsAffected() == 0 {
+			// group was acquired after validation: certificate stays unredeemed, it can be given to someone else
+			logger.Warn("gift warmup group already acquired", zap.Int64("userID", userID), zap.String("code", code))
+			if err = c.Send(giftWarmupsOwnedText); err != nil {
+				return fmt.Errorf("redeemGiftCertificate: %w", err)
+			}
+			return BotExt.ContinueState
+		}
+		text = "Сертификат активирован! Теперь тебе доступен " + t.Description() + " в меню Упражнения"
+	case CertificateLessons:
+		if t.Lessons == nil {
+			return fmt.Errorf("redeemGiftCertificate: certificate type %s has no lessons", typeID)
+		}
+		_, err = tx.Exec(context.Background(), `
+			INSERT INTO lesson_credits(user_id, delta, reason)
+			VALUES ($1, $2, $3)`, userID, *t.Lessons, "gift "+code)
+		text = fmt.Sprintf("Сертификат активирован! На твой баланс добавлено занятий: %d. Чтобы записаться на урок, нажми 'Записаться на урок'", *t.Lessons)
+	default:
+		return fmt.Errorf("redeemGiftCertificate: unknown certificate kind %s", t.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("redeemGiftCertificate: %w", err)
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("redeemGiftCertificate: %w", err)
+	}
+
+	logger.Info("gift certificate redeemed", zap.Int64("userID", userID), zap.String("code", code))
+	notifyAdmins(c.Bot(), fmt.Sprintf("🎟 Активирован сертификат %s '%s' (%s). Получатель: %s [ID%d]",
+		code, t.Title, t.Description(), userMention(c.Sender()), userID))
+	return c.Send(text)
+}
@@ -3,6 +3,7 @@ module vocal_training_bot
 go 1.19
 
 require (
+	github.com/bradfitz/latlong v0.0.0-20170410180902-f3db6d0dff40
 	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/google/uuid v1.1.2
 	github.com/jackc/pgx/v5 v5.0.3
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// statuses of wannabe_student leads
+const (
+	LeadNew       = "NEW"
+	LeadContacted = "CONTACTED"
+	LeadBooked    = "BOOKED"
+	LeadLost      = "LOST"
+
+	// leadsPageSize - number of leads on one page of admin inbox
+	leadsPageSize = 8
+
+	leadsPrevPage = "prev"
+	leadsNextPage = "next"
+)
+
+var leadStatusTexts = map[string]string{
+	LeadNew:       "🆕 Новая",
+	LeadContacted: "📞 Связались",
+	LeadBooked:    "📅 Записан(а)",
+	LeadLost:      "❌ Потерян(а)",
+}
+
+// leadStatusEmoji returns first symbol of status text for compact lists
+func leadStatusEmoji(status string) string {
+	text, ok := leadStatusTexts[status]
+	if !ok {
+		return "❔"
+	}
+	return strings.Fields(text)[0]
+}
+
+func wannabeStudentValidator(c tele.Context) string {
+	if contact := c.Message().Contact; contact != nil {
+		if contact.UserID != c.Sender().ID {
+			return "Нажми на кнопку 'Позвонить', чтобы поделиться своим контактом"
+		}
+		return ""
+	}
+	switch c.Text() {
+	case "Отмена":
+		return ""
+	case "Написать в личку в телеграм":
+		if c.Sender().Username == "" {
+			return "У тебя в телеграме не указано имя пользователя, и я не смогу тебе написать... Нажми на кнопку 'Позвонить', чтобы поделиться своим контактом"
+		}
+		return ""
+	}
+	return "Не могу распознать ответ... Выбери вариант из списка"
+}
+
+func wannabeStudentManipulator(c tele.Context) error {
+	if c.Text() == "Отмена" {
+		return c.Send("OK", MainUserMenu)
+	}
+	userID := c.Sender().ID
+	userName := c.Sender().Username
+	phone := ""
+	if contact := c.Message().Contact; contact != nil {
+		phone = contact.PhoneNumber
+	}
+
+	var status string
+	err := DB.QueryRow(context.Background(), `
+	SELECT status FROM wannabe_student
+	WHERE user_id = $1 AND status IN ($2, $3)
+	ORDER BY created DESC
+	LIMIT 1`, userID, LeadNew, LeadContacted).Scan(&status)
+	if err == nil {
+		return c.Send("Заявка уже подана, с тобой свяжутся в ближайшее время!", MainUserMenu)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("wannabeStudentManipulator: %w", err)
+	}
+
+	var leadID int
+	err = DB.QueryRow(context.Background(), `
+	INSERT INTO wannabe_student(user_id, user_name, phone_num)
+	VALUES ($1, $2, $3)
+	RETURNING lead_id`, userID, userName, phone).Scan(&leadID)
+	if err != nil {
+		return fmt.Errorf("wannabeStudentManipulator: %w", err)
+	}
+
+	logger.Info("new lead", zap.Int64("userID", userID), zap.Int("leadID", leadID))
+	text := fmt.Sprintf("📨 Новая заявка #%d на урок от %s [ID%d]", leadID, userMention(c.Sender()), userID)
+	if phone != "" {
+		text += "\nПросит позвонить: " + phone
+	}
+	notifyAdmins(c.Bot(), text+"\nВсе заявки - в меню 'Заявки учеников'")
+
+	return c.Send("Заявка отправлена! С тобой свяжутся в ближайшее время 🤍", MainUserMenu)
+}
+
+// switchLeadsPage changes current page of admin inbox, page can't be negative
+func switchLeadsPage(userID int64, direction string) {
+	pageStr, _ := adminFSM.GetStateVar(userID, "leadsPage")
+	page, _ := strconv.Atoi(pageStr)
+	switch direction {
+	case leadsPrevPage:
+		page--
+	case leadsNextPage:
+		page++
+	}
+	if page < 0 {
+		page = 0
+	}
+	adminFSM.SetStateVar(userID, "leadsPage", strconv.Itoa(page))
+}
+
+// sendLeadCard sends everything known about the lead before showing lead menu
+func sendLeadCard(c tele.Context, leadID string) error {
+	var (
+		userID          int64
+		userName, phone string
+		notes, created  string
+	)
+	err := DB.QueryRow(context.Background(), `
+	SELECT user_id, user_name, COALESCE(phone_num, ''), notes, created::date::text FROM wannabe_student
+	WHERE lead_id = $1`, leadID).Scan(&userID, &userName, &phone, &notes, &created)
+	if err != nil {
+		return fmt.Errorf("sendLeadCard: can't query row: %w", err)
+	}
+
+	mention := "@" + userName
+	if userName == "" {
+		mention = "пользователя без username"
+	}
+	text := fmt.Sprintf("Заявка #%s от %s [ID%d], %s", leadID, mention, userID, created)
+	if notes != "" {
+		text += "\n\nЗаметки:\n" + notes
+	}
+	err = c.Send(text)
+	if err != nil {
+		return err
+	}
+	if phone != "" {
+		params := map[string]string{
+			"chat_id":      strconv.FormatInt(c.Sender().ID, 10),
+			"phone_number": phone,
+			"first_name":   "Заявка #" + leadID,
+		}
+		_, err = c.Bot().Raw("sendContact", params)
+		if err != nil {
+			return err
+		}
+		return c.Send("Пользователь просил позвонить по телефону")
+	}
+	return nil
+}
+
+func setLeadStatus(c tele.Context, status string) error {
+	leadID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedLead")
+	if !ok {
+		return fmt.Errorf("setLeadStatus: can't find state var selectedLead")
+	}
+	_, err := DB.Exec(context.Background(), `
+	UPDATE wannabe_student
+	SET status = $1, updated = now() AT TIME ZONE 'UTC'
+	WHERE lead_id = $2`, status, leadID)
+	if err != nil {
+		return fmt.Errorf("setLeadStatus: %w", err)
+	}
+	return nil
+}
+
+// AddLeadNote appends admin's note to the lead, every note is prefixed with date
+func AddLeadNote(c tele.Context) error {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return nil
+	}
+	leadID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedLead")
+	if !ok {
+		return fmt.Errorf("AddLeadNote: can't find state var selectedLead")
+	}
+	_, err := DB.Exec(context.Background(), `
+	UPDATE wannabe_student
+	SET notes = notes || to_char(now() AT TIME ZONE 'UTC', 'DD.MM.YYYY') || ': ' || $1 || E'\n',
+		updated = now() AT TIME ZONE 'UTC'
+	WHERE lead_id = $2`, c.Text(), leadID)
+	if err != nil {
+		return fmt.Errorf("AddLeadNote: %w", err)
+	}
+	return nil
+}
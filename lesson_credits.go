@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// lessonBalance returns number of lessons user can attend. Balance is the sum of lesson_credits ledger
+func lessonBalance(userID int64) (int, error) {
+	var balance int
+	err := DB.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(delta), 0) FROM lesson_credits
+		WHERE user_id = $1`, userID).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("lessonBalance: %w", err)
+	}
+	return balance, nil
+}
+
+// warnIfNoLessonsLeft notifies admins that student has spent all lesson credits
+func warnIfNoLessonsLeft(b *tele.Bot, userID int64, balance int) {
+	if balance > 0 {
+		return
+	}
+	var userName string
+	err := DB.QueryRow(context.Background(), `
+		SELECT username FROM users
+		WHERE user_id = $1`, userID).Scan(&userName)
+	if err != nil {
+		logger.Error("can't fetch username", zap.Int64("userID", userID), zap.Error(err))
+	}
+	notifyAdmins(b, fmt.Sprintf("⚠️ У ученика %s [ID%d] закончились оплаченные занятия, баланс: %d", userName, userID, balance))
+}
+
+func processLessonPackage(c tele.Context, packageID string) error {
+	var (
+		title   string
+		lessons int
+		price   int
+	)
+	err := DB.QueryRow(context.Background(), `
+		SELECT title, lessons, price FROM lesson_packages
+		WHERE package_id = $1 AND active = true`, packageID).Scan(&title, &lessons, &price)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Этот пакет занятий больше недоступен")
+	}
+	if err != nil {
+		return fmt.Errorf("processLessonPackage: can't select row: %w", err)
+	}
+
+	invoice := &tele.Invoice{
+		Title:       "Пакет занятий вокалом",
+		Description: fmt.Sprintf("Пакет '%s': занятий - %d. Занятия можно проводить онлайн и на студии", title, lessons),
+		Payload:     LessonPackagePayloadChecker + PayloadSplit + packageID,
+		Currency:    PaymentCurrency,
+		Prices: []tele.Price{
+			{
+				Label:  PaymentCurrency,
+				Amount: price * 100,
+			},
+		},
+		Token: ProviderToken,
+	}
+	return c.Send(invoice)
+}
+
+// lessonPackageCheckoutValidator returns "" if checkout can be accepted, otherwise - it is an error message for user
+func lessonPackageCheckoutValidator(userID int64, packageID string, checkout *tele.PreCheckoutQuery) string {
+	var active bool
+	var dbPrice int
+	err := DB.QueryRow(context.Background(), `
+		SELECT price*100, active FROM lesson_packages
+		WHERE package_id = $1`, packageID).Scan(&dbPrice, &active)
+	if err != nil {
+		logger.Error("can't find lesson package in db", zap.Int64("userID", userID), zap.String("packageID", packageID),
+			zap.String("checkoutID", checkout.ID), zap.Error(err))
+		return PaymentErrorText
+	}
+	if !active {
+		return "Этот пакет занятий больше недоступен"
+	}
+	if (dbPrice != checkout.Total) || (checkout.Currency != PaymentCurrency) {
+		logger.Error("price doesn't match", zap.Int64("userID", userID), zap.String("packageID", packageID),
+			zap.String("checkoutID", checkout.ID), zap.String("payload", checkout.Payload),
+			zap.Int("dbPrice", dbPrice), zap.Int("checkout.Total", checkout.Total),
+			zap.String("checkout.Currency", checkout.Currency),
+		)
+		return "Цена изменилась, попробуй купить пакет еще раз!"
+	}
+	return ""
+}
+
+// acquireLessonPackage adds lessons of paid package to user's balance. stored is false if nothing was saved
+func acquireLessonPackage(c tele.Context, payment *tele.Payment, packageID string) (stored bool, err error) {
+	userID := c.Sender().ID
+	chargeID := payment.TelegramChargeID
+	price := strconv.Itoa(payment.Total) + payment.Currency
+
+	var title string
+	err = DB.QueryRow(context.Background(), `
+		INSERT INTO lesson_credits(user_id, delta, reason, checkout_id, price_when_acquired)
+		SELECT $1, lessons, 'package ' || title, $3, $4
+		FROM lesson_packages
+		WHERE package_id = $2
+		ON CONFLICT (checkout_id) DO NOTHING
+		RETURNING (SELECT title FROM lesson_packages WHERE package_id = $2)`,
+		userID, packageID, chargeID, price).Scan(&title)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn("duplicate payment", zap.Int64("userID", userID), zap.String("chargeID", chargeID))
+		return false, nil
+	}
+	if err != nil {
+		logger.Error("exec db error", zap.Int64("userID", userID), zap.String("packageID", packageID),
+			zap.String("chargeID", chargeID), zap.String("providerChargeID", payment.ProviderChargeID), zap.Error(err))
+		return false, c.Send(PaymentLostText)
+	}
+
+	logger.Info("successful payment", zap.Int64("userID", userID), zap.String("packageID", packageID),
+		zap.String("price", price), zap.String("chargeID", chargeID),
+		zap.String("providerChargeID", payment.ProviderChargeID))
+
+	balance, err := lessonBalance(userID)
+	if err != nil {
+		return true, fmt.Errorf("acquireLessonPackage: %w", err)
+	}
+	notifyAdmins(c.Bot(), fmt.Sprintf("💳 Куплен пакет занятий '%s'. Ученик: %s [ID%d], баланс: %d",
+		title, userMention(c.Sender()), userID, balance))
+
+	return true, c.Send(paymentReceipt("Пакет занятий: "+title, payment, time.Now()) +
+		fmt.Sprintf("\n\nПакет занятий '%s' приобретен! Занятий на балансе: %d. Чтобы записаться, нажми 'Записаться на урок'", title, balance))
+}
+
+// grantLessons changes user's balance by admin, delta can be negative to correct mistakes
+func grantLessons(b *tele.Bot, adminID, userID int64, delta int) (balance int, err error) {
+	_, err = DB.Exec(context.Background(), `
+		INSERT INTO lesson_credits(user_id, delta, reason)
+		VALUES ($1, $2, $3)`, userID, delta, fmt.Sprintf("admin %d", adminID))
+	if err != nil {
+		return 0, fmt.Errorf("grantLessons: %w", err)
+	}
+	balance, err = lessonBalance(userID)
+	if err != nil {
+		return 0, fmt.Errorf("grantLessons: %w", err)
+	}
+	logger.Info("lessons granted", zap.Int64("admin", adminID), zap.Int64("userID", userID), zap.Int("delta", delta))
+
+	if delta > 0 {
+		_, err = b.Send(UserIDType{userID}, fmt.Sprintf("🎁 Тебе начислены занятия: %d. Занятий на балансе: %d", delta, balance))
+		if err != nil {
+			logger.Error("can't notify user about granted lessons", zap.Int64("userID", userID), zap.Error(err))
+		}
+	}
+	warnIfNoLessonsLeft(b, userID, balance)
+	return balance, nil
+}
+
+// markLessonAttended spends one lesson credit of the student for booked lesson. Lesson can be marked only once
+func markLessonAttended(b *tele.Bot, slotID string) (studentID int64, balance int, err error) {
+	tx, err := DB.Begin(context.Background())
+	if err != nil {
+		return 0, 0, fmt.Errorf("markLessonAttended: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	err = tx.QueryRow(context.Background(), `
+		UPDATE lesson_slots
+		SET attended = true
+		WHERE slot_id = $1 AND booked_by IS NOT NULL AND attended IS NULL
+		RETURNING booked_by`, slotID).Scan(&studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, fmt.Errorf("markLessonAttended: slot %s is not booked or already marked", slotID)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("markLessonAttended: %w", err)
+	}
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO lesson_credits(user_id, delta, reason)
+		VALUES ($1, -1, $2)`, studentID, "lesson "+slotID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("markLessonAttended: %w", err)
+	}
+	if err = tx.Commit(context.Background()); err != nil {
+		return 0, 0, fmt.Errorf("markLessonAttended: %w", err)
+	}
+
+	balance, err = lessonBalance(studentID)
+	if err != nil {
+		return studentID, 0, fmt.Errorf("markLessonAttended: %w", err)
+	}
+	logger.Info("lesson attended", zap.Int64("userID", studentID), zap.String("slotID", slotID), zap.Int("balance", balance))
+
+	text := fmt.Sprintf("Спасибо за урок 🤍 Занятий на балансе: %d", balance)
+	if balance <= 0 {
+		text += "\nПополнить баланс можно в меню 'Настройки аккаунта'"
+	}
+	if _, err = b.Send(UserIDType{studentID}, text); err != nil {
+		logger.Error("can't notify user about attended lesson", zap.Int64("userID", studentID), zap.Error(err))
+	}
+	warnIfNoLessonsLeft(b, studentID, balance)
+	return studentID, balance, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	LessonOnline = "ONLINE"
+	LessonStudio = "STUDIO"
+
+	lessonReminderList = "lessonReminderList"
+
+	// lessonRemindBefore - how long before the lesson reminder is sent
+	lessonRemindBefore = 3 * time.Hour
+
+	lessonSlotLayout = "02.01.2006 15:04"
+)
+
+// lessonDurations - minutes, online lessons are longer because of communication delays
+var lessonDurations = map[string]int{
+	LessonOnline: 90,
+	LessonStudio: 60,
+}
+
+func lessonFormatText(format string) string {
+	if format == LessonOnline {
+		return "💻 онлайн"
+	}
+	return "🏢 студия"
+}
+
+// lessonSlot is a time when teacher is available for a lesson. StartDT is in UTC
+type lessonSlot struct {
+	SlotID   string
+	StartDT  time.Time
+	Duration int
+	Format   string
+	BookedBy *int64
+}
+
+// Text returns human-readable slot in local time of viewer
+func (s *lessonSlot) Text(loc *time.Location) string {
+	return fmt.Sprintf("%s, %s (%d мин)", s.StartDT.In(loc).Format(lessonSlotLayout), lessonFormatText(s.Format), s.Duration)
+}
+
+func getLessonSlot(slotID string) (*lessonSlot, error) {
+	s := &lessonSlot{SlotID: slotID}
+	err := DB.QueryRow(context.Background(), `
+		SELECT start_dt, duration_min, format, booked_by FROM lesson_slots
+		WHERE slot_id = $1`, slotID).Scan(&s.StartDT, &s.Duration, &s.Format, &s.BookedBy)
+	if err != nil {
+		return nil, fmt.Errorf("getLessonSlot: %w", err)
+	}
+	return s, nil
+}
+
+// sendUpcomingLessons tells user about lessons already booked
+func sendUpcomingLessons(c tele.Context) error {
+	userID := c.Sender().ID
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("sendUpcomingLessons: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT slot_id::text, start_dt, duration_min, format FROM lesson_slots
+		WHERE booked_by = $1 AND start_dt > now() AT TIME ZONE 'UTC'
+		ORDER BY start_dt`, userID)
+	if err != nil {
+		return fmt.Errorf("sendUpcomingLessons: %w", err)
+	}
+	defer rows.Close()
+
+	var lessons []string
+	for rows.Next() {
+		s := lessonSlot{}
+		if err = rows.Scan(&s.SlotID, &s.StartDT, &s.Duration, &s.Format); err != nil {
+			return fmt.Errorf("sendUpcomingLessons: scan row: %w", err)
+		}
+		lessons = append(lessons, "📅 "+s.Text(loc))
+	}
+	if len(lessons) == 0 {
+		return nil
+	}
+	return c.Send("Ты уже записан(а) на уроки:\n" + strings.Join(lessons, "\n") +
+		"\n\nЧтобы перенести или отменить урок, напиши мне в личку @vershkovaaa")
+}
+
+// bookLessonSlot books slot for user. Slot is booked only if nobody has booked it yet, so it can't be double-booked
+func bookLessonSlot(c tele.Context, slotID string) error {
+	userID := c.Sender().ID
+	s := &lessonSlot{SlotID: slotID}
+	err := DB.QueryRow(context.Background(), `
+		UPDATE lesson_slots
+		SET booked_by = $1, booked_dt = now() AT TIME ZONE 'UTC',
+			-- lesson is too close, booking confirmation is enough
+			reminder_sent = start_dt - $3 * INTERVAL '1 second' <= now() AT TIME ZONE 'UTC'
+		WHERE slot_id = $2 AND booked_by IS NULL AND start_dt > now() AT TIME ZONE 'UTC'
+		RETURNING start_dt, duration_min, format`,
+		userID, slotID, lessonRemindBefore.Seconds()).Scan(&s.StartDT, &s.Duration, &s.Format)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Это окошко уже занято, выбери другое 🙏")
+	}
+	if err != nil {
+		return fmt.Errorf("bookLessonSlot: %w", err)
+	}
+
+	logger.Info("lesson booked", zap.Int64("userID", userID), zap.String("slotID", slotID))
+	if err = lessonReminderService.AddUser(userID); err != nil {
+		logger.Error("can't schedule lesson reminder", zap.Int64("userID", userID), zap.Error(err))
+	}
+	notifyAdmins(c.Bot(), fmt.Sprintf("📅 Новая запись на урок: %s (UTC). Ученик: %s [ID%d]",
+		s.Text(time.UTC), userMention(c.Sender()), userID))
+	publishAchievementEvent(c.Bot(), achievementEvent{Type: EventLessonBooked, UserID: userID})
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("bookLessonSlot: %w", err)
+	}
+	text := "Ты записан(а) на урок: " + s.Text(loc) + " 🤍\nЯ напомню о нем заранее"
+	if s.Format == LessonStudio {
+		text += "\n\nАдрес: Красный Октябрь, Берсеневская набережная 6 с2, we play music rooms"
+	}
+	return c.Send(text, MainUserMenu)
+}
+
+// lessonReminderHandler is a job function for lessonReminderService
+func lessonReminderHandler(b *tele.Bot, userID int64) error {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("lessonReminderHandler: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT slot_id::text, start_dt, duration_min, format FROM lesson_slots
+		WHERE booked_by = $1 AND reminder_sent = false
+			AND start_dt - $2 * INTERVAL '1 second' <= now() AT TIME ZONE 'UTC'
+			AND start_dt > now() AT TIME ZONE 'UTC'`, userID, lessonRemindBefore.Seconds())
+	if err != nil {
+		return fmt.Errorf("lessonReminderHandler: %w", err)
+	}
+	var slots []lessonSlot
+	for rows.Next() {
+		s := lessonSlot{}
+		if err = rows.Scan(&s.SlotID, &s.StartDT, &s.Duration, &s.Format); err != nil {
+			rows.Close()
+			return fmt.Errorf("lessonReminderHandler: scan row: %w", err)
+		}
+		slots = append(slots, s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("lessonReminderHandler: %w", err)
+	}
+
+	for _, s := range slots {
+		_, err = b.Send(UserIDType{userID}, "❗ НАПОМИНАНИЕ ❗ Скоро урок вокала: "+s.Text(loc)+
+			"\nНе забудь бутылочку воды и песни, которые хочешь разобрать 🎶🤍")
+		if err != nil {
+			return fmt.Errorf("lessonReminderHandler: %w", err)
+		}
+		// reminder is marked after sending, so failed one is retried
+		_, err = DB.Exec(context.Background(), `
+			UPDATE lesson_slots
+			SET reminder_sent = true
+			WHERE slot_id = $1`, s.SlotID)
+		if err != nil {
+			return fmt.Errorf("lessonReminderHandler: %w", err)
+		}
+	}
+	return nil
+}
+
+func getLessonReminderFromPg(userID int64) (timestamp int64, err error) {
+	reminders, err := fetchLessonReminders(userID)
+	if err != nil {
+		return 0, err
+	}
+	return reminders[userID], nil
+}
+
+func getLessonRemindersFromPg() (results notificationQuery, err error) {
+	return fetchLessonReminders(0)
+}
+
+// fetchLessonReminders returns the nearest lesson reminder of every user (userID = 0) or of specific user
+func fetchLessonReminders(userID int64) (results notificationQuery, err error) {
+	results = make(notificationQuery)
+	rows, err := DB.Query(context.Background(), `
+	SELECT booked_by, EXTRACT(EPOCH FROM MIN(start_dt) - $2 * INTERVAL '1 second') :: INT8
+	FROM lesson_slots
+	INNER JOIN users ON users.user_id = booked_by
+	WHERE reminder_sent = false AND users.active = true
+		AND start_dt > now() AT TIME ZONE 'UTC'
+		AND (($1 = 0) OR (booked_by = $1))
+	GROUP BY booked_by`, userID, lessonRemindBefore.Seconds())
+	if err != nil {
+		return results, fmt.Errorf("fetchLessonReminders: %w", err)
+	}
+	defer rows.Close()
+
+	var user, timestamp int64
+	for rows.Next() {
+		if err = rows.Scan(&user, &timestamp); err != nil {
+			return results, fmt.Errorf("fetchLessonReminders: scan row: %w", err)
+		}
+		results[user] = timestamp
+	}
+	if err = rows.Err(); err != nil {
+		return results, fmt.Errorf("fetchLessonReminders: postgres itetator %w", err)
+	}
+	return results, nil
+}
+
+// parseLessonSlots parses admin's input: one slot per line in lessonSlotLayout, local time of admin.
+// Returns UTC time of slots
+func parseLessonSlots(text string, loc *time.Location) ([]time.Time, error) {
+	var slots []time.Time
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		dt, err := time.ParseInLocation(lessonSlotLayout, line, loc)
+		if err != nil {
+			return nil, fmt.Errorf("parseLessonSlots: %w", err)
+		}
+		slots = append(slots, dt.UTC())
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("parseLessonSlots: no slots")
+	}
+	return slots, nil
+}
+
+// cancelLessonBooking frees booked slot and tells student about it
+func cancelLessonBooking(b *tele.Bot, slotID string) error {
+	var studentID *int64
+	s := &lessonSlot{SlotID: slotID}
+	err := DB.QueryRow(context.Background(), `
+		UPDATE lesson_slots slot
+		SET booked_by = NULL, booked_dt = NULL, reminder_sent = false
+		FROM lesson_slots prev
+		WHERE slot.slot_id = prev.slot_id AND slot.slot_id = $1
+		RETURNING prev.booked_by, slot.start_dt, slot.duration_min, slot.format`, slotID).Scan(
+		&studentID, &s.StartDT, &s.Duration, &s.Format)
+	if err != nil {
+		return fmt.Errorf("cancelLessonBooking: %w", err)
+	}
+	if studentID == nil {
+		return nil
+	}
+
+	if err = lessonReminderService.DelUser(*studentID); err != nil {
+		logger.Error("can't reschedule lesson reminder", zap.Int64("userID", *studentID), zap.Error(err))
+	}
+	if err = lessonReminderService.AddUser(*studentID); err != nil {
+		logger.Error("can't reschedule lesson reminder", zap.Int64("userID", *studentID), zap.Error(err))
+	}
+
+	loc, err := userLocation(*studentID)
+	if err != nil {
+		return fmt.Errorf("cancelLessonBooking: %w", err)
+	}
+	_, err = b.Send(UserIDType{*studentID}, "Урок "+s.Text(loc)+" отменен 🙏 Выбери другое время в меню 'Записаться на урок'")
+	if err != nil {
+		return fmt.Errorf("cancelLessonBooking: %w", err)
+	}
+	return nil
+}
+
+// deleteLessonSlot removes slot if it is not booked
+func deleteLessonSlot(slotID string) error {
+	_, err := DB.Exec(context.Background(), `
+		DELETE FROM lesson_slots
+		WHERE slot_id = $1 AND booked_by IS NULL`, slotID)
+	if err != nil {
+		return fmt.Errorf("deleteLessonSlot: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata" // timezones don't depend on the system database
 
 	"vocal_training_bot/BotExt"
 
@@ -16,6 +17,9 @@ import (
 )
 
 var notificationService *NotificationService
+var subscriptionReminderService *NotificationService
+var lessonReminderService *NotificationService
+var broadcastScheduler *BroadcastScheduler
 var logger *zap.Logger
 
 func main() {
@@ -33,9 +37,14 @@ func main() {
 	cfg := ParseConfig()
 
 	DB = InitDbConnection(cfg)
-	BotExt.SetVars(DB, logger)
+	BotExt.SetLogger(logger)
 	RD = InitCacheConnection(cfg)
-	notificationService = NewNotificationService(RD, 10*time.Second)
+	notificationService = NewNotificationService(RD, delayedNotificationList, 10*time.Second,
+		getNearestNotificationFromPg, getNearestNotificationsFromPg)
+	subscriptionReminderService = NewNotificationService(RD, subscriptionReminderList, time.Minute,
+		getSubscriptionReminderFromPg, getSubscriptionRemindersFromPg)
+	lessonReminderService = NewNotificationService(RD, lessonReminderList, time.Minute,
+		getLessonReminderFromPg, getLessonRemindersFromPg)
 
 	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
@@ -51,7 +60,11 @@ func main() {
 	}()
 
 	userBot := InitBot(cfg)
+	broadcastScheduler = NewBroadcastScheduler(userBot, 30*time.Second)
 	notificationService.Start()
+	subscriptionReminderService.Start()
+	lessonReminderService.Start()
+	broadcastScheduler.Start()
 	userBot.Start()
 }
 
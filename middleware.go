@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	prom "github.com/prometheus/client_golang/prometheus"
@@ -128,3 +130,56 @@ func MiddlewareMetrics() tele.MiddlewareFunc {
 		}
 	}
 }
+
+// activityUpdatePeriod - last_active of user is written to db not more often than that
+const activityUpdatePeriod = time.Hour
+
+// activityTracker remembers when last_active of every user was written, so most updates don't touch db
+type activityTracker struct {
+	mu       sync.Mutex
+	lastSeen map[int64]time.Time
+}
+
+// touch returns true if last_active of user must be written. Then the write time is remembered
+func (at *activityTracker) touch(userID int64, now time.Time) bool {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	if seen, ok := at.lastSeen[userID]; ok && now.Sub(seen) < activityUpdatePeriod {
+		return false
+	}
+	at.lastSeen[userID] = now
+	return true
+}
+
+// forget makes the next touch of user return true, used if write failed
+func (at *activityTracker) forget(userID int64) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	delete(at.lastSeen, userID)
+}
+
+// MiddlewareActivity saves time of user's last update, it is used for segments by activity.
+// It is updated not more often than once an hour, admins are not tracked
+func MiddlewareActivity() tele.MiddlewareFunc {
+	tracker := &activityTracker{lastSeen: make(map[int64]time.Time)}
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Sender() == nil || !tracker.touch(c.Sender().ID, time.Now()) {
+				return next(c)
+			}
+			userID := c.Sender().ID
+			if ug, _ := GetUserGroup(userID); ug == UGAdmin {
+				return next(c)
+			}
+			_, err := DB.Exec(context.Background(), `
+				UPDATE users
+				SET last_active = now() AT TIME ZONE 'UTC'
+				WHERE user_id = $1`, userID)
+			if err != nil {
+				tracker.forget(userID)
+				logger.Error("can't update last activity", zap.Int64("user", userID), zap.Error(err))
+			}
+			return next(c)
+		}
+	}
+}
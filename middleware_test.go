@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityTrackerTouch(t *testing.T) {
+	at := &activityTracker{lastSeen: make(map[int64]time.Time)}
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name   string
+		userID int64
+		at     time.Time
+		want   bool
+	}{
+		{"first update", 1, start, true},
+		{"right after", 1, start.Add(time.Minute), false},
+		{"other user", 2, start.Add(time.Minute), true},
+		{"almost an hour", 1, start.Add(activityUpdatePeriod - time.Second), false},
+		{"an hour later", 1, start.Add(activityUpdatePeriod), true},
+		{"after write", 1, start.Add(activityUpdatePeriod + time.Minute), false},
+	}
+	for _, s := range steps {
+		if got := at.touch(s.userID, s.at); got != s.want {
+			t.Fatalf("%s: touch = %v; want %v", s.name, got, s.want)
+		}
+	}
+
+	at.forget(2)
+	if !at.touch(2, start.Add(2*time.Minute)) {
+		t.Fatal("touch after forget = false; want true")
+	}
+}
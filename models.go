@@ -54,30 +54,93 @@ func createSchema(conn *pgxpool.Pool) {
 		
 		PRIMARY KEY (user_id)
 	);
-	/*
+	-- IANA timezone, e.g. Europe/Moscow. NULL for users registered before it, only timezone_raw is known then
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone text;
+	-- Europe/Kiev is deprecated in tz database
+	UPDATE users SET timezone = 'Europe/Kyiv', timezone_txt = 'Europe/Kyiv' WHERE timezone = 'Europe/Kiev';
+	-- data migrations made in code (not in this schema), every one is applied once
+	CREATE TABLE IF NOT EXISTS migrations (
+		name			text		PRIMARY KEY,
+		applied			timestamp	DEFAULT now()
+	);
+	-- UTC timestamp of last update from user, it is refreshed not more often than once an hour
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active timestamp;
+	-- false if user blocked the bot or deleted his account, such users don't get broadcasts and notifications
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS active bool NOT NULL DEFAULT true;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deactivated timestamp; -- UTC
 	CREATE TABLE IF NOT EXISTS wannabe_student (
+	    lead_id		serial		PRIMARY KEY,
 	    user_id		int8		REFERENCES users(user_id),
-	    user_name   text    	NOT NULL,
+	    user_name   text    	NOT NULL, -- telegram username, can be empty
 	    phone_num	text,
-	    resolved	bool 		DEFAULT false,
-	    created		timestamp	DEFAULT now()
+	    status		varchar(9)	NOT NULL DEFAULT 'NEW' CHECK (status IN ('NEW', 'CONTACTED', 'BOOKED', 'LOST')),
+	    notes		text		NOT NULL DEFAULT '',
+	    created		timestamp	DEFAULT now(),
+	    updated		timestamp	DEFAULT now()
 	);
-	*/
+	-- for tables created before lead statuses
+	ALTER TABLE wannabe_student ADD COLUMN IF NOT EXISTS lead_id serial;
+	ALTER TABLE wannabe_student ADD COLUMN IF NOT EXISTS status varchar(9) NOT NULL DEFAULT 'NEW';
+	ALTER TABLE wannabe_student ADD COLUMN IF NOT EXISTS notes text NOT NULL DEFAULT '';
+	ALTER TABLE wannabe_student ADD COLUMN IF NOT EXISTS updated timestamp DEFAULT now();
+	CREATE INDEX IF NOT EXISTS idx_wannabe_student__user_id ON wannabe_student(user_id);
 	CREATE TABLE IF NOT EXISTS states (
 		user_id			int8		NOT NULL, -- 64 bit integer for chat_id / user_id
 		state			text,
-		message_id      int4,
 		temp_vars		jsonb		NOT NULL DEFAULT '{}'::jsonb,
-		
+		menu_messages	jsonb		NOT NULL DEFAULT '{}'::jsonb, -- menu name -> id of message with menu
+
 		PRIMARY KEY (user_id)
 	);
+	-- single message_id guessed as next message after menu trigger is replaced by menu_messages
+	ALTER TABLE states ADD COLUMN IF NOT EXISTS menu_messages jsonb NOT NULL DEFAULT '{}'::jsonb;
+	ALTER TABLE states DROP COLUMN IF EXISTS message_id;
+
+	CREATE TABLE IF NOT EXISTS broadcasts (
+		broadcast_id	serial		PRIMARY KEY,
+		record_id		uuid		NOT NULL, -- messages of broadcast
+		status			varchar(9)	NOT NULL DEFAULT 'DRAFT' CHECK (status IN ('DRAFT', 'SCHEDULED', 'SENDING', 'SENT', 'CANCELED')),
+		send_at			timestamp,	-- UTC
+		created_by		int8		NOT NULL,
+		created			timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+		started			timestamp,
+		finished		timestamp
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcasts__status ON broadcasts(status, send_at);
+	-- filters of recipients, NULL - every user
+	ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS segment jsonb;
+	-- message of admin with delivery progress, it is edited while broadcast is sent
+	ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS progress_message_id int4;
+
+	-- queue of broadcast recipients, delivery is resumed from PENDING rows after restart
+	CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+		broadcast_id	int			REFERENCES broadcasts(broadcast_id) ON DELETE CASCADE,
+		user_id			int8		NOT NULL,
+		status			varchar(7)	NOT NULL DEFAULT 'PENDING',
+		error			text,		-- reason of failed delivery
+		sent			timestamp,	-- UTC
+
+		PRIMARY KEY (broadcast_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcast_deliveries__status ON broadcast_deliveries(broadcast_id, status);
+	ALTER TABLE broadcast_deliveries DROP CONSTRAINT IF EXISTS broadcast_deliveries_status_check;
+	ALTER TABLE broadcast_deliveries ADD CONSTRAINT broadcast_deliveries_status_check
+		CHECK (status IN ('PENDING', 'SENT', 'FAILED', 'BLOCKED'));
+
+	-- saved audience segments for reuse in broadcasts
+	CREATE TABLE IF NOT EXISTS audience_segments (
+		segment_id		serial		PRIMARY KEY,
+		name			text		NOT NULL,
+		filters			jsonb		NOT NULL,
+		created_by		int8		NOT NULL,
+		created			timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+	);
 
 	CREATE TABLE IF NOT EXISTS warmup_notifications (
 		user_id		int8		REFERENCES users(user_id),
 		
 		day_of_week 	varchar(3)  NOT NULL CHECK (day_of_week IN ('sun','mon','tue','wed','thu','fri','sat')),
-		trigger_switch	bool        NOT NULL DEFAULT true,
-		trigger_time 	time(0) 	NOT NULL DEFAULT '18:00:00'
+		trigger_switch	bool        NOT NULL DEFAULT true
 	);
 	CREATE INDEX IF NOT EXISTS idx_warmup_notification_timings__user_id ON warmup_notifications(user_id);
 	CREATE INDEX IF NOT EXISTS idx_warmup_notification_timings__switch ON warmup_notifications(trigger_switch);
@@ -88,6 +151,41 @@ func createSchema(conn *pgxpool.Pool) {
 	);
 	CREATE INDEX IF NOT EXISTS idx_warmup_notification_global__user_id ON warmup_notification_global(user_id);
 	CREATE INDEX IF NOT EXISTS idx_warmup_notification_global__global_switch ON warmup_notification_global(global_switch);
+	-- interval rule: reminders are sent every interval_days days counting from interval_start (local date of user)
+	ALTER TABLE warmup_notification_global ADD COLUMN IF NOT EXISTS interval_days int NOT NULL DEFAULT 1 CHECK (interval_days > 0);
+	ALTER TABLE warmup_notification_global ADD COLUMN IF NOT EXISTS interval_start date;
+
+	-- every sent warmup reminder and user's answer to it
+	CREATE TABLE IF NOT EXISTS practice_log (
+		record_id	serial		PRIMARY KEY,
+		user_id		int8		REFERENCES users(user_id),
+		message_id	int4		NOT NULL,
+		status		varchar(7)	NOT NULL DEFAULT 'SENT' CHECK (status IN ('SENT', 'DONE', 'SNOOZED', 'SKIPPED')),
+		sent		timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+		answered	timestamp,
+		UNIQUE (user_id, message_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_practice_log__sent ON practice_log(sent);
+
+	CREATE TABLE IF NOT EXISTS warmup_reminder_times (
+		user_id			int8		REFERENCES users(user_id),
+		day_of_week		varchar(3)	NOT NULL CHECK (day_of_week IN ('sun','mon','tue','wed','thu','fri','sat')),
+		trigger_time	time(0)		NOT NULL,
+		UNIQUE (user_id, day_of_week, trigger_time)
+	);
+	-- warmup_notifications.trigger_time was the only reminder of the day, it is moved to warmup_reminder_times
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'warmup_notifications' AND column_name = 'trigger_time'
+		) THEN
+			INSERT INTO warmup_reminder_times(user_id, day_of_week, trigger_time)
+			SELECT user_id, day_of_week, trigger_time FROM warmup_notifications
+			ON CONFLICT DO NOTHING;
+			ALTER TABLE warmup_notifications DROP COLUMN trigger_time;
+		END IF;
+	END $$;
 
 	CREATE TABLE IF NOT EXISTS messages (
 	    record_id		uuid		NOT NULL, 
@@ -106,6 +204,22 @@ func createSchema(conn *pgxpool.Pool) {
 		cheerup_id	serial	PRIMARY KEY,
 		record_id	uuid	-- REFERENCES messages(record_id) MATCH SIMPLE
 	);
+	ALTER TABLE warmup_cheerups ADD COLUMN IF NOT EXISTS categories text[] NOT NULL DEFAULT '{}'; -- streak, comeback, morning, evening
+	-- cheerups left in user's current cycle, the one with the lowest position is sent next
+	CREATE TABLE IF NOT EXISTS cheerup_rotation (
+		user_id		int8				REFERENCES users(user_id),
+		cheerup_id	int					REFERENCES warmup_cheerups(cheerup_id) ON DELETE CASCADE,
+		position	double precision	NOT NULL, -- random, so cycle is shuffled
+
+		PRIMARY KEY (user_id, cheerup_id)
+	);
+	CREATE TABLE IF NOT EXISTS cheerup_deliveries (
+		record_id	serial		PRIMARY KEY,
+		user_id		int8		REFERENCES users(user_id),
+		cheerup_id	int			REFERENCES warmup_cheerups(cheerup_id) ON DELETE CASCADE,
+		delivered	timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+	);
+	CREATE INDEX IF NOT EXISTS idx_cheerup_deliveries__user_id ON cheerup_deliveries(user_id);
 
 	CREATE TABLE IF NOT EXISTS warmup_groups (
 	    warmup_group_id	serial	PRIMARY KEY, 
@@ -119,6 +233,29 @@ func createSchema(conn *pgxpool.Pool) {
 	    warmup_name		text,
 	    record_id		uuid    -- REFERENCES messages(record_id) MATCH SIMPLE 
 	);
+	-- approximate practice time with the warmup, used in "Мой прогресс"
+	ALTER TABLE warmups ADD COLUMN IF NOT EXISTS duration_min int NOT NULL DEFAULT 10;
+
+	-- practice of the user: reminder acknowledged with "Сделано" or opened warmup
+	CREATE TABLE IF NOT EXISTS practice_sessions (
+		record_id	serial		PRIMARY KEY,
+		user_id		int8		REFERENCES users(user_id),
+		warmup_id	int			REFERENCES warmups(warmup_id) ON DELETE SET NULL,
+		local_date	date		NOT NULL, -- date in user's timezone, streaks are counted by it
+		minutes		int			NOT NULL,
+		created		timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+	);
+	CREATE INDEX IF NOT EXISTS idx_practice_sessions__user_id ON practice_sessions(user_id, local_date);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_practice_sessions__warmup ON practice_sessions(user_id, warmup_id, local_date)
+		WHERE warmup_id IS NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS user_achievements (
+		user_id			int8		REFERENCES users(user_id),
+		achievement_id	text		NOT NULL, -- id of achievementRule
+		achieved		timestamp	NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+
+		PRIMARY KEY (user_id, achievement_id)
+	);
 
 	CREATE TABLE IF NOT EXISTS acquired_warmup_groups (
 	    user_id				int8		REFERENCES users(user_id),
@@ -129,12 +266,199 @@ func createSchema(conn *pgxpool.Pool) {
 
 	    acquire_datetime	timestamp	DEFAULT now()
 	);
+
+	CREATE TABLE IF NOT EXISTS promo_codes (
+	    promo_code		text		PRIMARY KEY CHECK (promo_code = upper(promo_code)),
+	    discount_type	varchar(7)	NOT NULL CHECK (discount_type IN ('PERCENT', 'FIXED')),
+	    discount_value	int4		NOT NULL CHECK (discount_value > 0), -- percents or rubles
+	    expire_dt		timestamp,	-- UTC, NULL - no expiration
+	    usage_limit		int4		CHECK (usage_limit > 0), -- NULL - unlimited
+	    active			bool		NOT NULL DEFAULT true,
+	    created			timestamp	DEFAULT now(),
+
+	    CHECK (discount_type = 'FIXED' OR discount_value <= 100)
+	);
+
+	-- restriction of promo code to specific warmup groups. No rows - promo code works for every group
+	CREATE TABLE IF NOT EXISTS promo_code_groups (
+	    promo_code	text	REFERENCES promo_codes(promo_code) ON DELETE CASCADE,
+	    group_id	int		REFERENCES warmup_groups(warmup_group_id) ON DELETE CASCADE,
+
+	    PRIMARY KEY (promo_code, group_id)
+	);
+
+	ALTER TABLE acquired_warmup_groups ADD COLUMN IF NOT EXISTS promo_code text REFERENCES promo_codes(promo_code);
+	CREATE INDEX IF NOT EXISTS idx_acquired_warmup_groups__promo_code ON acquired_warmup_groups(promo_code);
+	-- payments for items that were acquired already (e.g. two concurrent checkouts), admins refund them manually
+	CREATE TABLE IF NOT EXISTS duplicate_payments (
+	    checkout_id			text		PRIMARY KEY, -- telegram charge id
+	    provider_charge_id	text,
+	    user_id				int8		REFERENCES users(user_id),
+	    payload				text		NOT NULL,
+	    price				text		NOT NULL,
+	    created				timestamp	DEFAULT now()
+	);
+
+	-- ledger of lesson credits: balance of user is SUM(delta)
+	CREATE TABLE IF NOT EXISTS lesson_credits (
+	    record_id	serial		PRIMARY KEY,
+	    user_id		int8		REFERENCES users(user_id),
+	    delta		int2		NOT NULL,
+	    reason		text		NOT NULL,
+	    created		timestamp	DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_lesson_credits__user_id ON lesson_credits(user_id);
+
+	CREATE TABLE IF NOT EXISTS lesson_packages (
+	    package_id	serial		PRIMARY KEY,
+	    title		text		NOT NULL,
+	    lessons		int2		NOT NULL CHECK (lessons > 0),
+	    price		int4		NOT NULL CHECK (price > 0),
+	    active		bool		NOT NULL DEFAULT true
+	);
+	-- paid packages: telegram charge id makes payment processing idempotent
+	ALTER TABLE lesson_credits ADD COLUMN IF NOT EXISTS checkout_id text UNIQUE;
+	ALTER TABLE lesson_credits ADD COLUMN IF NOT EXISTS price_when_acquired text;
+
+	CREATE TABLE IF NOT EXISTS gift_certificate_types (
+	    type_id		serial		PRIMARY KEY,
+	    title		text		NOT NULL,
+	    kind		varchar(7)	NOT NULL CHECK (kind IN ('WARMUPS', 'LESSONS')),
+	    group_id	int			REFERENCES warmup_groups(warmup_group_id), -- for WARMUPS
+	    lessons		int2		CHECK (lessons > 0), -- for LESSONS
+	    price		int4		NOT NULL CHECK (price > 0),
+	    active		bool		NOT NULL DEFAULT true,
+
+	    CHECK ((kind = 'WARMUPS' AND group_id IS NOT NULL) OR (kind = 'LESSONS' AND lessons IS NOT NULL))
+	);
+
+	CREATE TABLE IF NOT EXISTS lesson_slots (
+	    slot_id			serial		PRIMARY KEY,
+	    start_dt		timestamp	NOT NULL UNIQUE, -- UTC
+	    duration_min	int2		NOT NULL CHECK (duration_min > 0),
+	    format			varchar(6)	NOT NULL CHECK (format IN ('ONLINE', 'STUDIO')),
+
+	    booked_by		int8		REFERENCES users(user_id),
+	    booked_dt		timestamp, -- UTC
+	    reminder_sent	bool		NOT NULL DEFAULT false
+	);
+	CREATE INDEX IF NOT EXISTS idx_lesson_slots__booked_by ON lesson_slots(booked_by);
+	ALTER TABLE lesson_slots ADD COLUMN IF NOT EXISTS attended bool; -- NULL until teacher marks the lesson
+
+	CREATE TABLE IF NOT EXISTS gift_certificates (
+	    certificate_code	text		PRIMARY KEY,
+	    type_id				int			REFERENCES gift_certificate_types(type_id),
+	    buyer_id			int8		REFERENCES users(user_id),
+
+	    checkout_id			text		UNIQUE NOT NULL,
+	    price_when_acquired	text		NOT NULL,
+
+	    created				timestamp	DEFAULT now(),
+	    expire_dt			timestamp	NOT NULL, -- UTC
+	    redeemed_by			int8		REFERENCES users(user_id),
+	    redeemed_dt			timestamp -- UTC
+	);
+
+	CREATE TABLE IF NOT EXISTS subscription_plans (
+	    plan_id		serial	PRIMARY KEY,
+	    plan_name	text	NOT NULL,
+	    period_days	int2	NOT NULL CHECK (period_days > 0), -- 30 for monthly, 365 for yearly plan
+	    price		int4	NOT NULL CHECK (price > 0),
+	    active		bool	NOT NULL DEFAULT true -- inactive plans can't be bought, but old subscriptions stay valid
+	);
+
+	CREATE TABLE IF NOT EXISTS subscriptions (
+	    subscription_id		serial		PRIMARY KEY,
+	    user_id				int8		REFERENCES users(user_id),
+	    plan_id				int			REFERENCES subscription_plans(plan_id),
+
+	    checkout_id			text		UNIQUE NOT NULL,
+	    price_when_acquired	text		NOT NULL,
+
+	    start_dt			timestamp	NOT NULL, -- UTC
+	    expire_dt			timestamp	NOT NULL, -- UTC, grace period is not included
+	    reminder_stage		int2		NOT NULL DEFAULT 0 -- 0 - nothing sent, 1 - sent before expiration, 2 - sent on expiration
+	);
+	CREATE INDEX IF NOT EXISTS idx_subscriptions__user_id ON subscriptions(user_id);
 	`
 
 	if _, err := conn.Exec(context.Background(), schema); err != nil {
 		panic(fmt.Errorf("createSchema: %w", err))
 
 	}
+	// acquisition code relies on the unique index, so the bot can't work without it
+	if err := runMigration(conn, warmupDuplicatesMigration, migrateWarmupDuplicates); err != nil {
+		panic(fmt.Errorf("createSchema: %w", err))
+	}
+	// users without timezone keep working with fixed offset, so failed migration is not fatal
+	if err := runMigration(conn, timezonesMigration, migrateTimezones); err != nil {
+		logger.Error("can't migrate timezones", zap.Error(err))
+	}
+}
+
+// runMigration applies data migration in transaction, if it isn't applied yet according to migrations table
+func runMigration(conn *pgxpool.Pool, name string, migrate func(tx pgx.Tx) error) error {
+	ctx := context.Background()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("runMigration[%s]: %w", name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO migrations(name) VALUES ($1)
+		ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return fmt.Errorf("runMigration[%s]: %w", name, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	if err = migrate(tx); err != nil {
+		return fmt.Errorf("runMigration[%s]: %w", name, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("runMigration[%s]: %w", name, err)
+	}
+	logger.Info("migration applied", zap.String("migration", name))
+	return nil
+}
+
+// warmupDuplicatesMigration - name of migrateWarmupDuplicates in migrations table
+const warmupDuplicatesMigration = "warmup_duplicates"
+
+// migrateWarmupDuplicates makes warmup group acquisition unique per user. Warmup group could be acquired twice
+// before it, later acquisitions become duplicate payments for refund
+func migrateWarmupDuplicates(tx pgx.Tx) error {
+	ctx := context.Background()
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO duplicate_payments(checkout_id, user_id, payload, price, created)
+		SELECT checkout_id, user_id, 'BuyWarmupGroup|' || group_id, price_when_acquired, acquire_datetime
+		FROM (
+			SELECT *, row_number() OVER (PARTITION BY user_id, group_id ORDER BY acquire_datetime, checkout_id) AS n
+			FROM acquired_warmup_groups) AS acquisitions
+		WHERE n > 1
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("migrateWarmupDuplicates: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM acquired_warmup_groups
+		WHERE checkout_id IN (SELECT checkout_id FROM duplicate_payments)`)
+	if err != nil {
+		return fmt.Errorf("migrateWarmupDuplicates: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_acquired_warmup_groups__user_id_group_id
+		ON acquired_warmup_groups(user_id, group_id)`)
+	if err != nil {
+		return fmt.Errorf("migrateWarmupDuplicates: %w", err)
+	}
+	if tag.RowsAffected() != 0 {
+		logger.Warn("duplicate warmup group acquisitions are moved to duplicate_payments",
+			zap.Int64("count", tag.RowsAffected()))
+	}
+	return nil
 }
 
 func initUserDBs(userID int64) error {
@@ -161,6 +485,16 @@ func initUserDBs(userID int64) error {
 		return fmt.Errorf("initUserDBs: %w", err)
 	}
 
+	// default reminder for every day, only for users without reminders
+	_, err = DB.Exec(context.Background(), `
+	INSERT INTO warmup_reminder_times(user_id, day_of_week, trigger_time)
+	SELECT $1, day_of_week, $2 FROM warmup_notifications
+	WHERE user_id = $1 AND NOT EXISTS (SELECT 1 FROM warmup_reminder_times WHERE user_id = $1)
+	ON CONFLICT DO NOTHING`, userID, defaultReminderTime)
+	if err != nil {
+		return fmt.Errorf("initUserDBs: %w", err)
+	}
+
 	_, err = DB.Exec(context.Background(), `
 	INSERT INTO warmup_notification_global(user_id)
 	VALUES ($1)
@@ -177,18 +511,6 @@ func initUserDBs(userID int64) error {
 	return nil
 }
 
-func getRandomCheerup() (recordID string, err error) {
-	// TODO prevent repetition (with warmup_notification_global.last_cheerup_id)
-	err = DB.QueryRow(context.Background(), `
-		SELECT record_id from warmup_cheerups
-		ORDER BY RANDOM()
-		LIMIT 1`).Scan(&recordID)
-	if err != nil {
-		err = fmt.Errorf("selectRandomCheerup: %w", err)
-	}
-	return
-}
-
 func InitCacheConnection(cfg Config) *redis.Client {
 	rd := redis.NewClient(&redis.Options{
 		Addr:     cfg.Redis.Host + ":" + cfg.Redis.Port,
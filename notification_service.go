@@ -1,31 +1,39 @@
 package main
 
 import (
-	"context"
-	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 )
 
 const delayedNotificationList = "delayedNotificationList"
 
+// NotificationService is a delayed queue of users in redis sorted set, score is unix timestamp of next notification.
+//   - listName - name of sorted set, different services should use different lists
+//   - fetchOne - returns next notification timestamp for specific user, 0 if there's nothing to schedule
+//   - fetchAll - returns next notification timestamps for all users, used to reschedule and to rebuild the queue
 type NotificationService struct {
 	rd        *redis.Client
+	listName  string
 	frequency time.Duration
 	handler   func(int64) error // job function
+	fetchOne  func(int64) (int64, error)
+	fetchAll  func() (notificationQuery, error)
 	quit      chan struct{}
 }
 
-func NewNotificationService(rd *redis.Client, frequency time.Duration) *NotificationService {
+func NewNotificationService(rd *redis.Client, listName string, frequency time.Duration,
+	fetchOne func(int64) (int64, error), fetchAll func() (notificationQuery, error)) *NotificationService {
 	ns := &NotificationService{}
 
 	ns.rd = rd
+	ns.listName = listName
 	ns.frequency = frequency
+	ns.fetchOne = fetchOne
+	ns.fetchAll = fetchAll
 	return ns
 }
 
@@ -56,7 +64,7 @@ func (ns *NotificationService) Stop() {
 }
 
 func (ns *NotificationService) AddUser(userID int64) error {
-	ts, err := getNearestNotificationFromPg(userID)
+	ts, err := ns.fetchOne(userID)
 	if err != nil {
 		return fmt.Errorf("NotificationService.AddUser: %w", err)
 	}
@@ -69,7 +77,7 @@ func (ns *NotificationService) AddUser(userID int64) error {
 
 // add user only if it does not exist or if new score is less than existing one
 func (ns *NotificationService) addUser(userID int64, timestamp int64) error {
-	req := ns.rd.ZScore(delayedNotificationList, strconv.FormatInt(userID, 10))
+	req := ns.rd.ZScore(ns.listName, strconv.FormatInt(userID, 10))
 	currentTimestamp := int64(req.Val())
 	if timestamp == 0 {
 		return nil
@@ -77,7 +85,7 @@ func (ns *NotificationService) addUser(userID int64, timestamp int64) error {
 	if (timestamp > currentTimestamp) && currentTimestamp != 0 {
 		return nil
 	}
-	_, err := ns.rd.ZAdd(delayedNotificationList, redis.Z{Member: userID, Score: float64(timestamp)}).Result()
+	_, err := ns.rd.ZAdd(ns.listName, redis.Z{Member: userID, Score: float64(timestamp)}).Result()
 	if err != nil {
 		return fmt.Errorf("NotificationService.addUserToQueue[%d]: %w", userID, err)
 	}
@@ -85,7 +93,7 @@ func (ns *NotificationService) addUser(userID int64, timestamp int64) error {
 }
 
 func (ns *NotificationService) DelUser(userID int64) error {
-	err := ns.rd.ZRem(delayedNotificationList, strconv.FormatInt(userID, 10)).Err()
+	err := ns.rd.ZRem(ns.listName, strconv.FormatInt(userID, 10)).Err()
 	if err != nil {
 		return fmt.Errorf("NotificationService.delUser[%d]: %w", userID, err)
 	}
@@ -94,7 +102,7 @@ func (ns *NotificationService) DelUser(userID int64) error {
 
 func (ns *NotificationService) processUsers() error {
 	now := time.Now().Unix()
-	users, err := ns.rd.ZRevRangeByScore(delayedNotificationList,
+	users, err := ns.rd.ZRevRangeByScore(ns.listName,
 		redis.ZRangeBy{
 			Min: "1",
 			Max: strconv.FormatInt(now, 10),
@@ -114,6 +122,13 @@ func (ns *NotificationService) processUsers() error {
 			continue
 		}
 		err = ns.handler(userID)
+		if isBotBlocked(err) {
+			// user is removed from every queue, so he isn't retried
+			if err = deactivateUser(userID); err != nil {
+				logger.Error("deactivateUser", zap.Int64("user", userID), zap.Error(err))
+			}
+			continue
+		}
 		if err != nil {
 			logger.Error("handler", zap.Int64("user", userID), zap.Error(err))
 			continue
@@ -126,14 +141,14 @@ func (ns *NotificationService) processUsers() error {
 		updateList = append(updateList, userID)
 	}
 
-	currentNotifications, err := getNearestNotificationsFromPg()
+	currentNotifications, err := ns.fetchAll()
 	if err != nil {
-		logger.Error("getNearestNotificationsFromPg", zap.Error(err))
+		logger.Error("fetchAll", zap.String("list", ns.listName), zap.Error(err))
 	}
 	for _, updateUserID := range updateList {
 		timestamp, ok := currentNotifications[updateUserID]
 		if !ok {
-			logger.Error("no userID in map", zap.Int64("user", updateUserID))
+			logger.Debug("nothing to schedule", zap.String("list", ns.listName), zap.Int64("user", updateUserID))
 			continue
 		}
 		err = ns.addUser(updateUserID, timestamp)
@@ -150,7 +165,7 @@ func (ns *NotificationService) RebuildQueue() error {
 	if err != nil {
 		return fmt.Errorf("NotificationService.RebuildQueue: %w", err)
 	}
-	currentNotifications, err := getNearestNotificationsFromPg()
+	currentNotifications, err := ns.fetchAll()
 	if err != nil {
 		return fmt.Errorf("NotificationService.RebuildQueue get currentNotifications: %w", err)
 	}
@@ -165,7 +180,7 @@ func (ns *NotificationService) RebuildQueue() error {
 }
 
 func (ns *NotificationService) purge() error {
-	err := ns.rd.Del(delayedNotificationList).Err()
+	err := ns.rd.Del(ns.listName).Err()
 	if err != nil {
 		return fmt.Errorf("NotificationService.purge: %w", err)
 	}
@@ -173,132 +188,34 @@ func (ns *NotificationService) purge() error {
 }
 
 func getNearestNotificationFromPg(userID int64) (timestamp int64, err error) {
-	err = DB.QueryRow(context.Background(), `
-		SELECT
-			MIN(EXTRACT(EPOCH FROM nearest_notification) :: INT) AS nearest_notification_ts
-		FROM
-			(SELECT user_dt :: DATE + trigger_time + INTERVAL '1 day' * (
-				CASE
-					WHEN (user_dow_int = extract(dow FROM user_dt)) AND (user_dt :: TIME < trigger_time) THEN 0
-					ELSE
-						CASE
-							WHEN user_dow_int > extract(dow FROM user_dt) THEN user_dow_int - extract(dow FROM user_dt)
-							ELSE 7 - extract(dow FROM user_dt) + user_dow_int
-						END
-				END
-			) - timezone_raw * INTERVAL '1 minute' AS nearest_notification -- convert to UTC Time
-
-			FROM (
-				SELECT
-					user_id,
-					trigger_time,
-					user_dt,
-					timezone_raw,
-					CASE day_of_week
-						WHEN 'sun' THEN 0
-						WHEN 'mon' THEN 1
-						WHEN 'tue' THEN 2
-						WHEN 'wed' THEN 3
-						WHEN 'thu' THEN 4
-						WHEN 'fri' THEN 5
-						WHEN 'sat' THEN 6
-					END AS user_dow_int
-				FROM warmup_notifications
-				INNER JOIN(
-					SELECT user_id
-					FROM warmup_notification_global
-					WHERE global_switch = TRUE
-				) notifications USING (user_id)
-				INNER JOIN(
-					SELECT
-						user_id,
-						timezone_raw,
-						now() AT TIME ZONE 'UTC' + (timezone_raw * INTERVAL '1 minute') AS user_dt
-					FROM users
-				) user_time USING (user_id)
-				WHERE trigger_switch = TRUE
-			) warmup_table_query
-			WHERE user_id = $1
-		) query`, userID).Scan(&timestamp)
-
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		return timestamp, err
+	schedules, err := fetchWarmupSchedules(userID)
+	if err != nil {
+		return 0, fmt.Errorf("getNearestNotificationFromPg: %w", err)
 	}
-	return timestamp, nil
+	schedule, ok := schedules[userID]
+	if !ok {
+		return 0, nil
+	}
+	next, ok := nextNotification(*schedule, time.Now())
+	if !ok {
+		return 0, nil
+	}
+	return next.Unix(), nil
 }
 
 type notificationQuery map[int64]int64 // key - userID, value - timestamp
 
 func getNearestNotificationsFromPg() (results notificationQuery, err error) {
 	results = make(notificationQuery)
-	rows, err := DB.Query(context.Background(), `
-	SELECT user_id, nearest_notification_ts FROM
-		(SELECT 
-			user_id,
-			EXTRACT(EPOCH FROM nearest_notification) AS nearest_notification_ts,
-			row_number() OVER (PARTITION BY user_id ORDER BY nearest_notification) priority
-		FROM
-			(SELECT 
-				user_id,
-				user_dt :: DATE + trigger_time + INTERVAL '1 day' * (
-				CASE
-					WHEN (user_dow_int = extract(dow FROM user_dt)) AND (user_dt :: TIME < trigger_time) THEN 0
-					ELSE 
-						CASE
-							WHEN user_dow_int > extract(dow FROM user_dt) THEN user_dow_int - extract(dow FROM user_dt)
-							ELSE 7 - extract(dow FROM user_dt) + user_dow_int
-						END
-				END
-				) - timezone_raw * INTERVAL '1 minute' AS nearest_notification -- convert to UTC Time
-			FROM
-				(SELECT 
-					user_id,
-					trigger_time,
-					user_dt,
-					timezone_raw,
-					CASE day_of_week
-						WHEN 'sun' THEN 0
-						WHEN 'mon' THEN 1
-						WHEN 'tue' THEN 2
-						WHEN 'wed' THEN 3
-						WHEN 'thu' THEN 4
-						WHEN 'fri' THEN 5
-						WHEN 'sat' THEN 6
-					END AS user_dow_int
-				FROM warmup_notifications
-				INNER JOIN(
-					SELECT user_id
-					FROM warmup_notification_global
-					WHERE global_switch = TRUE
-				) notifications USING (user_id)
-				INNER JOIN(
-					SELECT 
-						user_id,
-						timezone_raw,
-						now() AT TIME ZONE 'UTC' + (timezone_raw * INTERVAL '1 minute') AS user_dt
-					FROM users
-				) user_time USING (user_id)
-				WHERE trigger_switch = TRUE 
-				) warmup_table_query
-			) time_convert_query
-		) window_query
-	WHERE priority = 1`)
-	defer rows.Close()
+	schedules, err := fetchWarmupSchedules(0)
 	if err != nil {
 		return results, fmt.Errorf("getNearestNotificationsFromPg: %w", err)
 	}
-
-	var userID, timestamp int64
-	for rows.Next() {
-		err := rows.Scan(&userID, &timestamp)
-		if err != nil {
-			return results, fmt.Errorf("getNearestNotificationsFromPg: scan row: %w", err)
+	now := time.Now()
+	for userID, schedule := range schedules {
+		if next, ok := nextNotification(*schedule, now); ok {
+			results[userID] = next.Unix()
 		}
-		results[userID] = timestamp
-	}
-	if err := rows.Err(); err != nil {
-		return results, fmt.Errorf("getNearestNotificationsFromPg: postgres itetator %w", err)
 	}
-	return
+	return results, nil
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// statuses of practice_log records
+const (
+	PracticeSent    = "SENT"
+	PracticeDone    = "DONE"
+	PracticeSnoozed = "SNOOZED"
+	PracticeSkipped = "SKIPPED"
+
+	// reminderSnooze - delay of snoozed warmup reminder
+	reminderSnooze = 30 * time.Minute
+	// practiceReportDays - period of practice report for admins
+	practiceReportDays = 7
+
+	reminderDoneButton   = "done"
+	reminderSnoozeButton = "snooze"
+	reminderSkipButton   = "skip"
+)
+
+var warmupReminderMarkup = warmupReminderMarkupConstructor()
+
+// warmupReminderMarkupConstructor creates buttons of warmup reminder, they are processed in OnUserInlineResult
+func warmupReminderMarkupConstructor() *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("✅ Сделано", reminderDoneButton, WarmupReminderMessage)),
+		menu.Row(
+			menu.Data("⏰ Отложить на 30 мин", reminderSnoozeButton, WarmupReminderMessage),
+			menu.Data("⏭ Пропустить сегодня", reminderSkipButton, WarmupReminderMessage),
+		),
+	)
+	return menu
+}
+
+func logReminderSent(userID int64, messageID int) error {
+	_, err := DB.Exec(context.Background(), `
+		INSERT INTO practice_log(user_id, message_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, userID, messageID)
+	if err != nil {
+		return fmt.Errorf("logReminderSent: %w", err)
+	}
+	return nil
+}
+
+// answerReminder saves user's answer to the reminder. Reminder can be answered only once,
+// ok is false if it has been answered already
+func answerReminder(userID int64, messageID int, status string) (ok bool, err error) {
+	tag, err := DB.Exec(context.Background(), `
+		INSERT INTO practice_log(user_id, message_id, status, answered)
+		VALUES ($1, $2, $3, now() AT TIME ZONE 'UTC')
+		ON CONFLICT (user_id, message_id) DO UPDATE
+		SET status = EXCLUDED.status, answered = EXCLUDED.answered
+		WHERE practice_log.status = $4`, userID, messageID, status, PracticeSent)
+	if err != nil {
+		return false, fmt.Errorf("answerReminder: %w", err)
+	}
+	return tag.RowsAffected() != 0, nil
+}
+
+// processReminderAnswer handles buttons of warmup reminder message
+func processReminderAnswer(c tele.Context, action string) error {
+	userID := c.Sender().ID
+	var status, answer string
+	switch action {
+	case reminderDoneButton:
+		status, answer = PracticeDone, "✅ Сделано! Так держать 🤍"
+	case reminderSnoozeButton:
+		status, answer = PracticeSnoozed, "⏰ Напомню через 30 минут"
+	case reminderSkipButton:
+		status, answer = PracticeSkipped, "⏭ Сегодня отдыхаем, напомню в следующий раз"
+	default:
+		return fmt.Errorf("processReminderAnswer: unknown action %s", action)
+	}
+
+	ok, err := answerReminder(userID, c.Message().ID, status)
+	if err != nil {
+		return fmt.Errorf("processReminderAnswer: %w", err)
+	}
+	if !ok {
+		// reminder is answered already, buttons are left after unsuccessful edit
+		_, err = c.Bot().EditReplyMarkup(c.Message(), nil)
+		return err
+	}
+
+	switch status {
+	case PracticeDone:
+		// answer is saved already, so user should see it even if streak isn't updated
+		if recordErr := recordPractice(c.Bot(), userID, ""); recordErr != nil {
+			logger.Error("can't record practice", zap.Int64("userID", userID), zap.Error(recordErr))
+		}
+		publishAchievementEvent(c.Bot(), achievementEvent{Type: EventReminderDone, UserID: userID})
+	case PracticeSnoozed:
+		err = snoozeReminder(userID)
+	case PracticeSkipped:
+		err = skipTodayReminders(userID)
+	}
+	if err != nil {
+		return fmt.Errorf("processReminderAnswer: %w", err)
+	}
+	logger.Info("reminder answered", zap.Int64("userID", userID), zap.String("status", status))
+
+	return c.Edit(c.Message().Text + "\n\n" + answer)
+}
+
+// snoozeReminder reschedules user's warmup notification to reminderSnooze from now. User has one queued
+// notification, so if the next scheduled reminder comes earlier, it is kept instead: user is reminded anyway
+func snoozeReminder(userID int64) error {
+	at := time.Now().Add(reminderSnooze).Unix()
+	next, err := getNearestNotificationFromPg(userID)
+	if err != nil {
+		return fmt.Errorf("snoozeReminder: %w", err)
+	}
+	if (next != 0) && (next < at) {
+		at = next
+	}
+	// queued notification can be later than snooze, addUser would keep it
+	if err = notificationService.DelUser(userID); err != nil {
+		return fmt.Errorf("snoozeReminder: %w", err)
+	}
+	if err = notificationService.addUser(userID, at); err != nil {
+		return fmt.Errorf("snoozeReminder: %w", err)
+	}
+	return nil
+}
+
+// skipTodayReminders reschedules user's warmup notification to the first reminder after today in user's timezone
+func skipTodayReminders(userID int64) error {
+	schedules, err := fetchWarmupSchedules(userID)
+	if err != nil {
+		return fmt.Errorf("skipTodayReminders: %w", err)
+	}
+	if err = notificationService.DelUser(userID); err != nil {
+		return fmt.Errorf("skipTodayReminders: %w", err)
+	}
+	schedule, ok := schedules[userID]
+	if !ok {
+		return nil
+	}
+	local := time.Now().In(schedule.Loc)
+	endOfToday := triggerTime(local, 1, 0, schedule.Loc).Add(-time.Second)
+	next, ok := nextNotification(*schedule, endOfToday)
+	if !ok {
+		return nil
+	}
+	if err = notificationService.addUser(userID, next.Unix()); err != nil {
+		return fmt.Errorf("skipTodayReminders: %w", err)
+	}
+	return nil
+}
+
+// sendPracticeReport tells admin how students follow warmup reminders during last practiceReportDays days
+func sendPracticeReport(c tele.Context) error {
+	rows, err := DB.Query(context.Background(), `
+		SELECT user_id, COALESCE(username, ''),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = $2),
+			COUNT(*) FILTER (WHERE status = $3),
+			COUNT(*) FILTER (WHERE status = $4)
+		FROM practice_log
+		INNER JOIN users USING (user_id)
+		WHERE sent > now() AT TIME ZONE 'UTC' - $1 * INTERVAL '1 day'
+		GROUP BY user_id, username
+		ORDER BY COUNT(*) FILTER (WHERE status = $2) DESC, user_id`,
+		practiceReportDays, PracticeDone, PracticeSnoozed, PracticeSkipped)
+	if err != nil {
+		return fmt.Errorf("sendPracticeReport: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		userID                       int64
+		userName                     string
+		sent, done, snoozed, skipped int
+		lines                        []string
+	)
+	for rows.Next() {
+		if err = rows.Scan(&userID, &userName, &sent, &done, &snoozed, &skipped); err != nil {
+			return fmt.Errorf("sendPracticeReport: scan row: %w", err)
+		}
+		lines = append(lines, fmt.Sprintf("%s [ID%d]: ✅ %d/%d, ⏰ %d, ⏭ %d", userName, userID, done, sent, snoozed, skipped))
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("sendPracticeReport: postgres itetator %w", err)
+	}
+	if len(lines) == 0 {
+		return c.Send(fmt.Sprintf("За последние %d дней напоминаний не было", practiceReportDays))
+	}
+	return c.Send(fmt.Sprintf("Дневник занятий за последние %d дней (✅ сделано/напоминаний, ⏰ отложено, ⏭ пропущено):\n\n",
+		practiceReportDays) + strings.Join(lines, "\n"))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// reminderPracticeMinutes - how long practice is counted when user answers "Сделано" to reminder
+	reminderPracticeMinutes = 15
+
+	// favouriteWarmupsCount - number of the most used warmups in "Мой прогресс"
+	favouriteWarmupsCount = 3
+
+	practiceDateLayout = "2006-01-02"
+)
+
+// streakMilestones - streak lengths in days that are celebrated with special cheerup
+var streakMilestones = map[int]string{
+	3:   "🔥 3 дня подряд! Отличное начало!",
+	7:   "🔥 Целая неделя занятий подряд! Голос точно это заметит 🎶",
+	14:  "🔥 2 недели подряд! Это уже привычка 💪",
+	30:  "🏆 Месяц занятий без пропусков! Ты невероятный(ая) 🤍",
+	60:  "🏆 60 дней подряд! Это уровень профи 🎤",
+	100: "👑 100 дней подряд! Легендарная серия 🤍",
+}
+
+// practiceStreaks returns current and longest series of consecutive practice days.
+// days - distinct local dates of practice sorted ascending, today - local date of user.
+// Current series isn't broken until the end of today, so it may end yesterday
+func practiceStreaks(days []time.Time, today time.Time) (current, longest int) {
+	series := 0
+	for i, day := range days {
+		if i > 0 && daysBetween(days[i-1], day) == 1 {
+			series++
+		} else {
+			series = 1
+		}
+		if series > longest {
+			longest = series
+		}
+	}
+	if len(days) != 0 && daysBetween(days[len(days)-1], today) <= 1 {
+		current = series
+	}
+	return current, longest
+}
+
+// practiceDays returns distinct local dates of user's practice sorted ascending
+func practiceDays(userID int64) ([]time.Time, error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT DISTINCT local_date FROM practice_sessions
+		WHERE user_id = $1
+		ORDER BY local_date`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("practiceDays: %w", err)
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	var day time.Time
+	for rows.Next() {
+		if err = rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("practiceDays: scan row: %w", err)
+		}
+		days = append(days, day)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("practiceDays: postgres itetator %w", err)
+	}
+	return days, nil
+}
+
+// recordPractice adds practice session to user's log: warmupID is "" if practice is acknowledged in reminder.
+// Warmup is counted once a day. The first practice of the day can reach streak milestone, it is celebrated
+func recordPractice(b *tele.Bot, userID int64, warmupID string) error {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("recordPractice: %w", err)
+	}
+	today := time.Now().In(loc)
+
+	var firstToday bool
+	err = DB.QueryRow(context.Background(), `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM practice_sessions
+			WHERE user_id = $1 AND local_date = $2
+		)`, userID, today.Format(practiceDateLayout)).Scan(&firstToday)
+	if err != nil {
+		return fmt.Errorf("recordPractice: %w", err)
+	}
+
+	if warmupID == "" {
+		_, err = DB.Exec(context.Background(), `
+			INSERT INTO practice_sessions(user_id, local_date, minutes)
+			VALUES ($1, $2, $3)`, userID, today.Format(practiceDateLayout), reminderPracticeMinutes)
+	} else {
+		_, err = DB.Exec(context.Background(), `
+			INSERT INTO practice_sessions(user_id, local_date, minutes, warmup_id)
+			SELECT $1, $2, duration_min, warmup_id FROM warmups
+			WHERE warmup_id = $3
+			ON CONFLICT (user_id, warmup_id, local_date) WHERE warmup_id IS NOT NULL DO NOTHING`,
+			userID, today.Format(practiceDateLayout), warmupID)
+	}
+	if err != nil {
+		return fmt.Errorf("recordPractice: %w", err)
+	}
+	if !firstToday {
+		return nil
+	}
+
+	days, err := practiceDays(userID)
+	if err != nil {
+		return fmt.Errorf("recordPractice: %w", err)
+	}
+	current, _ := practiceStreaks(days, today)
+	publishAchievementEvent(b, achievementEvent{Type: EventPracticeRecorded, UserID: userID, Streak: current})
+	if text, ok := streakMilestones[current]; ok {
+		logger.Info("streak milestone", zap.Int64("userID", userID), zap.Int("streak", current))
+		if err = sendMilestoneCheerup(b, userID, text); err != nil {
+			return fmt.Errorf("recordPractice: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendMilestoneCheerup congratulates user with streak milestone and sends cheerup
+func sendMilestoneCheerup(b *tele.Bot, userID int64, text string) error {
+	_, err := b.Send(UserIDType{userID}, text)
+	if err != nil {
+		return fmt.Errorf("sendMilestoneCheerup: %w", err)
+	}
+	cheerupRecordID, err := getRandomCheerup(userID, CheerupStreak)
+	if err != nil {
+		return fmt.Errorf("sendMilestoneCheerup: %w", err)
+	}
+	if cheerupRecordID == "" {
+		return nil
+	}
+	return SendMessageToUser(b, userID, cheerupRecordID, false)
+}
+
+// sendProgress shows "Мой прогресс": streaks, practice minutes of last 7 days and favourite warmups
+func sendProgress(c tele.Context) error {
+	userID := c.Sender().ID
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("sendProgress: %w", err)
+	}
+	today := time.Now().In(loc)
+
+	days, err := practiceDays(userID)
+	if err != nil {
+		return fmt.Errorf("sendProgress: %w", err)
+	}
+	current, longest := practiceStreaks(days, today)
+
+	var weekMinutes int
+	err = DB.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(minutes), 0) FROM practice_sessions
+		WHERE user_id = $1 AND local_date > $2::date - 7`,
+		userID, today.Format(practiceDateLayout)).Scan(&weekMinutes)
+	if err != nil {
+		return fmt.Errorf("sendProgress: %w", err)
+	}
+
+	rows, err := DB.Query(context.Background(), `
+		SELECT COALESCE(warmup_name, ''), COUNT(*) FROM practice_sessions
+		INNER JOIN warmups USING (warmup_id)
+		WHERE user_id = $1
+		GROUP BY warmup_id, warmup_name
+		ORDER BY COUNT(*) DESC, warmup_id
+		LIMIT $2`, userID, favouriteWarmupsCount)
+	if err != nil {
+		return fmt.Errorf("sendProgress: %w", err)
+	}
+	defer rows.Close()
+	var favourites []string
+	var name string
+	var count int
+	for rows.Next() {
+		if err = rows.Scan(&name, &count); err != nil {
+			return fmt.Errorf("sendProgress: scan row: %w", err)
+		}
+		favourites = append(favourites, fmt.Sprintf("🎶 %s - %d", name, count))
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("sendProgress: postgres itetator %w", err)
+	}
+
+	text := fmt.Sprintf("🔥 Текущая серия: %d дн.\n🏆 Лучшая серия: %d дн.\n⏱ За последние 7 дней: %d мин",
+		current, longest, weekMinutes)
+	if len(favourites) != 0 {
+		text += "\n\nЛюбимые распевки:\n" + strings.Join(favourites, "\n")
+	}
+	if len(days) == 0 {
+		text += "\n\nОткрывай распевки и отмечай напоминания кнопкой 'Сделано' - здесь появится твоя статистика 🤍"
+	}
+	return c.Send(text)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	DiscountPercent = "PERCENT"
+	DiscountFixed   = "FIXED"
+)
+
+// promoCode is a discount for warmup group purchases.
+//   - DiscountValue - percents for DiscountPercent, rubles for DiscountFixed
+//   - Expire, UsageLimit - nil if there's no limit
+//   - Groups - ids of warmup groups code is applicable to, empty - applicable to every group
+type promoCode struct {
+	Code          string
+	DiscountType  string
+	DiscountValue int
+	Expire        *time.Time
+	UsageLimit    *int
+	Used          int
+	Active        bool
+	Groups        []int32
+}
+
+// getPromoCode fetches promo code from database. Codes are case-insensitive, returns pgx.ErrNoRows if there's no such code
+func getPromoCode(code string) (*promoCode, error) {
+	p := &promoCode{}
+	err := DB.QueryRow(context.Background(), `
+		SELECT promo_code, discount_type, discount_value, expire_dt, usage_limit, active,
+			(SELECT COUNT(*) FROM acquired_warmup_groups WHERE acquired_warmup_groups.promo_code = promo_codes.promo_code),
+			COALESCE((SELECT array_agg(group_id) FROM promo_code_groups WHERE promo_code_groups.promo_code = promo_codes.promo_code), '{}')
+		FROM promo_codes
+		WHERE promo_code = upper($1)`, strings.TrimSpace(code)).Scan(
+		&p.Code, &p.DiscountType, &p.DiscountValue, &p.Expire, &p.UsageLimit, &p.Active, &p.Used, &p.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("getPromoCode: %w", err)
+	}
+	return p, nil
+}
+
+// applicableTo returns "" if promo code can be used for warmup group, otherwise - it is an error message for user
+func (p *promoCode) applicableTo(warmupGroupID string) string {
+	if !p.Active {
+		return "Этот промокод больше не действует"
+	}
+	if (p.Expire != nil) && time.Now().UTC().After(*p.Expire) {
+		return "Срок действия промокода истек"
+	}
+	if (p.UsageLimit != nil) && (p.Used >= *p.UsageLimit) {
+		return "Промокод уже использован максимальное количество раз"
+	}
+	if len(p.Groups) == 0 {
+		return ""
+	}
+	for _, groupID := range p.Groups {
+		if strconv.Itoa(int(groupID)) == warmupGroupID {
+			return ""
+		}
+	}
+	return "Этот промокод не действует на выбранный пакет распевок"
+}
+
+// apply returns discounted amount. amount and result are in the smallest units of the currency (kopecks)
+func (p *promoCode) apply(amount int) int {
+	switch p.DiscountType {
+	case DiscountPercent:
+		amount = amount * (100 - p.DiscountValue) / 100
+	case DiscountFixed:
+		amount -= p.DiscountValue * 100
+	}
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// String returns human-readable discount, e.g. -15% or -500 руб.
+func (p *promoCode) String() string {
+	if p.DiscountType == DiscountPercent {
+		return fmt.Sprintf("-%d%%", p.DiscountValue)
+	}
+	return fmt.Sprintf("-%d руб.", p.DiscountValue)
+}
+
+// warmupGroupAmount returns full and discounted price of warmup group in kopecks.
+// promo is nil if there's no code, or it is not applicable anymore
+func warmupGroupAmount(warmupGroupID string, code string) (fullAmount, amount int, promo *promoCode, err error) {
+	err = DB.QueryRow(context.Background(), `
+		SELECT price*100 FROM warmup_groups
+		WHERE warmup_group_id = $1`, warmupGroupID).Scan(&fullAmount)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("warmupGroupAmount: %w", err)
+	}
+	if code == "" {
+		return fullAmount, fullAmount, nil, nil
+	}
+	promo, err = getPromoCode(code)
+	if err == nil && promo.applicableTo(warmupGroupID) == "" {
+		return fullAmount, promo.apply(fullAmount), promo, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, nil, err
+	}
+	return fullAmount, fullAmount, nil, nil
+}
+
+// priceWhenAcquired is a text for acquired_warmup_groups.price_when_acquired, contains applied discount
+func priceWhenAcquired(amount int, currency string, fullAmount int, promo *promoCode) string {
+	s := strconv.Itoa(amount) + currency
+	if promo != nil {
+		s += fmt.Sprintf(" (%s %s: -%d%s)", promo.Code, promo.String(), fullAmount-amount, currency)
+	}
+	return s
+}
+
+// sendWarmupInvoice sends invoice for warmup group, price is discounted if promo code is applicable.
+// If promo code makes group free, the group is granted without payment
+func sendWarmupInvoice(c tele.Context, warmupGroupID string, code string) error {
+	fullAmount, amount, promo, err := warmupGroupAmount(warmupGroupID, code)
+	if err != nil {
+		return fmt.Errorf("sendWarmupInvoice: %w", err)
+	}
+
+	var warmupGroupName string
+	err = DB.QueryRow(context.Background(), `
+		SELECT group_name FROM warmup_groups
+		WHERE warmup_group_id = $1`, warmupGroupID).Scan(&warmupGroupName)
+	if err != nil {
+		return fmt.Errorf("sendWarmupInvoice: %w", err)
+	}
+
+	payload := WarmupPayloadChecker + PayloadSplit + warmupGroupID
+	if promo != nil {
+		payload += PayloadSplit + promo.Code
+	}
+
+	if amount == 0 {
+		return grantWarmupGroupByPromo(c, warmupGroupID, warmupGroupName, fullAmount, promo)
+	}
+
+	prices := []tele.Price{{Label: PaymentCurrency, Amount: fullAmount}}
+	if promo != nil {
+		prices = append(prices, tele.Price{Label: "Промокод " + promo.Code, Amount: amount - fullAmount})
+	}
+	invoice := &tele.Invoice{
+		Title:       "Покупка пакета распевок",
+		Description: "Пакет распевок '" + warmupGroupName + "'",
+		Payload:     payload,
+		Currency:    PaymentCurrency,
+		Prices:      prices,
+		Token:       ProviderToken,
+	}
+	return c.Send(invoice)
+}
+
+func grantWarmupGroupByPromo(c tele.Context, warmupGroupID, warmupGroupName string, fullAmount int, promo *promoCode) error {
+	userID := c.Sender().ID
+	checkoutID := fmt.Sprintf("promo|%s|%d|%s", promo.Code, userID, warmupGroupID)
+	tag, err := DB.Exec(context.Background(), `
+		INSERT INTO acquired_warmup_groups(user_id, group_id, checkout_id, price_when_acquired, promo_code)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`,
+		userID, warmupGroupID, checkoutID, priceWhenAcquired(0, PaymentCurrency, fullAmount, promo), promo.Code)
+	if err != nil {
+		return fmt.Errorf("grantWarmupGroupByPromo: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		// the group is acquired already, e.g. by double submit or concurrent payment
+		return c.Send(WarmupAcquiredText)
+	}
+	logger.Info("warmup group granted by promo code", zap.Int64("userID", userID),
+		zap.String("warmupGroupID", warmupGroupID), zap.String("promoCode", promo.Code))
+	return c.Send("Промокод применен! Пакет распевок '" + warmupGroupName + "' теперь доступен в меню Упражнения")
+}
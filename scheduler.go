@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// defaultReminderTime - reminder time of every day for new users
+	defaultReminderTime = "18:00"
+	// maxRemindersPerDay - limit of reminder times for one day of week
+	maxRemindersPerDay = 5
+	// maxIntervalDays - the longest interval rule: reminders every maxIntervalDays days
+	maxIntervalDays = 3
+)
+
+// weekdays - values of warmup_notifications.day_of_week
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// weeklyTrigger is a notification time in user's local time, repeated every week
+type weeklyTrigger struct {
+	Weekday time.Weekday
+	Clock   time.Duration // since local midnight
+}
+
+// reminderSchedule is every enabled trigger of the user with user's timezone.
+// If IntervalDays > 1, triggers fire only every IntervalDays days counting from local date IntervalStart
+type reminderSchedule struct {
+	Triggers      []weeklyTrigger
+	Loc           *time.Location
+	IntervalDays  int
+	IntervalStart time.Time
+}
+
+// onInterval reports whether reminders are sent on the local date
+func (s *reminderSchedule) onInterval(date time.Time) bool {
+	if s.IntervalDays <= 1 || s.IntervalStart.IsZero() {
+		return true
+	}
+	days := daysBetween(s.IntervalStart, date) % s.IntervalDays
+	return days == 0
+}
+
+// daysBetween returns number of calendar days from date a to date b, time and timezone are ignored
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	diff := time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC).Sub(time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC))
+	return int(diff.Hours() / 24)
+}
+
+// nextNotification returns the nearest fire time of schedule strictly after now, ok is false if there are no triggers.
+// Trigger of today that has already passed is scheduled next week.
+// Local time is resolved by Loc, so DST is taken into account: trigger in the skipped hour is shifted by the gap
+func nextNotification(schedule reminderSchedule, now time.Time) (next time.Time, ok bool) {
+	local := now.In(schedule.Loc)
+	interval := schedule.IntervalDays
+	if interval < 1 {
+		interval = 1
+	}
+	// every trigger repeats at least once in 7 * interval days
+	for days := 0; days <= 7*interval; days++ {
+		date := triggerTime(local, days, 0, schedule.Loc)
+		if !schedule.onInterval(date) {
+			continue
+		}
+		for _, trigger := range schedule.Triggers {
+			if trigger.Weekday != date.Weekday() {
+				continue
+			}
+			candidate := triggerTime(local, days, trigger.Clock, schedule.Loc)
+			if !candidate.After(now) {
+				continue
+			}
+			if !ok || candidate.Before(next) {
+				next, ok = candidate, true
+			}
+		}
+		if ok {
+			return next, true
+		}
+	}
+	return next, false
+}
+
+// triggerTime returns clock of the day that is days after local day.
+// Clock in the hour skipped by DST is moved forward by the gap, e.g. 02:30 becomes 03:30
+func triggerTime(local time.Time, days int, clock time.Duration, loc *time.Location) time.Time {
+	y, m, d := local.Date()
+	hour := int(clock / time.Hour)
+	minute := int(clock % time.Hour / time.Minute)
+	second := int(clock % time.Minute / time.Second)
+	t := time.Date(y, m, d+days, hour, minute, second, 0, loc)
+	if t.Hour() != hour || t.Minute() != minute {
+		// time.Date resolves skipped clock with the offset before the transition, so it is earlier than asked
+		_, before := t.Zone()
+		_, after := t.Add(3 * time.Hour).Zone()
+		t = t.Add(time.Duration(after-before) * time.Second)
+	}
+	return t
+}
+
+// fetchWarmupSchedules returns schedules of users with enabled warmup notifications: every user (userID = 0) or specific user
+func fetchWarmupSchedules(userID int64) (map[int64]*reminderSchedule, error) {
+	rows, err := DB.Query(context.Background(), `
+	SELECT user_id, day_of_week, EXTRACT(EPOCH FROM trigger_time) :: INT8, timezone, COALESCE(timezone_raw, 0),
+		interval_days, interval_start
+	FROM warmup_reminder_times
+	INNER JOIN warmup_notifications USING (user_id, day_of_week)
+	INNER JOIN warmup_notification_global USING (user_id)
+	INNER JOIN users USING (user_id)
+	WHERE global_switch = TRUE AND trigger_switch = TRUE AND users.active = TRUE
+		AND (($1 = 0) OR (user_id = $1))`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("fetchWarmupSchedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := make(map[int64]*reminderSchedule)
+	var (
+		user, clock   int64
+		dayOfWeek     string
+		zone          *string
+		tzRaw         int
+		intervalDays  int
+		intervalStart *time.Time
+	)
+	for rows.Next() {
+		if err = rows.Scan(&user, &dayOfWeek, &clock, &zone, &tzRaw, &intervalDays, &intervalStart); err != nil {
+			return nil, fmt.Errorf("fetchWarmupSchedules: scan row: %w", err)
+		}
+		weekday, ok := weekdays[dayOfWeek]
+		if !ok {
+			return nil, fmt.Errorf("fetchWarmupSchedules: unknown day of week %s", dayOfWeek)
+		}
+		schedule, ok := schedules[user]
+		if !ok {
+			loc, err := zoneLocation(zone, tzRaw)
+			if err != nil {
+				logger.Error("fetchWarmupSchedules: can't load timezone, fixed offset is used", zap.Int64("userID", user),
+					zap.Error(err))
+			}
+			schedule = &reminderSchedule{Loc: loc, IntervalDays: intervalDays}
+			if intervalStart != nil {
+				schedule.IntervalStart = *intervalStart
+			}
+			schedules[user] = schedule
+		}
+		schedule.Triggers = append(schedule.Triggers, weeklyTrigger{
+			Weekday: weekday,
+			Clock:   time.Duration(clock) * time.Second,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetchWarmupSchedules: postgres itetator %w", err)
+	}
+	return schedules, nil
+}
+
+// rescheduleWarmupNotifications replaces user's warmup notification in the queue after schedule is changed
+func rescheduleWarmupNotifications(userID int64) error {
+	ts, err := getNearestNotificationFromPg(userID)
+	if err != nil {
+		return fmt.Errorf("rescheduleWarmupNotifications: %w", err)
+	}
+	if err = notificationService.DelUser(userID); err != nil {
+		return fmt.Errorf("rescheduleWarmupNotifications: %w", err)
+	}
+	if err = notificationService.addUser(userID, ts); err != nil {
+		return fmt.Errorf("rescheduleWarmupNotifications: %w", err)
+	}
+	return nil
+}
+
+// addReminderTime adds one more reminder to the day of week, time is HH:MM.
+// Nothing is added if the day already has maxRemindersPerDay reminders
+func addReminderTime(userID int64, day, clock string) error {
+	_, err := DB.Exec(context.Background(), `
+		INSERT INTO warmup_reminder_times(user_id, day_of_week, trigger_time)
+		SELECT $1, $2, $3
+		WHERE (SELECT COUNT(*) FROM warmup_reminder_times WHERE user_id = $1 AND day_of_week = $2) < $4
+		ON CONFLICT DO NOTHING`, userID, day, clock, maxRemindersPerDay)
+	if err != nil {
+		return fmt.Errorf("addReminderTime: %w", err)
+	}
+	return nil
+}
+
+// deleteReminderTime removes reminder of the day of week, time is HH:MM
+func deleteReminderTime(userID int64, day, clock string) error {
+	_, err := DB.Exec(context.Background(), `
+		DELETE FROM warmup_reminder_times
+		WHERE user_id = $1 AND day_of_week = $2 AND trigger_time = $3`, userID, day, clock)
+	if err != nil {
+		return fmt.Errorf("deleteReminderTime: %w", err)
+	}
+	return nil
+}
+
+// removeReminderTime deletes reminder chosen in WarmupDayTimesMenu, day is taken from state var "day"
+func removeReminderTime(c tele.Context, clock string) error {
+	userID := c.Sender().ID
+	day, ok := userFSM.GetStateVar(userID, "day")
+	if !ok {
+		return fmt.Errorf("removeReminderTime: can't get var day")
+	}
+	if err := deleteReminderTime(userID, day, clock); err != nil {
+		return fmt.Errorf("removeReminderTime: %w", err)
+	}
+	userInlineMenus.Update(c, WarmupDayTimesMenu)
+	if err := rescheduleWarmupNotifications(userID); err != nil {
+		return fmt.Errorf("removeReminderTime: %w", err)
+	}
+	return nil
+}
+
+// switchReminderInterval changes interval rule: every day -> every other day -> ... -> every maxIntervalDays days.
+// Interval starts from today in user's timezone
+func switchReminderInterval(userID int64) error {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return fmt.Errorf("switchReminderInterval: %w", err)
+	}
+	today := time.Now().In(loc).Format("2006-01-02")
+	_, err = DB.Exec(context.Background(), `
+		UPDATE warmup_notification_global
+		SET interval_days = interval_days % $2 + 1, interval_start = $3
+		WHERE user_id = $1`, userID, maxIntervalDays, today)
+	if err != nil {
+		return fmt.Errorf("switchReminderInterval: %w", err)
+	}
+	return nil
+}
+
+// reminderIntervalText is a text of interval rule for WarmupNotificationsMenu
+func reminderIntervalText(days int) string {
+	switch days {
+	case 1:
+		return "🔁 По выбранным дням"
+	case 2:
+		return "🔁 Через день"
+	}
+	return fmt.Sprintf("🔁 Раз в %d дня", days)
+}
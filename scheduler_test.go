@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("can't load location %s: %v", name, err)
+	}
+	return loc
+}
+
+func clock(h, m int) time.Duration {
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+}
+
+func TestNextNotification(t *testing.T) {
+	moscow := mustLoadLocation(t, "Europe/Moscow")
+	newYork := mustLoadLocation(t, "America/New_York")
+	losAngeles := mustLoadLocation(t, "America/Los_Angeles")
+
+	tests := []struct {
+		name     string
+		schedule reminderSchedule
+		now      time.Time
+		want     time.Time // zero - no notification
+	}{
+		{
+			name: "later today",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Saturday, Clock: clock(18, 0)},
+			}},
+			now:  time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 17, 18, 0, 0, 0, moscow),
+		},
+		{
+			name: "today's time has passed - next week",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Saturday, Clock: clock(9, 0)},
+			}},
+			now:  time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 24, 9, 0, 0, 0, moscow),
+		},
+		{
+			name: "trigger exactly now - next week",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Saturday, Clock: clock(10, 0)},
+			}},
+			now:  time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 24, 10, 0, 0, 0, moscow),
+		},
+		{
+			name: "week wraparound from saturday to monday",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Monday, Clock: clock(8, 0)},
+			}},
+			now:  time.Date(2026, 10, 17, 23, 59, 0, 0, moscow),
+			want: time.Date(2026, 10, 19, 8, 0, 0, 0, moscow),
+		},
+		{
+			name: "the nearest of several triggers",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Wednesday, Clock: clock(7, 0)},
+				{Weekday: time.Sunday, Clock: clock(20, 0)},
+				{Weekday: time.Sunday, Clock: clock(8, 0)},
+			}},
+			now:  time.Date(2026, 10, 17, 12, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 18, 8, 0, 0, 0, moscow),
+		},
+		{
+			name: "local date is ahead of UTC date",
+			schedule: reminderSchedule{Loc: moscow, Triggers: []weeklyTrigger{
+				{Weekday: time.Sunday, Clock: clock(9, 0)},
+			}},
+			// saturday 22:30 UTC is sunday 01:30 in Moscow
+			now:  time.Date(2026, 10, 17, 22, 30, 0, 0, time.UTC),
+			want: time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "local date is behind UTC date",
+			schedule: reminderSchedule{Loc: losAngeles, Triggers: []weeklyTrigger{
+				{Weekday: time.Saturday, Clock: clock(21, 0)},
+			}},
+			// sunday 03:00 UTC is saturday 20:00 in Los Angeles
+			now:  time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 10, 18, 4, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "DST starts: day is 23 hours long",
+			schedule: reminderSchedule{Loc: newYork, Triggers: []weeklyTrigger{
+				{Weekday: time.Sunday, Clock: clock(9, 0)},
+			}},
+			now:  time.Date(2026, 3, 7, 12, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 8, 13, 0, 0, 0, time.UTC), // 09:00 EDT
+		},
+		{
+			name: "DST starts: trigger in the skipped hour is shifted by the gap",
+			schedule: reminderSchedule{Loc: newYork, Triggers: []weeklyTrigger{
+				{Weekday: time.Sunday, Clock: clock(2, 30)},
+			}},
+			now:  time.Date(2026, 3, 7, 12, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 8, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+		},
+		{
+			name: "DST ends: day is 25 hours long",
+			schedule: reminderSchedule{Loc: newYork, Triggers: []weeklyTrigger{
+				{Weekday: time.Sunday, Clock: clock(9, 0)},
+			}},
+			now:  time.Date(2026, 10, 31, 12, 0, 0, 0, newYork),
+			want: time.Date(2026, 11, 1, 14, 0, 0, 0, time.UTC), // 09:00 EST
+		},
+		{
+			name: "every other day skips day off the interval",
+			schedule: reminderSchedule{Loc: moscow, IntervalDays: 2,
+				IntervalStart: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+				Triggers: []weeklyTrigger{
+					{Weekday: time.Saturday, Clock: clock(18, 0)},
+					{Weekday: time.Sunday, Clock: clock(18, 0)},
+				}},
+			now:  time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 18, 18, 0, 0, 0, moscow),
+		},
+		{
+			name: "interval longer than a week",
+			schedule: reminderSchedule{Loc: moscow, IntervalDays: 3,
+				IntervalStart: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+				Triggers: []weeklyTrigger{
+					{Weekday: time.Friday, Clock: clock(18, 0)},
+				}},
+			// fridays on the interval: 23.10 is day 6, 30.10 is day 13, 06.11 is day 20 - not on interval; 13.11 is day 27
+			now:  time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+			want: time.Date(2026, 10, 23, 18, 0, 0, 0, moscow),
+		},
+		{
+			name:     "no triggers",
+			schedule: reminderSchedule{Loc: moscow},
+			now:      time.Date(2026, 10, 17, 10, 0, 0, 0, moscow),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := nextNotification(tt.schedule, tt.now)
+			if tt.want.IsZero() {
+				if ok {
+					t.Fatalf("nextNotification() = %v, want no notification", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("nextNotification() has no notification, want %v", tt.want)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextNotification() = %v, want %v", got.UTC(), tt.want.UTC())
+			}
+		})
+	}
+}
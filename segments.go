@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	om "github.com/wk8/go-ordered-map/v2"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// segmentDateLayout - join dates entered by admin
+	segmentDateLayout = "02.01.2006"
+	// segmentSkip - admin's answer to not filter by parameter
+	segmentSkip = "-"
+
+	allUsersSegment = "all"
+	newSegment      = "new"
+)
+
+// audienceSegment is a filter of broadcast recipients, empty segment is every user.
+//   - JoinedAfter, JoinedBefore - inclusive dates of join_dt, YYYY-MM-DD
+//   - GroupID - purchased warmup group
+//   - ActiveDays - active (> 0) or inactive (< 0) users in the last |ActiveDays| days
+//   - BookedLesson - "yes" or "no" if user has or hasn't booked a lesson ever
+type audienceSegment struct {
+	City         string `json:"city,omitempty"`
+	Timezone     string `json:"timezone,omitempty"`
+	JoinedAfter  string `json:"joined_after,omitempty"`
+	JoinedBefore string `json:"joined_before,omitempty"`
+	GroupID      int    `json:"group_id,omitempty"`
+	ActiveDays   int    `json:"active_days,omitempty"`
+	BookedLesson string `json:"booked_lesson,omitempty"`
+}
+
+// filter returns condition for users table and its arguments
+func (s *audienceSegment) filter() (string, []interface{}) {
+	conds := []string{"user_class = 'USER'", "active = true"}
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "$?", "$"+strconv.Itoa(len(args))))
+	}
+	if s.City != "" {
+		add("lower(city) = lower($?)", s.City)
+	}
+	if s.Timezone != "" {
+		add("timezone = $?", s.Timezone)
+	}
+	if s.JoinedAfter != "" {
+		add("join_dt >= $?::date", s.JoinedAfter)
+	}
+	if s.JoinedBefore != "" {
+		add("join_dt < $?::date + 1", s.JoinedBefore)
+	}
+	if s.GroupID != 0 {
+		add(`EXISTS (SELECT 1 FROM acquired_warmup_groups
+			WHERE acquired_warmup_groups.user_id = users.user_id AND group_id = $?)`, s.GroupID)
+	}
+	if s.ActiveDays > 0 {
+		add("last_active > now() AT TIME ZONE 'UTC' - $? * INTERVAL '1 day'", s.ActiveDays)
+	}
+	if s.ActiveDays < 0 {
+		add("(last_active IS NULL OR last_active <= now() AT TIME ZONE 'UTC' - $? * INTERVAL '1 day')", -s.ActiveDays)
+	}
+	switch s.BookedLesson {
+	case "yes":
+		conds = append(conds, "EXISTS (SELECT 1 FROM lesson_slots WHERE booked_by = users.user_id)")
+	case "no":
+		conds = append(conds, "NOT EXISTS (SELECT 1 FROM lesson_slots WHERE booked_by = users.user_id)")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Text returns human-readable filters of segment
+func (s *audienceSegment) Text() string {
+	var lines []string
+	if s.City != "" {
+		lines = append(lines, "город: "+s.City)
+	}
+	if s.Timezone != "" {
+		lines = append(lines, "часовой пояс: "+s.Timezone)
+	}
+	if s.JoinedAfter != "" || s.JoinedBefore != "" {
+		lines = append(lines, fmt.Sprintf("регистрация: %s - %s", s.JoinedAfter, s.JoinedBefore))
+	}
+	if s.GroupID != 0 {
+		lines = append(lines, fmt.Sprintf("купили пакет распевок #%d", s.GroupID))
+	}
+	if s.ActiveDays > 0 {
+		lines = append(lines, fmt.Sprintf("заходили в бота за %d дн.", s.ActiveDays))
+	}
+	if s.ActiveDays < 0 {
+		lines = append(lines, fmt.Sprintf("не заходили в бота %d дн.", -s.ActiveDays))
+	}
+	switch s.BookedLesson {
+	case "yes":
+		lines = append(lines, "записывались на урок")
+	case "no":
+		lines = append(lines, "не записывались на урок")
+	}
+	if len(lines) == 0 {
+		return "все пользователи"
+	}
+	return strings.Join(lines, ", ")
+}
+
+func audienceSize(s *audienceSegment) (int, error) {
+	if s == nil {
+		s = &audienceSegment{}
+	}
+	cond, args := s.filter()
+	var size int
+	err := DB.QueryRow(context.Background(), `
+		SELECT COUNT(*) FROM users
+		WHERE `+cond, args...).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("audienceSize: %w", err)
+	}
+	return size, nil
+}
+
+// parseJoinRange parses "ДД.ММ.ГГГГ - ДД.ММ.ГГГГ", any side can be empty. Returns dates as YYYY-MM-DD
+func parseJoinRange(text string) (after, before string, err error) {
+	sides := strings.Split(text, "-")
+	if len(sides) != 2 {
+		return "", "", fmt.Errorf("parseJoinRange: no separator")
+	}
+	dates := make([]string, 2)
+	for i, side := range sides {
+		side = strings.TrimSpace(side)
+		if side == "" {
+			continue
+		}
+		dt, err := time.Parse(segmentDateLayout, side)
+		if err != nil {
+			return "", "", fmt.Errorf("parseJoinRange: %w", err)
+		}
+		dates[i] = dt.Format(practiceDateLayout)
+	}
+	if dates[0] == "" && dates[1] == "" {
+		return "", "", fmt.Errorf("parseJoinRange: empty range")
+	}
+	return dates[0], dates[1], nil
+}
+
+// parseActivity parses "активные N" or "неактивные N", returns audienceSegment.ActiveDays
+func parseActivity(text string) (int, error) {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("parseActivity: wrong format")
+	}
+	days, err := strconv.Atoi(fields[1])
+	if err != nil || days <= 0 || days > 365 {
+		return 0, fmt.Errorf("parseActivity: wrong number of days")
+	}
+	switch fields[0] {
+	case "активные":
+		return days, nil
+	case "неактивные":
+		return -days, nil
+	}
+	return 0, fmt.Errorf("parseActivity: wrong format")
+}
+
+func segmentTimezoneValidator(c tele.Context) string {
+	if strings.TrimSpace(c.Text()) == segmentSkip {
+		return ""
+	}
+	if _, ok := findTimezone(c.Text()); !ok {
+		return "Не могу распознать часовой пояс. Напиши город из списка при регистрации, название часового пояса, например Europe/Moscow, или '-'"
+	}
+	return ""
+}
+
+func segmentJoinedValidator(c tele.Context) string {
+	if strings.TrimSpace(c.Text()) == segmentSkip {
+		return ""
+	}
+	if _, _, err := parseJoinRange(c.Text()); err != nil {
+		return "Не могу распознать даты. Формат: 01.01.2026 - 31.03.2026, одну из дат можно не писать, например 01.01.2026 -"
+	}
+	return ""
+}
+
+func segmentGroupValidator(c tele.Context) string {
+	if group, ok := adminFSM.GetStateVar(c.Sender().ID, "segmentGroup"); ok && group != segmentSkip {
+		return ""
+	}
+	if strings.TrimSpace(c.Text()) == segmentSkip {
+		return ""
+	}
+	return "Выбери пакет распевок из списка или напиши '-'"
+}
+
+func segmentActivityValidator(c tele.Context) string {
+	if strings.TrimSpace(c.Text()) == segmentSkip {
+		return ""
+	}
+	if _, err := parseActivity(c.Text()); err != nil {
+		return "Напиши 'активные 30' или 'неактивные 30', число дней от 1 до 365, или '-'"
+	}
+	return ""
+}
+
+func segmentLessonValidator(c tele.Context) string {
+	switch strings.ToLower(strings.TrimSpace(c.Text())) {
+	case "да", "нет", segmentSkip:
+		return ""
+	}
+	return "Напиши 'да', 'нет' или '-'"
+}
+
+// segmentVars - answers of AdminSGSegment* states, segmentSkip means no filter
+var segmentVars = []string{"segmentCity", "segmentTimezone", "segmentJoined", "segmentGroup", "segmentActivity", "segmentLesson"}
+
+// StartSegment clears answers of previous segment and starts segment dialog
+func StartSegment(c tele.Context) {
+	userID := c.Sender().ID
+	for _, varName := range segmentVars {
+		adminFSM.SetStateVar(userID, varName, segmentSkip)
+	}
+	adminFSM.Trigger(c, AdminSGSegmentCity)
+}
+
+// setSegmentVar returns manipulator that saves admin's answer to state var
+func setSegmentVar(varName string) func(c tele.Context) error {
+	return func(c tele.Context) error {
+		adminFSM.SetStateVar(c.Sender().ID, varName, strings.TrimSpace(c.Text()))
+		return nil
+	}
+}
+
+// SetSegmentGroup saves '-' answer, the group itself is saved on warmupGroupAdminMenu click
+func SetSegmentGroup(c tele.Context) error {
+	if strings.TrimSpace(c.Text()) == segmentSkip {
+		adminFSM.SetStateVar(c.Sender().ID, "segmentGroup", segmentSkip)
+	}
+	return nil
+}
+
+// segmentFromVars builds segment from answers of AdminSGSegment* states
+func segmentFromVars(userID int64) (*audienceSegment, error) {
+	var err error
+	s := &audienceSegment{}
+	vars := adminFSM.GetStateVars(userID)
+	for varName, text := range vars {
+		if text == segmentSkip {
+			delete(vars, varName)
+		}
+	}
+	s.City = vars["segmentCity"]
+	if text, ok := vars["segmentTimezone"]; ok {
+		s.Timezone, _ = findTimezone(text)
+	}
+	if text, ok := vars["segmentJoined"]; ok {
+		if s.JoinedAfter, s.JoinedBefore, err = parseJoinRange(text); err != nil {
+			return nil, fmt.Errorf("segmentFromVars: %w", err)
+		}
+	}
+	if text, ok := vars["segmentGroup"]; ok {
+		if s.GroupID, err = strconv.Atoi(text); err != nil {
+			return nil, fmt.Errorf("segmentFromVars: %w", err)
+		}
+	}
+	if text, ok := vars["segmentActivity"]; ok {
+		if s.ActiveDays, err = parseActivity(text); err != nil {
+			return nil, fmt.Errorf("segmentFromVars: %w", err)
+		}
+	}
+	switch strings.ToLower(vars["segmentLesson"]) {
+	case "да":
+		s.BookedLesson = "yes"
+	case "нет":
+		s.BookedLesson = "no"
+	}
+	return s, nil
+}
+
+// SetSegmentLesson is the last filter of segment: audience size is shown before saving
+func SetSegmentLesson(c tele.Context) error {
+	userID := c.Sender().ID
+	if err := setSegmentVar("segmentLesson")(c); err != nil {
+		return err
+	}
+	s, err := segmentFromVars(userID)
+	if err != nil {
+		return fmt.Errorf("SetSegmentLesson: %w", err)
+	}
+	size, err := audienceSize(s)
+	if err != nil {
+		return fmt.Errorf("SetSegmentLesson: %w", err)
+	}
+	return c.Send(fmt.Sprintf("👥 Аудитория (%s): %d чел.", s.Text(), size))
+}
+
+// SaveSegment applies segment to the broadcast from state var selectedBroadcast and saves it if admin named it
+func SaveSegment(c tele.Context) error {
+	userID := c.Sender().ID
+	text := strings.TrimSpace(c.Text())
+	if strings.ToLower(text) == "отмена" {
+		return nil
+	}
+	s, err := segmentFromVars(userID)
+	if err != nil {
+		return fmt.Errorf("SaveSegment: %w", err)
+	}
+	if text != segmentSkip {
+		_, err = DB.Exec(context.Background(), `
+			INSERT INTO audience_segments(name, filters, created_by)
+			VALUES ($1, $2, $3)`, text, s, userID)
+		if err != nil {
+			return fmt.Errorf("SaveSegment: %w", err)
+		}
+	}
+	if err = applySegment(c, s); err != nil {
+		return fmt.Errorf("SaveSegment: %w", err)
+	}
+	return adminInlineMenus.Show(c, broadcastAdminMenu)
+}
+
+// applySegment sets recipients of the broadcast from state var selectedBroadcast, nil segment is every user
+func applySegment(c tele.Context, s *audienceSegment) error {
+	broadcastID, ok := adminFSM.GetStateVar(c.Sender().ID, "selectedBroadcast")
+	if !ok {
+		return fmt.Errorf("applySegment: can't find state var selectedBroadcast")
+	}
+	tag, err := DB.Exec(context.Background(), `
+		UPDATE broadcasts
+		SET segment = $1
+		WHERE broadcast_id = $2 AND status IN ($3, $4)`, s, broadcastID, BroadcastDraft, BroadcastScheduled)
+	if err != nil {
+		return fmt.Errorf("applySegment: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.Send("Рассылка уже отправляется или отменена")
+	}
+	size, err := audienceSize(s)
+	if err != nil {
+		return fmt.Errorf("applySegment: %w", err)
+	}
+	text := "все пользователи"
+	if s != nil {
+		text = s.Text()
+	}
+	return c.Send(fmt.Sprintf("👥 Рассылка #%s получит аудитория (%s): %d чел.", broadcastID, text, size))
+}
+
+// applySavedSegment sets saved segment or every user (allUsersSegment) as recipients of selected broadcast
+func applySavedSegment(c tele.Context, segmentID string) error {
+	if segmentID == allUsersSegment {
+		return applySegment(c, nil)
+	}
+	s := &audienceSegment{}
+	err := DB.QueryRow(context.Background(), `
+		SELECT filters FROM audience_segments
+		WHERE segment_id = $1`, segmentID).Scan(s)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Сегмент не найден")
+	}
+	if err != nil {
+		return fmt.Errorf("applySavedSegment: %w", err)
+	}
+	return applySegment(c, s)
+}
+
+func audienceSegmentsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT segment_id::text, name FROM audience_segments
+		ORDER BY segment_id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("audienceSegmentsFetcher: can't fetch database: %w", err)
+	}
+	defer rows.Close()
+	omap := om.New[string, string]()
+	omap.Set(allUsersSegment, "👥 Все пользователи")
+
+	var segmentID, name string
+	for rows.Next() {
+		if err = rows.Scan(&segmentID, &name); err != nil {
+			return omap, fmt.Errorf("audienceSegmentsFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(segmentID, "📌 "+name)
+	}
+	omap.Set(newSegment, "➕ Новый сегмент")
+	return omap, nil
+}
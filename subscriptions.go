@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	subscriptionReminderList = "subscriptionReminderList"
+
+	// subscriptionGracePeriod - for how long paid warmups are still available after subscription expiration
+	subscriptionGracePeriod = 3 * 24 * time.Hour
+	// subscriptionRemindBefore - how long before expiration the first renewal reminder is sent
+	subscriptionRemindBefore = 3 * 24 * time.Hour
+)
+
+// reminder stages of subscription: nothing sent, sent before expiration, sent on expiration
+const (
+	subscriptionReminderNone = iota
+	subscriptionReminderBefore
+	subscriptionReminderExpired
+)
+
+// hasActiveSubscription reports if user has subscription that is not expired (including grace period)
+func hasActiveSubscription(userID int64) (bool, error) {
+	var active bool
+	err := DB.QueryRow(context.Background(), `
+		SELECT EXISTS(
+			SELECT 1 FROM subscriptions
+			WHERE user_id = $1
+				AND start_dt <= now() AT TIME ZONE 'UTC'
+				AND expire_dt + $2 * INTERVAL '1 second' > now() AT TIME ZONE 'UTC')`,
+		userID, subscriptionGracePeriod.Seconds()).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("hasActiveSubscription: %w", err)
+	}
+	return active, nil
+}
+
+// subscriptionExpiration returns expiration of the latest subscription of user. ok is false if there's none
+func subscriptionExpiration(userID int64) (expire time.Time, ok bool, err error) {
+	var maxExpire *time.Time
+	err = DB.QueryRow(context.Background(), `
+		SELECT MAX(expire_dt) FROM subscriptions
+		WHERE user_id = $1`, userID).Scan(&maxExpire)
+	if err != nil {
+		return expire, false, fmt.Errorf("subscriptionExpiration: %w", err)
+	}
+	if maxExpire == nil {
+		return expire, false, nil
+	}
+	return *maxExpire, true, nil
+}
+
+// subscriptionCheckoutValidator returns "" if checkout can be accepted, otherwise - it is an error message for user
+func subscriptionCheckoutValidator(userID int64, planID string, checkout *tele.PreCheckoutQuery) string {
+	var active bool
+	var dbPrice int
+	err := DB.QueryRow(context.Background(), `
+		SELECT price*100, active FROM subscription_plans
+		WHERE plan_id = $1`, planID).Scan(&dbPrice, &active)
+	if err != nil {
+		logger.Error("can't find subscription plan in db", zap.Int64("userID", userID), zap.String("planID", planID),
+			zap.String("checkoutID", checkout.ID), zap.Error(err))
+		return PaymentErrorText
+	}
+	if !active {
+		return "Этот тариф больше недоступен, выбери другой в меню Подписка"
+	}
+	if (dbPrice != checkout.Total) || (checkout.Currency != PaymentCurrency) {
+		logger.Error("price doesn't match", zap.Int64("userID", userID), zap.String("planID", planID),
+			zap.String("checkoutID", checkout.ID), zap.String("payload", checkout.Payload),
+			zap.Int("dbPrice", dbPrice), zap.Int("checkout.Total", checkout.Total),
+			zap.String("checkout.Currency", checkout.Currency),
+		)
+		return "Цена изменилась, попробуй оформить подписку еще раз!"
+	}
+	return ""
+}
+
+// acquireSubscription saves paid subscription. If user already has subscription, new one starts when the old one expires.
+// stored is false if nothing was saved
+func acquireSubscription(c tele.Context, payment *tele.Payment, planID string) (stored bool, err error) {
+	userID := c.Sender().ID
+	chargeID := payment.TelegramChargeID
+	priceWhenAcquired := strconv.Itoa(payment.Total) + payment.Currency
+
+	var planName string
+	var expire time.Time
+	err = DB.QueryRow(context.Background(), `
+		WITH start AS (
+			SELECT GREATEST(
+				now() AT TIME ZONE 'UTC',
+				(SELECT MAX(expire_dt) FROM subscriptions WHERE user_id = $1)) AS start_dt
+		)
+		INSERT INTO subscriptions(user_id, plan_id, checkout_id, price_when_acquired, start_dt, expire_dt)
+		SELECT $1, plan_id, $3, $4, start_dt, start_dt + period_days * INTERVAL '1 day'
+		FROM subscription_plans, start
+		WHERE plan_id = $2
+		ON CONFLICT (checkout_id) DO NOTHING
+		RETURNING expire_dt, (SELECT plan_name FROM subscription_plans WHERE plan_id = $2)`,
+		userID, planID, chargeID, priceWhenAcquired).Scan(&expire, &planName)
+	if err == pgx.ErrNoRows {
+		logger.Warn("duplicate payment", zap.Int64("userID", userID), zap.String("chargeID", chargeID))
+		return false, nil
+	}
+	if err != nil {
+		logger.Error("exec db error", zap.Int64("userID", userID), zap.String("planID", planID),
+			zap.String("chargeID", chargeID), zap.String("providerChargeID", payment.ProviderChargeID), zap.Error(err))
+		return false, c.Send(PaymentLostText)
+	}
+
+	logger.Info("successful payment", zap.Int64("userID", userID), zap.String("planID", planID),
+		zap.String("price", priceWhenAcquired), zap.String("chargeID", chargeID),
+		zap.String("providerChargeID", payment.ProviderChargeID))
+
+	// reminders are scheduled for the latest subscription, so the old reminder is not relevant anymore
+	if err = subscriptionReminderService.DelUser(userID); err != nil {
+		logger.Error("can't reschedule subscription reminder", zap.Int64("userID", userID), zap.Error(err))
+	}
+	if err = subscriptionReminderService.AddUser(userID); err != nil {
+		logger.Error("can't reschedule subscription reminder", zap.Int64("userID", userID), zap.Error(err))
+	}
+
+	return true, c.Send(paymentReceipt("Подписка: "+planName, payment, time.Now()) +
+		"\n\nПодписка оформлена! Все платные пакеты распевок доступны до " + expire.Format("02.01.2006"))
+}
+
+func processSubscriptionPlan(c tele.Context, planID string) error {
+	var (
+		planName   string
+		periodDays int
+		price      int
+	)
+	err := DB.QueryRow(context.Background(), `
+		SELECT plan_name, period_days, price FROM subscription_plans
+		WHERE plan_id = $1 AND active = true`, planID).Scan(&planName, &periodDays, &price)
+	if err == pgx.ErrNoRows {
+		return c.Send("Этот тариф больше недоступен")
+	}
+	if err != nil {
+		return fmt.Errorf("processSubscriptionPlan: can't select row: %w", err)
+	}
+
+	invoice := &tele.Invoice{
+		Title:       "Подписка на распевки",
+		Description: fmt.Sprintf("Подписка '%s': доступ ко всем платным пакетам распевок на %d дн.", planName, periodDays),
+		Payload:     SubscriptionPayloadChecker + PayloadSplit + planID,
+		Currency:    PaymentCurrency,
+		Prices: []tele.Price{
+			{
+				Label:  PaymentCurrency,
+				Amount: price * 100,
+			},
+		},
+		Token: ProviderToken,
+	}
+	return c.Send(invoice)
+}
+
+// sendSubscriptionStatus tells user about current subscription before showing subscription plans
+func sendSubscriptionStatus(c tele.Context) error {
+	expire, ok, err := subscriptionExpiration(c.Sender().ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	switch {
+	case !ok:
+		return c.Send("Подписка открывает доступ ко всем платным пакетам распевок, пока она действует. Выбери тариф:")
+	case expire.After(now):
+		return c.Send("Твоя подписка действует до " + expire.Format("02.01.2006") + ". Продлить можно заранее - новый период начнется после окончания текущего:")
+	case expire.Add(subscriptionGracePeriod).After(now):
+		return c.Send("Твоя подписка закончилась " + expire.Format("02.01.2006") +
+			", но доступ к распевкам сохранится еще до " + expire.Add(subscriptionGracePeriod).Format("02.01.2006") + ". Продлить:")
+	}
+	return c.Send("Твоя подписка закончилась " + expire.Format("02.01.2006") + ". Продлить:")
+}
+
+// subscriptionReminderHandler is a job function for subscriptionReminderService
+func subscriptionReminderHandler(b *tele.Bot, userID int64) error {
+	var subscriptionID, stage int
+	var expire time.Time
+	err := DB.QueryRow(context.Background(), `
+		SELECT subscription_id, reminder_stage, expire_dt FROM subscriptions
+		WHERE user_id = $1
+		ORDER BY expire_dt DESC
+		LIMIT 1`, userID).Scan(&subscriptionID, &stage, &expire)
+	if err != nil {
+		return fmt.Errorf("subscriptionReminderHandler: %w", err)
+	}
+
+	var text string
+	switch stage {
+	case subscriptionReminderNone:
+		text = "Твоя подписка на распевки заканчивается " + expire.Format("02.01.2006") +
+			". Продлить ее можно в меню 'Подписка' 🤍"
+	case subscriptionReminderBefore:
+		text = "Твоя подписка на распевки закончилась. Платные пакеты распевок будут доступны еще до " +
+			expire.Add(subscriptionGracePeriod).Format("02.01.2006") + ", продлить подписку можно в меню 'Подписка' 🤍"
+	default:
+		return nil
+	}
+	if _, err = b.Send(UserIDType{userID}, text); err != nil {
+		return fmt.Errorf("subscriptionReminderHandler: %w", err)
+	}
+
+	_, err = DB.Exec(context.Background(), `
+		UPDATE subscriptions
+		SET reminder_stage = reminder_stage + 1
+		WHERE subscription_id = $1`, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("subscriptionReminderHandler: %w", err)
+	}
+	return nil
+}
+
+func getSubscriptionReminderFromPg(userID int64) (timestamp int64, err error) {
+	reminders, err := fetchSubscriptionReminders(userID)
+	if err != nil {
+		return 0, err
+	}
+	return reminders[userID], nil
+}
+
+func getSubscriptionRemindersFromPg() (results notificationQuery, err error) {
+	return fetchSubscriptionReminders(0)
+}
+
+// fetchSubscriptionReminders returns next reminder for the latest subscription of every user (userID = 0)
+// or of specific user
+func fetchSubscriptionReminders(userID int64) (results notificationQuery, err error) {
+	results = make(notificationQuery)
+	rows, err := DB.Query(context.Background(), `
+	SELECT user_id, EXTRACT(EPOCH FROM
+		CASE reminder_stage
+			WHEN $2 THEN expire_dt - $4 * INTERVAL '1 second'
+			ELSE expire_dt
+		END) :: INT8
+	FROM (
+		SELECT DISTINCT ON (user_id) user_id, expire_dt, reminder_stage
+		FROM subscriptions
+		INNER JOIN users USING (user_id)
+		WHERE users.active = true AND (($1 = 0) OR (user_id = $1))
+		ORDER BY user_id, expire_dt DESC
+	) latest_subscriptions
+	WHERE reminder_stage < $3`,
+		userID, subscriptionReminderNone, subscriptionReminderExpired, subscriptionRemindBefore.Seconds())
+	if err != nil {
+		return results, fmt.Errorf("fetchSubscriptionReminders: %w", err)
+	}
+	defer rows.Close()
+
+	var user, timestamp int64
+	for rows.Next() {
+		if err = rows.Scan(&user, &timestamp); err != nil {
+			return results, fmt.Errorf("fetchSubscriptionReminders: scan row: %w", err)
+		}
+		results[user] = timestamp
+	}
+	if err = rows.Err(); err != nil {
+		return results, fmt.Errorf("fetchSubscriptionReminders: postgres itetator %w", err)
+	}
+	return results, nil
+}
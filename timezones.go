@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/latlong"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// timezoneCity is a city user can pick to set IANA timezone
+type timezoneCity struct {
+	City string
+	Zone string
+}
+
+var timezoneCities = []timezoneCity{
+	{"Калининград", "Europe/Kaliningrad"},
+	{"Москва", "Europe/Moscow"},
+	{"Санкт-Петербург", "Europe/Moscow"},
+	{"Самара", "Europe/Samara"},
+	{"Екатеринбург", "Asia/Yekaterinburg"},
+	{"Омск", "Asia/Omsk"},
+	{"Новосибирск", "Asia/Novosibirsk"},
+	{"Красноярск", "Asia/Krasnoyarsk"},
+	{"Иркутск", "Asia/Irkutsk"},
+	{"Владивосток", "Asia/Vladivostok"},
+	{"Минск", "Europe/Minsk"},
+	{"Киев", "Europe/Kyiv"},
+	{"Тбилиси", "Asia/Tbilisi"},
+	{"Ереван", "Asia/Yerevan"},
+	{"Алматы", "Asia/Almaty"},
+	{"Ташкент", "Asia/Tashkent"},
+	{"Стамбул", "Europe/Istanbul"},
+	{"Берлин", "Europe/Berlin"},
+	{"Лондон", "Europe/London"},
+	{"Нью-Йорк", "America/New_York"},
+}
+
+const shareLocationButton = "📍 Отправить местоположение"
+
+var timezoneMenu = timezoneMenuConstructor()
+
+// timezoneMenuConstructor creates reply menu with location button and cities from timezoneCities
+func timezoneMenuConstructor() *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{
+		ResizeKeyboard:  true,
+		OneTimeKeyboard: true,
+	}
+	rows := []tele.Row{menu.Row(menu.Location(shareLocationButton))}
+	var buttons []tele.Btn
+	for _, tc := range timezoneCities {
+		buttons = append(buttons, menu.Text(tc.City))
+		if len(buttons) == 3 {
+			rows = append(rows, menu.Row(buttons...))
+			buttons = nil
+		}
+	}
+	if len(buttons) != 0 {
+		rows = append(rows, menu.Row(buttons...))
+	}
+	menu.Reply(rows...)
+	return menu
+}
+
+// locationTimezone resolves coordinates to IANA timezone with embedded timezone boundaries, no network calls
+func locationTimezone(location *tele.Location) (zone string, ok bool) {
+	zone = latlong.LookupZoneName(float64(location.Lat), float64(location.Lng))
+	if zone == "" {
+		return "", false
+	}
+	// dataset can be older than timezone database
+	if _, err := time.LoadLocation(zone); err != nil {
+		return "", false
+	}
+	return zone, true
+}
+
+// findTimezone resolves user's answer to IANA timezone: city from timezoneCities or IANA name, e.g. Asia/Kathmandu
+func findTimezone(text string) (zone string, ok bool) {
+	text = strings.TrimSpace(text)
+	for _, tc := range timezoneCities {
+		if strings.EqualFold(tc.City, text) {
+			return tc.Zone, true
+		}
+	}
+	if !strings.Contains(text, "/") {
+		return "", false
+	}
+	loc, err := time.LoadLocation(text)
+	if err != nil {
+		return "", false
+	}
+	return loc.String(), true
+}
+
+// formatUTCOffset formats offset in seconds as UTC+03:00
+func formatUTCOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("UTC%c%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+// userLocation returns user's timezone. Users who haven't chosen IANA timezone yet have fixed offset timezone_raw
+func userLocation(userID int64) (*time.Location, error) {
+	var zone *string
+	var tzRaw int
+	err := DB.QueryRow(context.Background(), `
+		SELECT timezone, COALESCE(timezone_raw, 0) FROM users
+		WHERE user_id = $1`, userID).Scan(&zone, &tzRaw)
+	if err != nil {
+		return nil, fmt.Errorf("userLocation: %w", err)
+	}
+	loc, err := zoneLocation(zone, tzRaw)
+	if err != nil {
+		logger.Error("userLocation: can't load timezone, fixed offset is used", zap.Int64("userID", userID), zap.Error(err))
+	}
+	return loc, nil
+}
+
+// zoneLocation returns IANA timezone or fixed offset timezone if zone is nil.
+// If zone can't be loaded, fixed offset timezone is returned with error
+func zoneLocation(zone *string, tzRaw int) (*time.Location, error) {
+	fixed := time.FixedZone(formatUTCOffset(tzRaw*60), tzRaw*60)
+	if zone == nil {
+		return fixed, nil
+	}
+	loc, err := time.LoadLocation(*zone)
+	if err != nil {
+		return fixed, fmt.Errorf("zoneLocation: %w", err)
+	}
+	return loc, nil
+}
+
+// answerTimezone resolves user's answer to IANA timezone: shared location, city from timezoneCities or IANA name
+func answerTimezone(c tele.Context) (zone string, ok bool) {
+	if location := c.Message().Location; location != nil {
+		return locationTimezone(location)
+	}
+	return findTimezone(c.Text())
+}
+
+// timezoneValidator accepts shared location, city from the list, IANA timezone name or current time of user
+func timezoneValidator(c tele.Context) string {
+	if _, ok := answerTimezone(c); ok {
+		return ""
+	}
+	if c.Message().Location != nil {
+		return "Не получилось определить часовой пояс по местоположению... Выбери город из списка " +
+			"или напиши, сколько сейчас времени по твоим часам в формате ЧЧ:ММ, например, 20:55"
+	}
+	if errStr := timeValidator(c); errStr != "" {
+		return "Не могу распознать ответ. Выбери город из списка, напиши название часового пояса, например Asia/Kathmandu, " +
+			"или напиши, сколько сейчас времени по твоим часам в формате ЧЧ:ММ, например, 20:55"
+	}
+	return ""
+}
+
+// resolveTimezone returns values for users.timezone, users.timezone_raw and users.timezone_txt.
+// zone is nil if user has entered current time: only fixed offset is known then
+func resolveTimezone(c tele.Context) (zone *string, tzRaw int, tzTxt string, err error) {
+	if z, ok := answerTimezone(c); ok {
+		loc, err := time.LoadLocation(z)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("resolveTimezone: %w", err)
+		}
+		_, offset := time.Now().In(loc).Zone()
+		return &z, offset / 60, z, nil
+	}
+
+	userHoursMinutes := strings.Split(c.Text(), ":")
+	userHours, _ := strconv.Atoi(userHoursMinutes[0])
+	userMinutes, _ := strconv.Atoi(userHoursMinutes[1])
+	utcTimezone, utcMinutesShift, err := calcTimezoneByTimeShift(userHours, userMinutes)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("resolveTimezone: %w", err)
+	}
+	tzRaw, err = strconv.Atoi(utcMinutesShift)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("resolveTimezone: %w", err)
+	}
+	return nil, tzRaw, utcTimezone, nil
+}
+
+// timezoneSavedText tells user which timezone is saved
+func timezoneSavedText(zone *string, tzTxt string) string {
+	if zone == nil {
+		return fmt.Sprintf("Получается, твой часовой пояс - %s. Если у тебя переводят часы на летнее время, "+
+			"лучше выбрать город в настройках аккаунта - тогда напоминания не собьются", tzTxt)
+	}
+	return fmt.Sprintf("Твой часовой пояс - %s", tzTxt)
+}
+
+// timezonesMigration - name of migrateTimezones in migrations table
+const timezonesMigration = "timezones"
+
+// migrateTimezones sets IANA timezone for users registered before timezone column existed,
+// if their city is in timezoneCities and current offset of the city matches saved offset.
+// Other users keep fixed offset until they choose timezone in account settings.
+// It runs once by runMigration, later users always get timezone on registration
+func migrateTimezones(tx pgx.Tx) error {
+	ctx := context.Background()
+	var migrated int64
+	for _, tc := range timezoneCities {
+		loc, err := time.LoadLocation(tc.Zone)
+		if err != nil {
+			return fmt.Errorf("migrateTimezones: %w", err)
+		}
+		_, offset := time.Now().In(loc).Zone()
+		tag, err := tx.Exec(ctx, `
+			UPDATE users
+			SET timezone = $1, timezone_txt = $1
+			WHERE timezone IS NULL AND lower(city) = lower($2) AND timezone_raw = $3`, tc.Zone, tc.City, offset/60)
+		if err != nil {
+			return fmt.Errorf("migrateTimezones: %w", err)
+		}
+		migrated += tag.RowsAffected()
+	}
+
+	var unmigrated int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM users
+		WHERE timezone IS NULL`).Scan(&unmigrated)
+	if err != nil {
+		return fmt.Errorf("migrateTimezones: %w", err)
+	}
+	logger.Info("timezones are migrated", zap.Int64("migrated", migrated),
+		zap.Int("unmigrated", unmigrated))
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimezoneCitiesLoad(t *testing.T) {
+	for _, tc := range timezoneCities {
+		if _, err := time.LoadLocation(tc.Zone); err != nil {
+			t.Errorf("%s: %v", tc.City, err)
+		}
+	}
+}
@@ -3,26 +3,37 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"vocal_training_bot/BotExt"
 
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 	tele "gopkg.in/telebot.v3"
 )
 
 var (
 	userInlineMenus = BotExt.NewInlineMenus()
-	userFSM         = BotExt.NewFiniteStateMachine(userInlineMenus)
+	userFSM         *BotExt.FSM
 )
 
 const (
-	WarmupPayloadChecker = "BuyWarmupGroup"
-	PayloadSplit         = "|"
-	PaymentErrorText     = "Произошла ошибка при проведении платежа!"
+	WarmupPayloadChecker          = "BuyWarmupGroup"
+	SubscriptionPayloadChecker    = "BuySubscription"
+	GiftCertificatePayloadChecker = "BuyGiftCertificate"
+	LessonPackagePayloadChecker   = "BuyLessonPackage"
+	PayloadSplit                  = "|"
+	PaymentErrorText              = "Произошла ошибка при проведении платежа!"
+	PaymentLostText               = PaymentErrorText + " Деньги списаны, но покупка не сохранилась - напиши @vershkovaaa, мы все исправим."
+	PaymentDuplicateText          = "Эта покупка уже была оплачена раньше! Деньги за повторный платеж вернем, вопросы - @vershkovaaa."
+	PaymentCurrency               = "RUB"
+	WarmupAcquiredText            = "Этот пакет распевок уже куплен!"
 )
 
-func setupUserHandlers(b *tele.Bot) {
+func setupUserHandlers(b *tele.Bot, store BotExt.StateStore) {
+	userFSM = BotExt.NewFiniteStateMachine(userInlineMenus, store)
 	SetupUserStates(userFSM)
 	SetupUserMenuHandlers(b)
 }
@@ -35,7 +46,7 @@ func OnUserInlineResult(c tele.Context) error {
 
 	switch triggeredItem {
 	case WarmupGroupsMenu:
-		BotExt.SetStateVar(c.Sender().ID, "selectedWarmupGroup", triggeredID)
+		userFSM.SetStateVar(c.Sender().ID, "selectedWarmupGroup", triggeredID)
 		err := processWarmupGroup(c, triggeredID)
 		if err != nil {
 			logger.Error("OnUserInlineResult: WarmupGroupsMenu", zap.Error(err))
@@ -45,6 +56,54 @@ func OnUserInlineResult(c tele.Context) error {
 		if err != nil {
 			logger.Error("OnUserInlineResult: WarmupsMenu", zap.Error(err))
 		}
+	case SubscriptionPlansMenu:
+		err := processSubscriptionPlan(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: SubscriptionPlansMenu", zap.Error(err))
+		}
+	case LessonSlotsMenu:
+		if triggeredID == lessonRequestButton {
+			userFSM.Trigger(c, WannabeStudentSGSendReq)
+			break
+		}
+		userFSM.SetStateVar(c.Sender().ID, "selectedSlot", triggeredID)
+		err := userInlineMenus.Show(c, LessonBookingMenu)
+		if err != nil {
+			logger.Error("OnUserInlineResult: LessonSlotsMenu", zap.Error(err))
+		}
+	case LessonPackagesMenu:
+		err := processLessonPackage(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: LessonPackagesMenu", zap.Error(err))
+		}
+	case AchievementsMenu:
+		err := sendAchievement(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: AchievementsMenu", zap.Error(err))
+		}
+	case WarmupReminderMessage:
+		err := processReminderAnswer(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: WarmupReminderMessage", zap.Error(err))
+		}
+	case WarmupDayTimesMenu:
+		if triggeredID == addReminderTimeButton {
+			userFSM.Trigger(c, NotificationSGSetTime, WarmupDayTimesMenu)
+			break
+		}
+		err := removeReminderTime(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: WarmupDayTimesMenu", zap.Error(err))
+		}
+	case GiftCertificatesMenu:
+		if triggeredID == redeemGiftCertificateButton {
+			userFSM.Trigger(c, GiftCertificateSGRedeem)
+			break
+		}
+		err := processGiftCertificateType(c, triggeredID)
+		if err != nil {
+			logger.Error("OnUserInlineResult: GiftCertificatesMenu", zap.Error(err))
+		}
 	}
 	return c.Respond()
 }
@@ -58,64 +117,228 @@ func onUnregisteredStart(c tele.Context) error {
 	return nil
 }
 
-/*
+// onUserCheckout answers pre-checkout query. Nothing is written here: access is granted only in onUserPayment,
+// after telegram confirms the charge
 func onUserCheckout(c tele.Context) error {
 	checkout := c.PreCheckoutQuery()
 
 	userID := c.Sender().ID
 	checkoutID := checkout.ID
 
-	payloadData := strings.Split(checkout.Payload, PayloadSplit)
-	if len(payloadData) != 2 {
+	payloadChecker, itemID, code, err := parsePayload(checkout.Payload)
+	if err != nil {
 		logger.Error("can't extract payloadData", zap.Int64("userID", userID),
-			zap.String("checkoutID", checkoutID), zap.Strings("payload", payloadData))
+			zap.String("checkoutID", checkoutID), zap.String("payload", checkout.Payload), zap.Error(err))
 		return c.Bot().Accept(checkout, PaymentErrorText)
 	}
-	if payloadData[0] != WarmupPayloadChecker {
-		logger.Error("unknown payloadChecker", zap.Int64("userID", userID),
-			zap.String("checkoutID", checkoutID), zap.Strings("payload", payloadData))
-		return c.Bot().Accept(checkout, PaymentErrorText)
+
+	var errText string
+	switch payloadChecker {
+	case WarmupPayloadChecker:
+		errText = warmupCheckoutValidator(userID, itemID, code, checkout)
+	case SubscriptionPayloadChecker:
+		errText = subscriptionCheckoutValidator(userID, itemID, checkout)
+	case GiftCertificatePayloadChecker:
+		errText = giftCertificateCheckoutValidator(userID, itemID, checkout)
+	case LessonPackagePayloadChecker:
+		errText = lessonPackageCheckoutValidator(userID, itemID, checkout)
+	}
+	if errText != "" {
+		return c.Bot().Accept(checkout, errText)
 	}
-	warmupGroupID := payloadData[1]
-	priceWhenAcquired := strconv.Itoa(checkout.Total) + checkout.Currency
+	return c.Bot().Accept(checkout)
+}
 
-	var warmupGroupName string
-	var dbPrice int
-	err := DB.QueryRow(context.Background(), `
-		SELECT group_name, price*100 FROM warmup_groups
-		WHERE warmup_group_id = $1`, warmupGroupID).Scan(&warmupGroupName, &dbPrice)
+// onUserPayment processes successful payment message. Telegram charge id is used as checkout_id,
+// so repeated delivery of the same payment doesn't create duplicates
+func onUserPayment(c tele.Context) error {
+	payment := c.Message().Payment
+
+	payloadChecker, itemID, code, err := parsePayload(payment.Payload)
+	if err != nil {
+		return fmt.Errorf("onUserPayment[charge %s]: %w", payment.TelegramChargeID, err)
+	}
+
+	// stored is false for repeated, duplicate and lost payments: there is no new purchase then
+	var stored bool
+	switch payloadChecker {
+	case WarmupPayloadChecker:
+		stored, err = acquireWarmupGroup(c, payment, itemID, code)
+	case SubscriptionPayloadChecker:
+		stored, err = acquireSubscription(c, payment, itemID)
+	case GiftCertificatePayloadChecker:
+		stored, err = acquireGiftCertificate(c, payment, itemID)
+	case LessonPackagePayloadChecker:
+		stored, err = acquireLessonPackage(c, payment, itemID)
+	default:
+		return nil
+	}
+	if stored {
+		publishAchievementEvent(c.Bot(), achievementEvent{Type: EventPurchase, UserID: c.Sender().ID})
+	}
+	return err
+}
+
+// warmupGroupAcquired returns true if user has warmup group already
+func warmupGroupAcquired(userID int64, warmupGroupID string) (acquired bool, err error) {
+	err = DB.QueryRow(context.Background(), `
+		SELECT EXISTS(
+			SELECT 1 FROM acquired_warmup_groups
+			WHERE user_id = $1 AND group_id = $2)`, userID, warmupGroupID).Scan(&acquired)
+	if err != nil {
+		return false, fmt.Errorf("warmupGroupAcquired: %w", err)
+	}
+	return acquired, nil
+}
+
+// warmupCheckoutValidator returns "" if checkout can be accepted, otherwise - it is an error message for user
+func warmupCheckoutValidator(userID int64, warmupGroupID string, code string, checkout *tele.PreCheckoutQuery) string {
+	acquired, err := warmupGroupAcquired(userID, warmupGroupID)
+	if err != nil {
+		logger.Error("can't check acquired warmup in db", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
+			zap.String("checkoutID", checkout.ID), zap.Error(err))
+		return PaymentErrorText
+	}
+
+	if acquired {
+		logger.Warn("warmup group already acquired", zap.Int64("userID", userID),
+			zap.String("warmupGroupID", warmupGroupID), zap.String("checkoutID", checkout.ID))
+		return WarmupAcquiredText
+	}
+
+	_, amount, promo, err := warmupGroupAmount(warmupGroupID, code)
 	if err != nil {
 		logger.Error("can't find warmup in db", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
-			zap.String("checkoutID", checkoutID))
-		return c.Bot().Accept(checkout, PaymentErrorText)
+			zap.String("checkoutID", checkout.ID), zap.Error(err))
+		return PaymentErrorText
+	}
+	if (code != "") && (promo == nil) {
+		return "Промокод больше не действует, попробуй купить пакет распевок еще раз!"
 	}
 
-	if dbPrice != checkout.Total {
+	if (amount != checkout.Total) || (checkout.Currency != PaymentCurrency) {
 		logger.Error("price doesn't match", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
-			zap.String("checkoutID", checkoutID), zap.Strings("payload", payloadData),
-			zap.Int("dbPrice", dbPrice), zap.Int("checkout.Total", checkout.Total),
+			zap.String("checkoutID", checkout.ID), zap.String("payload", checkout.Payload),
+			zap.Int("dbPrice", amount), zap.Int("checkout.Total", checkout.Total),
+			zap.String("checkout.Currency", checkout.Currency),
 		)
-		return c.Bot().Accept(checkout, PaymentErrorText)
+		return "Цена изменилась, попробуй купить пакет распевок еще раз!"
+	}
+	return ""
+}
+
+// acquireWarmupGroup gives access to paid warmup group. stored is false if nothing was saved
+func acquireWarmupGroup(c tele.Context, payment *tele.Payment, warmupGroupID string, code string) (stored bool, err error) {
+	userID := c.Sender().ID
+	chargeID := payment.TelegramChargeID
+	price := strconv.Itoa(payment.Total) + payment.Currency
+
+	// promo code could expire after checkout, but the payment is done - save the discount as it was paid
+	var promoCodeUsed *string
+	if code != "" {
+		promoCodeUsed = &code
+		if promo, err := getPromoCode(code); err == nil {
+			fullAmount, _, _, _ := warmupGroupAmount(warmupGroupID, "")
+			price = priceWhenAcquired(payment.Total, payment.Currency, fullAmount, promo)
+		}
 	}
 
-	_, err = DB.Exec(context.Background(), `
-		INSERT INTO acquired_warmup_groups(user_id, group_id, checkout_id, price_when_acquired)
-		VALUES ($1, $2, $3, $4)`, userID, warmupGroupID, checkoutID, priceWhenAcquired)
+	tag, err := DB.Exec(context.Background(), `
+		INSERT INTO acquired_warmup_groups(user_id, group_id, checkout_id, price_when_acquired, promo_code)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`, userID, warmupGroupID, chargeID, price, promoCodeUsed)
 	if err != nil {
 		logger.Error("exec db error", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
-			zap.String("checkoutID", checkoutID), zap.Error(err))
-		return c.Bot().Accept(checkout, PaymentErrorText)
+			zap.String("chargeID", chargeID), zap.String("providerChargeID", payment.ProviderChargeID), zap.Error(err))
+		return false, c.Send(PaymentLostText)
+	}
+	if tag.RowsAffected() == 0 {
+		// either the same payment is delivered again, or the group was acquired by another (concurrent) payment
+		var redelivered bool
+		err = DB.QueryRow(context.Background(), `
+			SELECT EXISTS(
+				SELECT 1 FROM acquired_warmup_groups
+				WHERE checkout_id = $1)`, chargeID).Scan(&redelivered)
+		if err != nil {
+			logger.Error("can't check acquired warmup in db", zap.Int64("userID", userID),
+				zap.String("warmupGroupID", warmupGroupID), zap.String("chargeID", chargeID), zap.Error(err))
+			return false, c.Send(PaymentLostText)
+		}
+		if redelivered {
+			logger.Warn("duplicate payment", zap.Int64("userID", userID), zap.String("chargeID", chargeID))
+			return false, nil
+		}
+		return false, recordDuplicatePayment(c, payment, price)
+	}
+
+	var warmupGroupName string
+	err = DB.QueryRow(context.Background(), `
+		SELECT group_name FROM warmup_groups
+		WHERE warmup_group_id = $1`, warmupGroupID).Scan(&warmupGroupName)
+	if err != nil {
+		logger.Error("can't find warmup in db", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
+			zap.String("chargeID", chargeID), zap.Error(err))
 	}
 
-	_ = c.Send("Пакет распевок '" + warmupGroupName + "' преобретен! Теперь он доступен для просмотра в меню Распевки")
 	logger.Info("successful payment", zap.Int64("userID", userID), zap.String("warmupGroupID", warmupGroupID),
-		zap.String("price", priceWhenAcquired))
-	return c.Bot().Accept(checkout)
+		zap.String("price", price), zap.String("chargeID", chargeID),
+		zap.String("providerChargeID", payment.ProviderChargeID))
+
+	return true, c.Send(paymentReceipt("Пакет распевок: "+warmupGroupName, payment, time.Now()) +
+		"\n\nПакет распевок '" + warmupGroupName + "' приобретен! Теперь он доступен для просмотра в меню Упражнения")
+}
+
+// recordDuplicatePayment saves payment for already acquired item and asks admins to refund it
+func recordDuplicatePayment(c tele.Context, payment *tele.Payment, price string) error {
+	userID := c.Sender().ID
+	logger.Error("payment for already acquired item", zap.Int64("userID", userID), zap.String("payload", payment.Payload),
+		zap.String("chargeID", payment.TelegramChargeID), zap.String("providerChargeID", payment.ProviderChargeID))
+
+	_, err := DB.Exec(context.Background(), `
+		INSERT INTO duplicate_payments(checkout_id, provider_charge_id, user_id, payload, price)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`, payment.TelegramChargeID, payment.ProviderChargeID, userID, payment.Payload, price)
+	if err != nil {
+		logger.Error("can't save duplicate payment", zap.Int64("userID", userID),
+			zap.String("chargeID", payment.TelegramChargeID), zap.Error(err))
+	}
+
+	notifyAdmins(c.Bot(), fmt.Sprintf("⚠️ Повторная оплата уже купленного товара (%s), нужен возврат %d.%02d %s. "+
+		"Покупатель: %s [ID%d], платеж: %s, платеж провайдера: %s",
+		payment.Payload, payment.Total/100, payment.Total%100, payment.Currency,
+		userMention(c.Sender()), userID, payment.TelegramChargeID, payment.ProviderChargeID))
+	return c.Send(PaymentDuplicateText)
+}
+
+// parsePayload splits invoice payload into payload checker (type of the product), item id and optional promo code
+func parsePayload(payload string) (payloadChecker string, itemID string, code string, err error) {
+	payloadData := strings.Split(payload, PayloadSplit)
+	if (len(payloadData) != 2) && (len(payloadData) != 3) {
+		return "", "", "", fmt.Errorf("parsePayload: wrong payload '%s'", payload)
+	}
+	if len(payloadData) == 3 {
+		code = payloadData[2]
+	}
+	switch payloadData[0] {
+	case WarmupPayloadChecker, SubscriptionPayloadChecker, GiftCertificatePayloadChecker, LessonPackagePayloadChecker:
+		return payloadData[0], payloadData[1], code, nil
+	}
+	return "", "", "", fmt.Errorf("parsePayload: unknown payloadChecker '%s'", payloadData[0])
+}
+
+func paymentReceipt(item string, payment *tele.Payment, dt time.Time) string {
+	return fmt.Sprintf(`🧾 Чек об оплате
+
+%s
+Сумма: %d.%02d %s
+Номер платежа: %s
+Дата: %s`,
+		item, payment.Total/100, payment.Total%100, payment.Currency,
+		payment.TelegramChargeID, dt.UTC().Format("02.01.2006 15:04 UTC"))
 }
-*/
 
 func onUnregisteredText(c tele.Context) error {
-	if ok := BotExt.HasState(c.Sender().ID); ok {
+	if ok := userFSM.HasState(c.Sender().ID); ok {
 		userFSM.Update(c)
 		return nil
 	}
@@ -139,7 +362,7 @@ func onUnregisteredText(c tele.Context) error {
 func onUserText(c tele.Context) error {
 	userID := c.Sender().ID
 
-	if ok := BotExt.HasState(userID); ok {
+	if ok := userFSM.HasState(userID); ok {
 		userFSM.Update(c)
 		return nil
 	}
@@ -161,9 +384,23 @@ func onUserText(c tele.Context) error {
 		return userInlineMenus.Show(c, WarmupGroupsMenu)
 	case "Напоминания":
 		return userInlineMenus.Show(c, WarmupNotificationsMenu)
+	case "Мой прогресс":
+		if err := sendProgress(c); err != nil {
+			return err
+		}
+		return userInlineMenus.Show(c, AchievementsMenu)
+	case "Подписка":
+		if err := sendSubscriptionStatus(c); err != nil {
+			return err
+		}
+		return userInlineMenus.Show(c, SubscriptionPlansMenu)
+	case "Подарочный сертификат":
+		return userInlineMenus.Show(c, GiftCertificatesMenu)
 	case "Записаться на урок":
-		userFSM.Trigger(c, WannabeStudentSGSendReq)
-		return nil
+		if err := sendUpcomingLessons(c); err != nil {
+			return err
+		}
+		return userInlineMenus.Show(c, LessonSlotsMenu)
 	case "Обо мне":
 		return sendAboutMe(c)
 	case "Настройки аккаунта":
@@ -207,7 +444,12 @@ func processWarmupGroup(c tele.Context, warmupGroupID string) error {
 		return fmt.Errorf("processWarmupGroup: can't select row: %w", err)
 	}
 
-	if (price == 0) || acquired {
+	subscribed, err := hasActiveSubscription(c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("processWarmupGroup: %w", err)
+	}
+
+	if (price == 0) || acquired || subscribed {
 		err := userInlineMenus.Show(c, WarmupsMenu)
 		if err != nil {
 			return fmt.Errorf("processWarmups: SendMessageToUser: %w", err)
@@ -215,23 +457,9 @@ func processWarmupGroup(c tele.Context, warmupGroupID string) error {
 		return c.Respond()
 	}
 
-	return c.Send("Пока недоступно!")
-	/*
-		invoice := &tele.Invoice{
-			Title:       "Покупка пакета распевок",
-			Description: "Пакет распевок '" + warmupGroupName + "'",
-			Payload:     WarmupPayloadChecker + PayloadSplit + warmupGroupID,
-			Currency:    "RUB",
-			Prices: []tele.Price{
-				{
-					Label:  "RUB",
-					Amount: price * 100,
-				},
-			},
-			Token: ProviderToken,
-		}
-		return c.Send(invoice)
-	*/
+	// promo code of previously selected group is not relevant
+	userFSM.SetStateVar(c.Sender().ID, "promoCode", "")
+	return userInlineMenus.Show(c, WarmupPurchaseMenu)
 }
 
 func showWarmup(c tele.Context, warmupID string) error {
@@ -239,20 +467,31 @@ func showWarmup(c tele.Context, warmupID string) error {
 	userID := c.Sender().ID
 	err := DB.QueryRow(context.Background(), `
 		SELECT record_id FROM warmups
-		/*INNER JOIN (
-		    SELECT group_id FROM acquired_warmup_groups
-		    WHERE user_id = $1
-		) AS payment_check ON payment_check.group_id = warmups.warmup_group
-		*/
-		WHERE warmup_id = $1`, /*userID,*/ warmupID).Scan(&recordID)
-	/*
-		if err == pgx.ErrNoRows {
-			return c.Send("☝️Ай-яй-яй! Распевка не найдена... Возможно, теперь она входит в платный пакет!")
-		}
-	*/
+		INNER JOIN warmup_groups ON warmup_groups.warmup_group_id = warmups.warmup_group
+		WHERE warmup_id = $2 AND (
+		    price = 0 OR EXISTS(
+		        SELECT 1 FROM acquired_warmup_groups
+		        WHERE user_id = $1 AND group_id = warmups.warmup_group
+		    ) OR EXISTS(
+		        SELECT 1 FROM subscriptions
+		        WHERE user_id = $1
+		            AND start_dt <= now() AT TIME ZONE 'UTC'
+		            AND expire_dt + $3 * INTERVAL '1 second' > now() AT TIME ZONE 'UTC'))`,
+		userID, warmupID, subscriptionGracePeriod.Seconds()).Scan(&recordID)
+	if err == pgx.ErrNoRows {
+		return c.Send("☝️Ай-яй-яй! Распевка не найдена... Возможно, теперь она входит в платный пакет!")
+	}
 
 	if err != nil {
 		return fmt.Errorf("processWarmupGroup: can't select row: %w", err)
 	}
-	return SendMessageToUser(c.Bot(), userID, recordID, true)
+	err = SendMessageToUser(c.Bot(), userID, recordID, true)
+	if err != nil {
+		return err
+	}
+	if err = recordPractice(c.Bot(), userID, warmupID); err != nil {
+		logger.Error("can't record practice", zap.Int64("userID", userID), zap.String("warmupID", warmupID), zap.Error(err))
+	}
+	publishAchievementEvent(c.Bot(), achievementEvent{Type: EventWarmupOpened, UserID: userID, WarmupID: warmupID})
+	return nil
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// botAPICall is a request received by fakeBotAPI
+type botAPICall struct {
+	Method string
+	Params map[string]interface{}
+}
+
+// fakeBotAPI is a telegram Bot API server, it accepts every request and records it
+type fakeBotAPI struct {
+	mu    sync.Mutex
+	calls []botAPICall
+}
+
+func (api *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := botAPICall{Method: r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]}
+	_ = json.NewDecoder(r.Body).Decode(&call.Params)
+	api.mu.Lock()
+	api.calls = append(api.calls, call)
+	api.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if call.Method == "sendMessage" {
+		chatID, _ := strconv.ParseInt(call.Params["chat_id"].(string), 10, 64)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":     true,
+			"result": map[string]interface{}{"message_id": 1, "chat": map[string]interface{}{"id": chatID}},
+		})
+		return
+	}
+	_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+}
+
+// take returns recorded calls and forgets them
+func (api *fakeBotAPI) take() []botAPICall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	calls := api.calls
+	api.calls = nil
+	return calls
+}
+
+func newTestBot(t *testing.T) (*tele.Bot, *fakeBotAPI) {
+	t.Helper()
+	logger = zap.NewNop()
+	api := &fakeBotAPI{}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	b, err := tele.NewBot(tele.Settings{URL: srv.URL, Token: "test", Offline: true, Synchronous: true})
+	if err != nil {
+		t.Fatalf("NewBot: %v", err)
+	}
+	return b, api
+}
+
+func checkoutContext(b *tele.Bot, userID int64, payload string, total int) tele.Context {
+	return b.NewContext(tele.Update{PreCheckoutQuery: &tele.PreCheckoutQuery{
+		ID:       "query" + strconv.Itoa(total),
+		Sender:   &tele.User{ID: userID},
+		Currency: PaymentCurrency,
+		Payload:  payload,
+		Total:    total,
+	}})
+}
+
+func paymentContext(b *tele.Bot, userID int64, payload string, total int, chargeID string) tele.Context {
+	return b.NewContext(tele.Update{Message: &tele.Message{
+		Sender: &tele.User{ID: userID},
+		Chat:   &tele.Chat{ID: userID},
+		Payment: &tele.Payment{
+			Currency:         PaymentCurrency,
+			Total:            total,
+			Payload:          payload,
+			TelegramChargeID: chargeID,
+			ProviderChargeID: "provider-" + chargeID,
+		},
+	}})
+}
+
+// checkAnswer checks that the only call is answer to pre-checkout query. errText is "" if checkout must be accepted
+func checkAnswer(t *testing.T, calls []botAPICall, errText string) {
+	t.Helper()
+	if len(calls) != 1 || calls[0].Method != "answerPreCheckoutQuery" {
+		t.Fatalf("calls = %+v; want single answerPreCheckoutQuery", calls)
+	}
+	params := calls[0].Params
+	if errText == "" {
+		if params["ok"] != "true" {
+			t.Fatalf("checkout is rejected: %v", params)
+		}
+		return
+	}
+	if params["ok"] == "true" || params["error_message"] != errText {
+		t.Fatalf("answer = %v; want rejection '%s'", params, errText)
+	}
+}
+
+// sentTexts returns texts of sent messages
+func sentTexts(calls []botAPICall) []string {
+	var texts []string
+	for _, call := range calls {
+		if call.Method == "sendMessage" {
+			texts = append(texts, call.Params["text"].(string))
+		}
+	}
+	return texts
+}
+
+func TestOnUserCheckoutWrongPayload(t *testing.T) {
+	b, api := newTestBot(t)
+	if err := onUserCheckout(checkoutContext(b, 1, "unknown|1", 100)); err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, api.take(), PaymentErrorText)
+}
+
+// TestWarmupGroupPayment runs against database from TEST_POSTGRES_URL, schema is created there
+func TestWarmupGroupPayment(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	logger = zap.NewNop()
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatalf("pgxpool.New: %v", err)
+	}
+	defer db.Close()
+	createSchema(db)
+	DB = db
+
+	const userID = -1000 // negative ids don't clash with telegram users
+	ctx := context.Background()
+	cleanup := func() {
+		for _, q := range []string{
+			"DELETE FROM acquired_warmup_groups WHERE user_id = $1",
+			"DELETE FROM duplicate_payments WHERE user_id = $1",
+			"DELETE FROM user_achievements WHERE user_id = $1",
+			"DELETE FROM users WHERE user_id = $1",
+		} {
+			if _, err := db.Exec(ctx, q, userID); err != nil {
+				t.Fatalf("cleanup: %v", err)
+			}
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO users(user_id, timezone_txt, join_dt)
+		VALUES ($1, 'UTC', now())`, userID)
+	if err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	var groupID int
+	err = db.QueryRow(ctx, `
+		INSERT INTO warmup_groups(group_name, price)
+		VALUES ('test group', 100)
+		RETURNING warmup_group_id`).Scan(&groupID)
+	if err != nil {
+		t.Fatalf("insert warmup group: %v", err)
+	}
+	defer db.Exec(ctx, "DELETE FROM warmup_groups WHERE warmup_group_id = $1", groupID)
+
+	b, api := newTestBot(t)
+	payload := WarmupPayloadChecker + PayloadSplit + strconv.Itoa(groupID)
+	const total = 100 * 100
+
+	// pre-checkout
+	if err = onUserCheckout(checkoutContext(b, userID, payload, total+1)); err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, api.take(), "Цена изменилась, попробуй купить пакет распевок еще раз!")
+	if err = onUserCheckout(checkoutContext(b, userID, payload, total)); err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, api.take(), "")
+
+	// successful payment
+	if err = onUserPayment(paymentContext(b, userID, payload, total, "charge1")); err != nil {
+		t.Fatal(err)
+	}
+	texts := sentTexts(api.take())
+	if len(texts) == 0 || !strings.Contains(texts[0], "Номер платежа: charge1") {
+		t.Fatalf("sent %q; want receipt first", texts)
+	}
+	var acquired int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM acquired_warmup_groups
+		WHERE user_id = $1 AND group_id = $2`, userID, groupID).Scan(&acquired)
+	if err != nil || acquired != 1 {
+		t.Fatalf("acquired %d groups, %v; want 1", acquired, err)
+	}
+
+	// group can't be bought again
+	if err = onUserCheckout(checkoutContext(b, userID, payload, total)); err != nil {
+		t.Fatal(err)
+	}
+	checkAnswer(t, api.take(), "Этот пакет распевок уже куплен!")
+
+	// the same payment delivered again is ignored
+	if err = onUserPayment(paymentContext(b, userID, payload, total, "charge1")); err != nil {
+		t.Fatal(err)
+	}
+	if texts = sentTexts(api.take()); len(texts) != 0 {
+		t.Fatalf("sent %q on repeated payment; want nothing", texts)
+	}
+
+	// another charge for the acquired group (concurrent checkouts) is saved for refund
+	if err = onUserPayment(paymentContext(b, userID, payload, total, "charge2")); err != nil {
+		t.Fatal(err)
+	}
+	if texts = sentTexts(api.take()); len(texts) != 1 || texts[0] != PaymentDuplicateText {
+		t.Fatalf("sent %q on duplicate charge; want '%s'", texts, PaymentDuplicateText)
+	}
+	var duplicates int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM duplicate_payments
+		WHERE user_id = $1 AND checkout_id = 'charge2'`, userID).Scan(&duplicates)
+	if err != nil || duplicates != 1 {
+		t.Fatalf("duplicate payments = %d, %v; want 1", duplicates, err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"vocal_training_bot/BotExt"
 
@@ -15,6 +16,9 @@ var (
 	MainUserMenuOptions = []string{
 		"Упражнения",
 		"Напоминания",
+		"Мой прогресс",
+		"Подписка",
+		"Подарочный сертификат",
 		"Записаться на урок",
 		"Обо мне",
 		"Настройки аккаунта",
@@ -27,6 +31,20 @@ const (
 	WarmupNotificationsMenu = "WarmupNotificationsMenu"
 	WarmupGroupsMenu        = "WarmupGroupsMenu"
 	WarmupsMenu             = "WarmupsMenu"
+	SubscriptionPlansMenu   = "SubscriptionPlansMenu"
+	WarmupPurchaseMenu      = "WarmupPurchaseMenu"
+	GiftCertificatesMenu    = "GiftCertificatesMenu"
+	LessonSlotsMenu         = "LessonSlotsMenu"
+	LessonBookingMenu       = "LessonBookingMenu"
+	LessonPackagesMenu      = "LessonPackagesMenu"
+	WarmupDayTimesMenu      = "WarmupDayTimesMenu"
+	AchievementsMenu        = "AchievementsMenu"
+	// WarmupReminderMessage is not a menu: buttons of reminder message are routed as dynamic menu buttons
+	WarmupReminderMessage = "WarmupReminder"
+
+	redeemGiftCertificateButton = "redeem"
+	lessonRequestButton         = "request"
+	addReminderTimeButton       = "add"
 )
 
 var (
@@ -47,7 +65,7 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 		Unique:         "Cancel",
 		TextOnCreation: "Отмена",
 		OnClick: func(c tele.Context) error {
-			BotExt.ResetState(c.Sender().ID, false)
+			userFSM.ResetState(c.Sender().ID, false)
 			if err := c.Send("OK", MainUserMenu); err != nil {
 				logger.Error("can't send OK button", zap.Int64("userID", c.Sender().ID), zap.Error(err))
 			}
@@ -67,12 +85,17 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 			if err != nil {
 				return nil, err
 			}
+			lessons, err := lessonBalance(c.Sender().ID)
+			if err != nil {
+				return nil, err
+			}
 			data := map[string]string{
 				"name": name,
 				//"age":        age,
 				"city":     city,
 				"timezone": tz,
 				//"experience": xp,
+				"lessons": strconv.Itoa(lessons),
 			}
 			return data, nil
 		},
@@ -134,6 +157,22 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 				return c.Respond()
 			},
 		},
+		{
+			Unique: "LessonBalance",
+			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+				s, ok := dc["lessons"]
+				if !ok {
+					return "Баланс занятий неизвестен", fmt.Errorf("can't fetch lessons")
+				}
+				return "Занятий на балансе: " + s + " (пополнить)", nil
+			},
+			OnClick: func(c tele.Context) error {
+				if err := userInlineMenus.Show(c, LessonPackagesMenu); err != nil {
+					logger.Error("can't show lesson packages", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
 		/*{
 			Unique: "ChangeExperience",
 			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
@@ -160,7 +199,8 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 		`Здесь ты можешь настроить напоминалки о самостоятельных занятиях 📩
 🔔 - включить напоминание
 🔕 - отключить напоминание
-🕐 в окошках со временем ты можешь изменить время отправки напоминания
+🕐 в окошках со временем ты можешь добавить или удалить напоминания на этот день, их может быть несколько
+🔁 - как часто присылать напоминания: по выбранным дням недели или, например, через день
 `,
 		2,
 		WarmupNotificationsMenuDataFetcher,
@@ -181,6 +221,31 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 		sat[0], sat[1],
 		sun[0], sun[1],
 		{Unique: BotExt.RowSplitterButton},
+		{
+			Unique: "NotificationInterval",
+			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+				v, ok := dc["intervalDays"]
+				if !ok {
+					return "🔁 ???", fmt.Errorf("can't fetch intervalDays")
+				}
+				days, err := strconv.Atoi(v)
+				if err != nil {
+					return "🔁 ???", err
+				}
+				return reminderIntervalText(days), nil
+			},
+			OnClick: func(c tele.Context) error {
+				userID := c.Sender().ID
+				if err := switchReminderInterval(userID); err != nil {
+					logger.Error("can't switch reminder interval", zap.Int64("userID", userID), zap.Error(err))
+				}
+				userInlineMenus.Update(c, WarmupNotificationsMenu)
+				if err := rescheduleWarmupNotifications(userID); err != nil {
+					logger.Error("can't switch reminder interval", zap.Int64("userID", userID), zap.Error(err))
+				}
+				return c.Respond()
+			}},
+		{Unique: BotExt.RowSplitterButton},
 		{
 			Unique: "GlobalSwitch",
 			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
@@ -225,6 +290,26 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 		panic(err)
 	}
 
+	achievementsIM := BotExt.NewDynamicInlineMenu(
+		AchievementsMenu,
+		"Достижения 🏅 Нажми на значок, чтобы узнать подробности",
+		1,
+		achievementsFetcher)
+	err = userInlineMenus.RegisterMenu(bot, achievementsIM)
+	if err != nil {
+		panic(err)
+	}
+
+	warmupDayTimesIM := BotExt.NewDynamicInlineMenu(
+		WarmupDayTimesMenu,
+		"Напоминания на этот день недели. Нажми на время, чтобы удалить напоминание",
+		1,
+		warmupDayTimesFetcher)
+	err = userInlineMenus.RegisterMenu(bot, warmupDayTimesIM)
+	if err != nil {
+		panic(err)
+	}
+
 	warmupGroupsIM := BotExt.NewDynamicInlineMenu(
 		WarmupGroupsMenu,
 		"Категории:",
@@ -245,6 +330,141 @@ func SetupUserMenuHandlers(bot *tele.Bot) {
 	if err != nil {
 		panic(err)
 	}
+
+	warmupPurchaseIM := BotExt.NewInlineMenu(
+		WarmupPurchaseMenu,
+		"Этот пакет распевок платный. Если у тебя есть промокод - введи его перед оплатой",
+		1,
+		warmupPurchaseDataFetcher,
+	)
+	warmupPurchaseIM.AddButtons([]*BotExt.InlineButtonTemplate{
+		{
+			Unique: "PayWarmupGroup",
+			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+				price, ok := dc["price"]
+				if !ok {
+					return "💳 Оплатить", fmt.Errorf("can't fetch price")
+				}
+				return "💳 Оплатить " + price, nil
+			},
+			OnClick: func(c tele.Context) error {
+				values := userFSM.GetStateVars(c.Sender().ID)
+				groupID, ok := values["selectedWarmupGroup"]
+				if !ok {
+					logger.Error("can't fetch selectedWarmupGroup", zap.Int64("userID", c.Sender().ID))
+					return c.Respond()
+				}
+				acquired, err := warmupGroupAcquired(c.Sender().ID, groupID)
+				if err != nil {
+					logger.Error("can't check acquired warmup", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+					return c.Respond()
+				}
+				if acquired {
+					if err = c.Send(WarmupAcquiredText); err != nil {
+						logger.Error("can't send message", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+					}
+					return c.Respond()
+				}
+				if err = sendWarmupInvoice(c, groupID, values["promoCode"]); err != nil {
+					logger.Error("can't send invoice", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
+		{
+			Unique:         "EnterPromoCode",
+			TextOnCreation: "🏷 Ввести промокод",
+			OnClick: func(c tele.Context) error {
+				userFSM.Trigger(c, PromoCodeSGEnter, WarmupPurchaseMenu)
+				return c.Respond()
+			},
+		},
+		cancelButton,
+	})
+	err = userInlineMenus.RegisterMenu(bot, warmupPurchaseIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonSlotsIM := BotExt.NewDynamicInlineMenu(
+		LessonSlotsMenu,
+		`Я преподаю вокал в Москве и онлайн в любой точке мира 🤍
+🏢 Занятие на студии длится 60 мин, 💻 онлайн - 90 мин
+
+Выбери свободное окошко (время указано по твоему часовому поясу):`,
+		1,
+		lessonSlotsFetcher)
+	err = userInlineMenus.RegisterMenu(bot, lessonSlotsIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonPackagesIM := BotExt.NewDynamicInlineMenu(
+		LessonPackagesMenu,
+		"Пакеты занятий вокалом. Занятия с баланса списываются после урока:",
+		1,
+		lessonPackagesFetcher)
+	err = userInlineMenus.RegisterMenu(bot, lessonPackagesIM)
+	if err != nil {
+		panic(err)
+	}
+
+	lessonBookingIM := BotExt.NewInlineMenu(
+		LessonBookingMenu,
+		"Подтверди запись на урок",
+		1,
+		lessonBookingDataFetcher,
+	)
+	lessonBookingIM.AddButtons([]*BotExt.InlineButtonTemplate{
+		{
+			Unique: "ConfirmLessonBooking",
+			TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
+				slot, ok := dc["slot"]
+				if !ok {
+					return "✅ Записаться", fmt.Errorf("can't fetch slot")
+				}
+				return "✅ Записаться: " + slot, nil
+			},
+			OnClick: func(c tele.Context) error {
+				slotID, ok := userFSM.GetStateVar(c.Sender().ID, "selectedSlot")
+				if !ok {
+					logger.Error("can't fetch selectedSlot", zap.Int64("userID", c.Sender().ID))
+					return c.Respond()
+				}
+				if err := bookLessonSlot(c, slotID); err != nil {
+					logger.Error("can't book lesson slot", zap.Int64("userID", c.Sender().ID), zap.Error(err))
+				}
+				return c.Respond()
+			},
+		},
+		cancelButton,
+	})
+	err = userInlineMenus.RegisterMenu(bot, lessonBookingIM)
+	if err != nil {
+		panic(err)
+	}
+
+	giftCertificatesIM := BotExt.NewDynamicInlineMenu(
+		GiftCertificatesMenu,
+		`🎁 Подари близким занятия вокалом или пакет распевок!
+После оплаты ты получишь код сертификата - перешли его получателю. Сертификат действует два месяца.
+Если тебе подарили сертификат - нажми 'Активировать сертификат'`,
+		1,
+		giftCertificatesFetcher)
+	err = userInlineMenus.RegisterMenu(bot, giftCertificatesIM)
+	if err != nil {
+		panic(err)
+	}
+
+	subscriptionPlansIM := BotExt.NewDynamicInlineMenu(
+		SubscriptionPlansMenu,
+		"Тарифы:",
+		1,
+		subscriptionPlansFetcher)
+	err = userInlineMenus.RegisterMenu(bot, subscriptionPlansIM)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func WarmupNotificationsMenuDataFetcher(c tele.Context) (map[string]string, error) {
@@ -252,8 +472,15 @@ func WarmupNotificationsMenuDataFetcher(c tele.Context) (map[string]string, erro
 				SELECT 
 				    day_of_week,
     				cast(trigger_switch AS varchar(5)), 
-       				to_char(trigger_time,'HH24:MI')
-				FROM warmup_notifications WHERE user_id = $1`, c.Sender().ID)
+       				COALESCE(times, '')
+				FROM warmup_notifications
+				LEFT JOIN (
+					SELECT user_id, day_of_week, string_agg(to_char(trigger_time,'HH24:MI'), ', ' ORDER BY trigger_time) AS times
+					FROM warmup_reminder_times
+					WHERE user_id = $1
+					GROUP BY user_id, day_of_week
+				) reminder_times USING (user_id, day_of_week)
+				WHERE user_id = $1`, c.Sender().ID)
 	defer rows.Close()
 	if err != nil {
 		return nil, err
@@ -272,14 +499,15 @@ func WarmupNotificationsMenuDataFetcher(c tele.Context) (map[string]string, erro
 		return data, fmt.Errorf("WarmupNotificationsMenuDataFetcher: postgres itetator %w", err)
 	}
 
-	var globalSwitch string
+	var globalSwitch, intervalDays string
 	err = DB.QueryRow(context.Background(),
-		`SELECT cast(global_switch AS varchar(5)) FROM warmup_notification_global WHERE user_id = $1`,
-		c.Sender().ID).Scan(&globalSwitch)
+		`SELECT cast(global_switch AS varchar(5)), interval_days::text FROM warmup_notification_global WHERE user_id = $1`,
+		c.Sender().ID).Scan(&globalSwitch, &intervalDays)
 	if err != nil {
 		return data, err
 	}
 	data["globalOn"] = globalSwitch
+	data["intervalDays"] = intervalDays
 
 	return data, nil
 }
@@ -311,35 +539,33 @@ func NotificationButtonFabric(fsm *BotExt.FSM, ims *BotExt.InlineMenusType, dayU
 			}
 			ims.Update(c, WarmupNotificationsMenu)
 
-			ts, err := getNearestNotificationFromPg(userID)
-			if err != nil {
-				logger.Error("can't switch notifications for day",
-					zap.Int64("userID", userID), zap.String("dayUnique", dayUnique), zap.Error(err))
-			}
-			if err = notificationService.DelUser(userID); err != nil {
-				logger.Error("can't switch notifications for day",
-					zap.Int64("userID", userID), zap.String("dayUnique", dayUnique), zap.Error(err))
-			}
-			if err = notificationService.addUser(userID, ts); err != nil {
+			if err = rescheduleWarmupNotifications(userID); err != nil {
 				logger.Error("can't switch notifications for day",
 					zap.Int64("userID", userID), zap.String("dayUnique", dayUnique), zap.Error(err))
 			}
 			return c.Respond()
 		},
 	}
-	// set time
+	// reminder times of the day
 	ibt[1] = &BotExt.InlineButtonTemplate{
 		Unique: "NotificationTime_" + dayUnique,
 		TextOnCreation: func(c tele.Context, dc map[string]string) (string, error) {
-			time, ok := dc[dayUnique+"Time"]
+			times, ok := dc[dayUnique+"Time"]
 			if !ok {
 				return "HH:MM", fmt.Errorf("can't fetch %sTime", dayUnique)
 			}
-			return time, nil
+			if times == "" {
+				return "➕ время", nil
+			}
+			return times, nil
 		},
 		OnClick: func(c tele.Context) error {
-			BotExt.SetStateVar(c.Sender().ID, "day", dayUnique)
-			fsm.Trigger(c, NotificationSGSetTime, WarmupNotificationsMenu)
+			userFSM.SetStateVar(c.Sender().ID, "day", dayUnique)
+			err := ims.Show(c, WarmupDayTimesMenu)
+			if err != nil {
+				logger.Error("can't show reminder times", zap.Int64("userID", c.Sender().ID),
+					zap.String("dayUnique", dayUnique), zap.Error(err))
+			}
 			return c.Respond()
 		},
 	}
@@ -361,6 +587,10 @@ func warmupGroupsFetcher(c tele.Context) (*om.OrderedMap[string, string], error)
 	if err != nil {
 		return nil, fmt.Errorf("warmupGroupsFetcher: can't fetch database: %w", err)
 	}
+	subscribed, err := hasActiveSubscription(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("warmupGroupsFetcher: %w", err)
+	}
 	omap := om.New[string, string]()
 
 	var unique, text, price string
@@ -371,14 +601,15 @@ func warmupGroupsFetcher(c tele.Context) (*om.OrderedMap[string, string], error)
 			return omap, fmt.Errorf("warmupGroupsFetcher: can't fetch row: %w", err)
 		}
 		var priceText string
-		if (price == "0") && !acquired {
+		switch {
+		case (price == "0") && !acquired:
 			priceText = "🎁 бесплатно"
-		} else {
-			if acquired {
-				priceText = "🤑 куплено"
-			} else {
-				priceText = "💳 " + price + " рублей"
-			}
+		case acquired:
+			priceText = "🤑 куплено"
+		case subscribed:
+			priceText = "⭐ по подписке"
+		default:
+			priceText = "💳 " + price + " рублей"
 		}
 		text := fmt.Sprintf("%s [%s]", text, priceText)
 		omap.Set(unique, text)
@@ -396,7 +627,7 @@ func warmupGroupsFetcher(c tele.Context) (*om.OrderedMap[string, string], error)
 }
 
 func warmupsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
-	groupID, ok := BotExt.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+	groupID, ok := userFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
 	if !ok {
 		return nil, fmt.Errorf("warmupsFetcher: can't get var selectedWarmupGroup")
 	}
@@ -430,3 +661,193 @@ func warmupsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
 
 	return omap, nil
 }
+
+func warmupPurchaseDataFetcher(c tele.Context) (map[string]string, error) {
+	values := userFSM.GetStateVars(c.Sender().ID)
+	groupID, ok := values["selectedWarmupGroup"]
+	if !ok {
+		return nil, fmt.Errorf("warmupPurchaseDataFetcher: can't get var selectedWarmupGroup")
+	}
+	fullAmount, amount, promo, err := warmupGroupAmount(groupID, values["promoCode"])
+	if err != nil {
+		return nil, fmt.Errorf("warmupPurchaseDataFetcher: %w", err)
+	}
+
+	price := fmt.Sprintf("%d рублей", amount/100)
+	if promo != nil {
+		price = fmt.Sprintf("%d → %d рублей (%s %s)", fullAmount/100, amount/100, promo.Code, promo.String())
+	}
+	return map[string]string{"price": price}, nil
+}
+
+func subscriptionPlansFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT plan_id::text, plan_name, period_days, price FROM subscription_plans
+		WHERE active = true
+		ORDER BY period_days`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("subscriptionPlansFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var planID, planName string
+	var periodDays, price int
+	for rows.Next() {
+		err = rows.Scan(&planID, &planName, &periodDays, &price)
+		if err != nil {
+			return omap, fmt.Errorf("subscriptionPlansFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(planID, fmt.Sprintf("%s [%d дн. - 💳 %d рублей]", planName, periodDays, price))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Подписка пока недоступна... Скоро тут будет много интересного!")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+func giftCertificatesFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT type_id::text, title, price FROM gift_certificate_types
+		WHERE active = true
+		ORDER BY price`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("giftCertificatesFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var typeID, title string
+	var price int
+	for rows.Next() {
+		err = rows.Scan(&typeID, &title, &price)
+		if err != nil {
+			return omap, fmt.Errorf("giftCertificatesFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(typeID, fmt.Sprintf("🎁 %s [💳 %d рублей]", title, price))
+	}
+	omap.Set(redeemGiftCertificateButton, "🎟 Активировать сертификат")
+
+	return omap, nil
+}
+
+func lessonSlotsFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	loc, err := userLocation(c.Sender().ID)
+	if err != nil {
+		return nil, fmt.Errorf("lessonSlotsFetcher: %w", err)
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT slot_id::text, start_dt, duration_min, format FROM lesson_slots
+		WHERE booked_by IS NULL AND start_dt > now() AT TIME ZONE 'UTC'
+		ORDER BY start_dt
+		LIMIT 30`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("lessonSlotsFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	for rows.Next() {
+		s := lessonSlot{}
+		err = rows.Scan(&s.SlotID, &s.StartDT, &s.Duration, &s.Format)
+		if err != nil {
+			return omap, fmt.Errorf("lessonSlotsFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(s.SlotID, s.Text(loc))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Свободных окошек для уроков пока нет 🙏 Оставь заявку, и мы что-нибудь придумаем!")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+	}
+	omap.Set(lessonRequestButton, "✍️ Оставить заявку")
+
+	return omap, nil
+}
+
+func lessonBookingDataFetcher(c tele.Context) (map[string]string, error) {
+	userID := c.Sender().ID
+	slotID, ok := userFSM.GetStateVar(userID, "selectedSlot")
+	if !ok {
+		return nil, fmt.Errorf("lessonBookingDataFetcher: can't get var selectedSlot")
+	}
+	s, err := getLessonSlot(slotID)
+	if err != nil {
+		return nil, fmt.Errorf("lessonBookingDataFetcher: %w", err)
+	}
+	loc, err := userLocation(userID)
+	if err != nil {
+		return nil, fmt.Errorf("lessonBookingDataFetcher: %w", err)
+	}
+	return map[string]string{"slot": s.Text(loc)}, nil
+}
+
+func lessonPackagesFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	rows, err := DB.Query(context.Background(), `
+		SELECT package_id::text, title, lessons, price FROM lesson_packages
+		WHERE active = true
+		ORDER BY lessons`)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("lessonPackagesFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var packageID, title string
+	var lessons, price int
+	for rows.Next() {
+		err = rows.Scan(&packageID, &title, &lessons, &price)
+		if err != nil {
+			return omap, fmt.Errorf("lessonPackagesFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(packageID, fmt.Sprintf("%s [%d зан. - 💳 %d рублей]", title, lessons, price))
+	}
+
+	if omap.Len() == 0 {
+		err = c.Send("Пакетов занятий пока нет. Чтобы оплатить занятия, напиши мне в личку @vershkovaaa")
+		if err != nil {
+			logger.Error("can't send message", zap.Error(err))
+		}
+		return nil, BotExt.NoButtons
+	}
+
+	return omap, nil
+}
+
+// warmupDayTimesFetcher returns reminder times of the day from state var "day"
+func warmupDayTimesFetcher(c tele.Context) (*om.OrderedMap[string, string], error) {
+	day, ok := userFSM.GetStateVar(c.Sender().ID, "day")
+	if !ok {
+		return nil, fmt.Errorf("warmupDayTimesFetcher: can't get var day")
+	}
+	rows, err := DB.Query(context.Background(), `
+		SELECT to_char(trigger_time,'HH24:MI') FROM warmup_reminder_times
+		WHERE user_id = $1 AND day_of_week = $2
+		ORDER BY trigger_time`, c.Sender().ID, day)
+	defer rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("warmupDayTimesFetcher: can't fetch database: %w", err)
+	}
+	omap := om.New[string, string]()
+
+	var clock string
+	for rows.Next() {
+		err = rows.Scan(&clock)
+		if err != nil {
+			return omap, fmt.Errorf("warmupDayTimesFetcher: can't fetch row: %w", err)
+		}
+		omap.Set(clock, "❌ "+clock)
+	}
+	if omap.Len() < maxRemindersPerDay {
+		omap.Set(addReminderTimeButton, "➕ Добавить время")
+	}
+	return omap, nil
+}
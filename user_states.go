@@ -37,6 +37,10 @@ const (
 	NotificationSGSetTime = "NotificationStateGroup_SetTime"
 
 	WannabeStudentSGSendReq = "WannabeStudentSG_SendReq"
+
+	PromoCodeSGEnter = "PromoCodeSG_Enter"
+
+	GiftCertificateSGRedeem = "GiftCertificateSG_Redeem"
 )
 
 func SetupUserStates(fsm *BotExt.FSM) {
@@ -66,10 +70,12 @@ func SetupUserStates(fsm *BotExt.FSM) {
 			Manipulator: citySaver,
 		},
 		{
-			Name:        surveySGSetTimezone,
-			OnTrigger:   "(3/3) Сколько сейчас времени по твоим часам? Надо написать часы:минуты, например, 23:15. Это надо чтобы понять в каком часовом поясе ты находишься.",
-			Validator:   timeValidator,
-			Manipulator: timezoneSaver,
+			Name: surveySGSetTimezone,
+			OnTrigger: `(3/3) В каком ты часовом поясе? Отправь свое местоположение, выбери город из списка или напиши название часового пояса, например Asia/Kathmandu.
+Если не получается - напиши, сколько сейчас времени по твоим часам, например, 23:15`,
+			OnTriggerExtra: []interface{}{timezoneMenu},
+			Validator:      timezoneValidator,
+			Manipulator:    timezoneSaver,
 			OnSuccess: `Спасибо! Ты зарегистрирован в системе бота и теперь тебе доступна его функциональность!
 В главном меню ты найдёшь упражнения, распевки, напоминания и полезные материалы 🤍
 ⚠️ Если главное меню не открывается, нажми на иконку 🎛 в правом нижнем углу`,
@@ -144,23 +150,32 @@ func SetupUserStates(fsm *BotExt.FSM) {
 	}
 
 	err = fsm.RegisterOneShotState(&BotExt.State{
-		Name:      SettingsSGSetTimezone,
-		OnTrigger: "Введи свое время (в формате ЧЧ:ММ, например 12:15 или 9:15)",
-		Validator: timeValidator,
+		Name: SettingsSGSetTimezone,
+		OnTrigger: `Отправь свое местоположение, выбери город из списка, напиши название часового пояса, например Asia/Kathmandu,
+или напиши свое время в формате ЧЧ:ММ, например 12:15 или 9:15`,
+		OnTriggerExtra: []interface{}{timezoneMenu},
+		Validator:      timezoneValidator,
 		Manipulator: func(c tele.Context) (err error) {
-			userHoursMinutes := strings.Split(c.Text(), ":")
-			userHours, _ := strconv.Atoi(userHoursMinutes[0])
-			userMinutes, _ := strconv.Atoi(userHoursMinutes[1])
-			utcTimezone, utcMinutesShift, err := calcTimezoneByTimeShift(userHours, userMinutes)
+			zone, tzRaw, tzTxt, err := resolveTimezone(c)
+			if err != nil {
+				return
+			}
 			_, err = DB.Exec(context.Background(), `
 				UPDATE users
-				SET timezone_txt = $1, timezone_raw = $2
-				WHERE user_id = $3
-				`, utcTimezone, utcMinutesShift, c.Sender().ID)
+				SET timezone = $1, timezone_txt = $2, timezone_raw = $3
+				WHERE user_id = $4
+				`, zone, tzTxt, tzRaw, c.Sender().ID)
 			if err != nil {
 				return
 			}
-			return c.Send(fmt.Sprintf("Получается, твой часовой пояс - %s", utcTimezone))
+			// notifications are scheduled in user's timezone
+			if err = notificationService.DelUser(c.Sender().ID); err != nil {
+				return
+			}
+			if err = notificationService.AddUser(c.Sender().ID); err != nil {
+				return
+			}
+			return c.Send(timezoneSavedText(zone, tzTxt), MainUserMenu)
 		},
 	})
 	if err != nil {
@@ -196,31 +211,19 @@ func SetupUserStates(fsm *BotExt.FSM) {
 		Manipulator: func(c tele.Context) error {
 			userID := c.Sender().ID
 
-			day, ok := BotExt.GetStateVar(userID, "day")
+			day, ok := userFSM.GetStateVar(userID, "day")
 			if !ok {
 				return fmt.Errorf("can't fetch variable 'day' from states table")
 			}
 
-			_, err := DB.Exec(context.Background(), `
-			UPDATE warmup_notifications
-			SET trigger_time = $1
-			WHERE (user_id = $2) AND (day_of_week = $3)`, c.Message().Text, userID, day)
+			err := addReminderTime(userID, day, c.Message().Text)
 			if err != nil {
 				return err
 			}
-
-			ts, err := getNearestNotificationFromPg(userID)
-			if err != nil {
-				return fmt.Errorf("state NotificationSGSetTime: getNearestNotificationFromPg: %w", err)
-			}
-			if err = notificationService.DelUser(userID); err != nil {
-				return fmt.Errorf("state NotificationSGSetTime: DelUser: %w", err)
+			if err = rescheduleWarmupNotifications(userID); err != nil {
+				return fmt.Errorf("state NotificationSGSetTime: %w", err)
 			}
-			if err = notificationService.addUser(userID, ts); err != nil {
-				return fmt.Errorf("state NotificationSGSetTime: addUser: %w", err)
-			}
-
-			return err
+			return nil
 		},
 		OnSuccess: "Отлично! Буду на связи в это время 🤓",
 	})
@@ -229,21 +232,40 @@ func SetupUserStates(fsm *BotExt.FSM) {
 	}
 
 	err = fsm.RegisterOneShotState(&BotExt.State{
-		Name: WannabeStudentSGSendReq,
-		OnTrigger: `Я преподаю вокал в Москве и онлайн в любой точке мира
-
-В первой части урока мы уделяем время тренировке голосовых мышц, координации голоса, теории, вопросам, изучению новых приемов и возможностей нашего голоса 🤓
-Во второй части урока мы поем, кайфуем, разбираем песни, импровизируем и творим музыку здесь и сейчас ✨🎶🤍
-
-Одеваемся на занятия удобно, так как мы много работаем с телом + берём с собой
-бутылочку воды, готовим несколько песен, тексты и, конечно, open mind 🪐🤍
+		Name:           PromoCodeSGEnter,
+		OnTrigger:      "Введи промокод. Если передумал - напиши 'ОТМЕНА'",
+		Validator:      promoCodeValidator,
+		KeepVarsOnQuit: true,
+		Manipulator: func(c tele.Context) error {
+			if strings.ToLower(c.Text()) == "отмена" {
+				return nil
+			}
+			promo, err := getPromoCode(c.Text())
+			if err != nil {
+				return err
+			}
+			userFSM.SetStateVar(c.Sender().ID, "promoCode", promo.Code)
+			return nil
+		},
+		OnSuccess: "Готово! Актуальная цена - на кнопке оплаты",
+	})
+	if err != nil {
+		panic(err)
+	}
 
-🏢 Занятие в Москве 🏢
-Адрес для занятий в Москве: Красный Октябрь, Берсеневская набережная 6 с2, we play music rooms
-Урок длится 60 мин
+	err = fsm.RegisterOneShotState(&BotExt.State{
+		Name:        GiftCertificateSGRedeem,
+		OnTrigger:   "Введи код сертификата, например ABCD-EFGH-JKLM. Если передумал - напиши 'ОТМЕНА'",
+		Validator:   giftCodeValidator,
+		Manipulator: redeemGiftCertificate,
+	})
+	if err != nil {
+		panic(err)
+	}
 
-💻 Занятие онлайн 💻
-Урок длится 90 мин. В онлайне работаем дольше, чем на студии из-за особенностей формата и взаимодействия + закладываем время на косяки связи. Созваниваемся по фейстайм/скайп
+	err = fsm.RegisterOneShotState(&BotExt.State{
+		Name: WannabeStudentSGSendReq,
+		OnTrigger: `Оставь заявку, и я свяжусь с тобой, чтобы подобрать время для урока 🤍
 
 🍨 Цены 🍨
 2000р - стартовое занятие
@@ -251,20 +273,15 @@ func SetupUserStates(fsm *BotExt.FSM) {
 10000р - абонемент на 4 занятия
 *цены на онлайн и оффлайн занятия одинаковы
 
-Чтобы записаться на урок, напишите мне в личные сообщения в телеграме! @vershkovaaa
-
-🎁 Сертификаты 🎁
-
-Декабрь - время милых подарков для своих близких! Если вы хотите их порадовать и дать волшебный пинок для развития своего голоса, проявленности и открытости, вы можете подарить им занятия вокалом со мной 🤍
-Также вы можете попросить их положить вам под ёлку сертификат на одно или несколько занятий к новому году 🎅❤️
-Сертификат работает для всех форматов обучения: онлайн и оффлайн. Действует в течение двух месяцев.
-`,
-		// OnTriggerExtra: []interface{}{wannabeStudentMenu},
-		//Validator:   wannabeStudentValidator,
-		//Manipulator: wannabeStudentManipulator,
-		//OnSuccess:   "Готово!",
-		//OnQuitExtra: []interface{}{MainUserMenu},
+Как с тобой связаться?`,
+		OnTriggerExtra: []interface{}{wannabeStudentMenu},
+		Validator:      wannabeStudentValidator,
+		Manipulator:    wannabeStudentManipulator,
+		OnQuitExtra:    []interface{}{MainUserMenu},
 	})
+	if err != nil {
+		panic(err)
+	}
 }
 
 var (
@@ -339,6 +356,21 @@ func timeValidator(c tele.Context) string {
 	return ""
 }
 
+func promoCodeValidator(c tele.Context) string {
+	if strings.ToLower(c.Text()) == "отмена" {
+		return ""
+	}
+	groupID, ok := userFSM.GetStateVar(c.Sender().ID, "selectedWarmupGroup")
+	if !ok {
+		return "Не могу найти выбранный пакет распевок, выбери его еще раз в меню Упражнения"
+	}
+	promo, err := getPromoCode(c.Text())
+	if err != nil {
+		return "Такого промокода нет. Проверь, правильно ли он написан, и попробуй еще раз"
+	}
+	return promo.applicableTo(groupID)
+}
+
 /*
 func experienceValidator(c tele.Context) string {
 	xpVariant := strings.ToLower(strings.TrimSpace(c.Text()))
@@ -352,14 +384,14 @@ func experienceValidator(c tele.Context) string {
 func nameSaver(c tele.Context) error {
 	name := strings.TrimSpace(c.Text())
 	name = cases.Title(language.Tag{}).String(name)
-	BotExt.SetStateVar(c.Sender().ID, surveySGVarName, name)
+	userFSM.SetStateVar(c.Sender().ID, surveySGVarName, name)
 	return nil
 }
 
 /*
 func ageSaver(c tele.Context) error {
 	age := c.Text()
-	BotExt.SetStateVar(c.Sender().ID, surveySGVarAge, age)
+	userFSM.SetStateVar(c.Sender().ID, surveySGVarAge, age)
 	return nil
 }
 */
@@ -367,32 +399,29 @@ func ageSaver(c tele.Context) error {
 func citySaver(c tele.Context) error {
 	city := strings.TrimSpace(c.Text())
 	city = cases.Title(language.Tag{}).String(city)
-	BotExt.SetStateVar(c.Sender().ID, surveySGVarCity, city)
+	userFSM.SetStateVar(c.Sender().ID, surveySGVarCity, city)
 	return nil
 }
 
 func timezoneSaver(c tele.Context) error {
-	userHoursMinutes := strings.Split(c.Text(), ":")
-	userHours, _ := strconv.Atoi(userHoursMinutes[0])
-	userMinutes, _ := strconv.Atoi(userHoursMinutes[1])
-	utcTimezone, utcMinutesShift, err := calcTimezoneByTimeShift(userHours, userMinutes)
+	zone, tzRaw, tzTxt, err := resolveTimezone(c)
 	if err != nil {
 		return err
 	}
-	_ = c.Send(fmt.Sprintf("Получается, твой часовой пояс - %s", utcTimezone))
+	_ = c.Send(timezoneSavedText(zone, tzTxt))
 
 	userID := c.Sender().ID
 
-	values := BotExt.GetStateVars(userID)
+	values := userFSM.GetStateVars(userID)
 	name, _ := values[surveySGVarName]
 	city, _ := values[surveySGVarCity]
 
 	joinTime := time.Now().UTC()
 
 	_, err = DB.Exec(context.Background(), `
-				INSERT INTO users(user_id, username, city, timezone_raw, timezone_txt, join_dt)
-				VALUES ($1, $2, $3, $4, $5, $6)
-				`, userID, name, city, utcMinutesShift, utcTimezone, joinTime)
+				INSERT INTO users(user_id, username, city, timezone, timezone_raw, timezone_txt, join_dt)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				`, userID, name, city, zone, tzRaw, tzTxt, joinTime)
 	if err != nil {
 		return err
 	}
@@ -423,7 +452,7 @@ func calcTimezoneByTimeShift(userHours, userMinutes int) (utcTimezone string, ut
 		err = fmt.Errorf("calcTimezoneByTimeshift(%d, %d): %w", userHours, userMinutes, err)
 		return
 	}
-	deltaMinutesDur = deltaMinutesDur.Round(30 * time.Minute)
+	deltaMinutesDur = deltaMinutesDur.Round(15 * time.Minute)      // there are +05:45-style zones
 	utcMinutesShift = strconv.Itoa(int(deltaMinutesDur.Minutes())) // save output
 
 	// utcTimezone representation
@@ -447,7 +476,7 @@ func saveSurveyRegisterUser(c tele.Context) error {
 	userID := c.Sender().ID
 
 	xp := strings.ToLower(strings.TrimSpace(c.Text()))
-	values := BotExt.GetStateVars(userID)
+	values := userFSM.GetStateVars(userID)
 	name, _ := values[surveySGVarName]
 	ageTxt, _ := values[surveySGVarAge]
 	age, _ := strconv.Atoi(ageTxt)
@@ -470,56 +499,3 @@ func saveSurveyRegisterUser(c tele.Context) error {
 	return err
 }
 */
-
-/*
-func wannabeStudentValidator(c tele.Context) string {
-	t := c.Text()
-	if t == "" && c.Message().ReplyTo != nil {
-		if c.Message().ReplyTo.Sender.ID != c.Bot().Me.ID {
-			return "Нажми на кнопку 'Позвонить' чтобы поделиться своим контактом"
-		}
-		if c.Message().Contact != nil {
-			return "" // handle contact
-		}
-	}
-	if t == "Отмена" || t == "Написать в личку в телеграм" {
-		return ""
-	}
-	return "Не могу распознать ответ... Выбери вариант из списка"
-}
-
-func wannabeStudentManipulator(c tele.Context) error {
-	if c.Text() == "Отмена" {
-		return nil
-	}
-	userID := c.Sender().ID
-	phone := ""
-	userName := c.Sender().Username
-
-	if c.Text() != "Написать в личку в телеграм" {
-		phone = c.Message().Contact.PhoneNumber
-	}
-
-	var resolved bool
-	err := DB.QueryRow(context.Background(), `
-	SELECT resolved FROM wannabe_student
-	WHERE user_id = $1`, userID).Scan(&resolved)
-	if err != pgx.ErrNoRows {
-		if resolved == true {
-			_ = c.Send("Вы уже подавали заявку и ее рассмотрели... Напиши, пожалуйста, напрямую @vershkovaaa для записи на занятие")
-			return nil
-		}
-		if resolved == false {
-			_ = c.Send("Заявка уже подана, ее рассмотрят в ближайшее время!")
-			return nil
-		}
-		return err
-	}
-
-	_, err = DB.Exec(context.Background(), `
-	INSERT INTO wannabe_student(user_id, user_name, phone_num)
-	VALUES ($1, $2, $3)
-	`, userID, userName, phone)
-	return err
-}
-*/